@@ -0,0 +1,48 @@
+package amqpgraphite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dt/go-metrics"
+	"github.com/dt/go-metrics-graphite"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A fakeChannel records the messages published.
+type fakeChannel struct {
+	exchange, key string
+	msgs          []amqp.Publishing
+}
+
+func (c *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.exchange, c.key = exchange, key
+	msg.Body = append([]byte(nil), msg.Body...)
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	metrics.GetOrRegisterGauge("bar", r).Update(2)
+
+	ch := &fakeChannel{}
+	err := graphite.GraphiteOnce(graphite.GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         &Sink{Exchange: "graphite", RoutingKey: "app", Persistent: true, Channel: ch},
+		SortedOutput: true,
+		Timestamp:    func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "graphite" != ch.exchange || "app" != ch.key || 1 != len(ch.msgs) {
+		t.Fatalf("bad messages: %+v", ch)
+	}
+	m := ch.msgs[0]
+	if expected, found := "app.bar.value 2 1\napp.foo.count 1 1\n", string(m.Body); expected != found || amqp.Persistent != m.DeliveryMode {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
@@ -0,0 +1,56 @@
+package graphite
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCircuitOpen is returned by flushes which did not connect to the server
+// because BreakerFailures dials in a row failed less than BreakerCooldown
+// ago.
+var ErrCircuitOpen = errors.New("graphite: not connecting while the circuit breaker is open")
+
+// A breaker counts the dials which failed in a row. Once there are
+// BreakerFailures of them it opens, failing dials without attempting them
+// until BreakerCooldown has passed. The next dial then probes the server,
+// opening the breaker again right away if it fails too.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time // End of the cooldown while open
+	dropped  uint64    // Datapoints not sent while open, accessed atomically
+}
+
+// allow reports whether a dial may be attempted at now.
+func (b *breaker) allow(c *GraphiteConfig, now time.Time) bool {
+	if 0 >= c.BreakerFailures {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.until)
+}
+
+// record counts the outcome of a dial attempted at now.
+func (b *breaker) record(c *GraphiteConfig, now time.Time, err error) {
+	if 0 >= c.BreakerFailures {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if nil == err {
+		b.failures = 0
+		return
+	}
+	if b.failures++; b.failures >= c.BreakerFailures {
+		b.until = now.Add(c.BreakerCooldown)
+	}
+}
+
+// CircuitDropped returns how many datapoints have not been sent because
+// the circuit breaker was open, see BreakerFailures.
+func (e *Exporter) CircuitDropped() uint64 {
+	return atomic.LoadUint64(&e.breaker.dropped)
+}
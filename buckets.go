@@ -0,0 +1,91 @@
+package graphite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bucketQuantiles are the percentiles the distribution of a sample is read
+// at to estimate the counts of its buckets, the middles of a hundred equal
+// intervals.
+var bucketQuantiles = func() []float64 {
+	qs := make([]float64, 100)
+	for i := range qs {
+		qs[i] = (float64(i) + 0.5) / float64(len(qs))
+	}
+	return qs
+}()
+
+// sortedValues returns the values of s in ascending order.
+func sortedValues(s sampleValues) []float64 {
+	values := s.Values()
+	vs := make([]float64, len(values))
+	for i, v := range values {
+		vs[i] = float64(v)
+	}
+	sort.Float64s(vs)
+	return vs
+}
+
+// bucketCounts returns the estimated counts of the total values in each of
+// the buckets bounded above by bounds, which are scaled by scale, and of
+// those above every bound last. Histograms and timers keep a sample rather
+// than every value, so the share of the values in each bucket is read from
+// vs in ascending order: the values of the sample, or its percentiles at
+// bucketQuantiles, to a hundredth.
+func bucketCounts(vs []float64, total int64, bounds []float64, scale float64) []int64 {
+	counts := make([]int64, len(bounds)+1)
+	if 0 == len(vs) {
+		counts[len(bounds)] = total
+		return counts
+	}
+	below, prev := 0, int64(0)
+	for i, bound := range bounds {
+		for below < len(vs) && vs[below] <= bound*scale {
+			below++
+		}
+		cum := int64(math.Round(float64(total) * float64(below) / float64(len(vs))))
+		counts[i], prev = cum-prev, cum
+	}
+	counts[len(bounds)] = total - prev
+	return counts
+}
+
+// bucketKeys returns the keys of the buckets bounded above by bounds, such
+// as "bucket_100", or "bucket_0_5" for 0.5, followed by "bucket_inf" for
+// the values above every bound.
+func bucketKeys(bounds []float64) []string {
+	if 0 == len(bounds) {
+		return nil
+	}
+	keys := make([]string, 0, len(bounds)+1)
+	for _, b := range bounds {
+		keys = append(keys, "bucket_"+strings.Replace(strconv.FormatFloat(b, 'f', -1, 64), ".", "_", 1))
+	}
+	return append(keys, "bucket_inf")
+}
+
+// cleanBuckets returns a sorted copy of bounds without duplicates, nor
+// bounds which are not finite.
+func cleanBuckets(bounds []float64) []float64 {
+	if nil == bounds {
+		return nil
+	}
+	clean := make([]float64, 0, len(bounds))
+	for _, b := range bounds {
+		if !math.IsNaN(b) && !math.IsInf(b, 0) {
+			clean = append(clean, b)
+		}
+	}
+	sort.Float64s(clean)
+	n := 0
+	for _, b := range clean {
+		if 0 == n || clean[n-1] != b {
+			clean[n] = b
+			n++
+		}
+	}
+	return clean[:n]
+}
@@ -0,0 +1,43 @@
+package graphite
+
+import "time"
+
+// A Clock tells the time and creates tickers for an Exporter, so that tests
+// can control the timestamps of flushes and when they happen.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// A Ticker delivers ticks of a Clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the Clock used when GraphiteConfig.Clock is nil.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// clock returns the configured Clock, or the system clock.
+func (c *GraphiteConfig) clock() Clock {
+	if nil != c.Clock {
+		return c.Clock
+	}
+	return systemClock{}
+}
@@ -0,0 +1,84 @@
+package graphite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// A testClock is a Clock whose time only changes when ticked.
+type testClock struct {
+	now time.Time
+	c   chan time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) NewTicker(d time.Duration) Ticker {
+	return c
+}
+
+func (c *testClock) C() <-chan time.Time {
+	return c.c
+}
+
+func (c *testClock) Stop() {}
+
+func TestClock(t *testing.T) {
+	bodies := make(chan string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	clock := &testClock{now: time.Unix(1000, 0), c: make(chan time.Time)}
+	go NewExporter(GraphiteConfig{
+		Registry:      r,
+		Prefix:        "app",
+		FlushInterval: time.Hour,
+		Transport:     TransportHTTP,
+		URL:           ts.URL,
+		Clock:         clock,
+	}).Run()
+
+	clock.c <- clock.now
+	if expected, found := "app.foo.count 2 1000\n", <-bodies; expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Transport: TransportHTTP,
+		URL:       ts.URL,
+		Timestamp: func() int64 { return 42 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected := "app.foo.count 2 42\n"; expected != body {
+		t.Fatalf("expected %q, found %q", expected, body)
+	}
+}
@@ -0,0 +1,132 @@
+// Command graphite-push sends the numbers of a JSON document, such as the
+// vars served by an expvar endpoint or a file written by a batch job, to
+// Graphite once, named the way the services exporting with this package
+// name their metrics.
+//
+// Usage:
+//
+//	graphite-push -address graphite:2003 -prefix jobs.backup -file stats.json
+//	graphite-push -address graphite:2003 -prefix app -url http://localhost:8080/debug/vars
+//
+// Numbers nested within objects are named after the keys leading to them,
+// joined by dots. Integers are sent as gauges, other numbers as float
+// gauges, and anything else, such as strings and arrays, is skipped.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dt/go-metrics"
+	"github.com/dt/go-metrics-graphite"
+)
+
+func main() {
+	var (
+		address  = flag.String("address", "localhost:2003", "host:port of the Graphite server")
+		prefix   = flag.String("prefix", "", "prefix of the metric names, may contain placeholders such as {host}")
+		protocol = flag.String("protocol", graphite.ProtocolPlaintext, "wire protocol, such as influx or opentsdb")
+		url      = flag.String("url", "", "expvar endpoint to read the metrics from")
+		file     = flag.String("file", "", "JSON file to read the metrics from, - for standard input")
+		timeout  = flag.Duration("timeout", 10*time.Second, "limit of reading the metrics, connecting and each write")
+	)
+	flag.Parse()
+	if ("" == *url) == ("" == *file) {
+		fmt.Fprintln(os.Stderr, "graphite-push: exactly one of -url and -file is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	doc, err := read(*url, *file, *timeout)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, "graphite-push:", err)
+		os.Exit(1)
+	}
+	r := metrics.NewRegistry()
+	if err := register(r, "", doc); nil != err {
+		fmt.Fprintln(os.Stderr, "graphite-push:", err)
+		os.Exit(1)
+	}
+	err = graphite.GraphiteOnce(graphite.GraphiteConfig{
+		Address:      *address,
+		Registry:     r,
+		Prefix:       *prefix,
+		Protocol:     *protocol,
+		DurationUnit: time.Nanosecond,
+		DialTimeout:  *timeout,
+		WriteTimeout: *timeout,
+	})
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// read returns the JSON document served at url, or held by file.
+func read(url, file string, timeout time.Duration) (interface{}, error) {
+	var body io.Reader = os.Stdin
+	if "" != url {
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(url)
+		if nil != err {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("GET %s failed: %s", url, resp.Status)
+		}
+		body = resp.Body
+	} else if "-" != file {
+		f, err := os.Open(file)
+		if nil != err {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	}
+	d := json.NewDecoder(body)
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); nil != err {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// register registers a gauge in r for every number in v, named after the
+// keys leading to it appended to name.
+func register(r metrics.Registry, name string, v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if "" != name {
+				k = name + "." + k
+			}
+			if err := register(r, k, child); nil != err {
+				return err
+			}
+		}
+	case json.Number:
+		if "" == name {
+			return nil
+		}
+		if i, err := v.Int64(); nil == err {
+			g := metrics.NewGauge()
+			g.Update(i)
+			return r.Register(name, g)
+		}
+		f, err := v.Float64()
+		if nil != err {
+			return err
+		}
+		g := metrics.NewGaugeFloat64()
+		g.Update(f)
+		return r.Register(name, g)
+	}
+	return nil
+}
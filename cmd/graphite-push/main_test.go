@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dt/go-metrics"
+)
+
+func TestRegister(t *testing.T) {
+	d := json.NewDecoder(strings.NewReader(`{"cmdline": ["app"], "jobs": {"done": 3, "rate": 0.5, "last": "ok"}}`))
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); nil != err {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	if err := register(r, "", doc); nil != err {
+		t.Fatal(err)
+	}
+	if g, ok := r.Get("jobs.done").(metrics.Gauge); !ok || 3 != g.Value() {
+		t.Fatal("bad gauge:", r.Get("jobs.done"))
+	}
+	if g, ok := r.Get("jobs.rate").(metrics.GaugeFloat64); !ok || 0.5 != g.Value() {
+		t.Fatal("bad gauge:", r.Get("jobs.rate"))
+	}
+	n := 0
+	r.Each(func(string, interface{}) { n++ })
+	if 2 != n {
+		t.Fatal("expected only the numbers to be registered:", n)
+	}
+}
@@ -0,0 +1,107 @@
+package graphite
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Codec serializes the series of the plaintext protocol into a wire
+// format, such as the pickle protocol of carbon, independently of the
+// Transport, Network or Dialer they are sent over.
+type Codec interface {
+	// Encode writes points to w. Payloads are split into batches and
+	// datagrams between writes, so each write must be a whole unit of the
+	// format, such as a line or a frame.
+	Encode(w io.Writer, points []Point)
+}
+
+// A Point is the value of a series at a time, as passed to a Codec.
+type Point struct {
+	Path  string // Dotted name of the series
+	Tags  string // Graphite tags of the series as appended to Path, such as ";env=prod", sorted by key; empty if it has none
+	Value string // Value in decimal, at full precision unless FloatPrecision is set
+	Time  int64  // Unix timestamp in seconds
+}
+
+// Codecs for GraphiteConfig.Codec.
+var (
+	PlaintextCodec       Codec = plaintextCodec{}       // Plaintext lines with tags folded into the path, for receivers without tag support
+	TaggedPlaintextCodec Codec = taggedPlaintextCodec{} // Plaintext lines with Graphite tags, like a nil Codec but ignoring ExportFormats
+	PickleCodec          Codec = pickleCodec{}          // Pickle protocol of carbon, one frame per metric
+)
+
+// codecs maps the names of codecs in a ConfigFile to them.
+var codecs = map[string]Codec{
+	"plaintext":        PlaintextCodec,
+	"tagged-plaintext": TaggedPlaintextCodec,
+	"pickle":           PickleCodec,
+}
+
+type plaintextCodec struct{}
+
+func (plaintextCodec) Encode(w io.Writer, points []Point) {
+	var b []byte
+	for i := range points {
+		b = append(b[:0], points[i].Path...)
+		if "" != points[i].Tags {
+			b = appendFoldedTags(b, points[i].Tags)
+		}
+		w.Write(appendPlaintextValue(b, &points[i]))
+	}
+}
+
+// appendFoldedTags appends tags, in the form of Point.Tags, to b as path
+// components, like TagModeFolded.
+func appendFoldedTags(b []byte, tags string) []byte {
+	for _, t := range strings.Split(tags[1:], ";") {
+		k, v, _ := strings.Cut(t, "=")
+		b = append(append(b, '.'), foldedTagEscaper.Replace(k)...)
+		b = append(append(b, '.'), foldedTagEscaper.Replace(v)...)
+	}
+	return b
+}
+
+type taggedPlaintextCodec struct{}
+
+func (taggedPlaintextCodec) Encode(w io.Writer, points []Point) {
+	var b []byte
+	for i := range points {
+		b = append(append(b[:0], points[i].Path...), points[i].Tags...)
+		w.Write(appendPlaintextValue(b, &points[i]))
+	}
+}
+
+// appendPlaintextValue appends the value and timestamp of p to b, the
+// series name of a plaintext line.
+func appendPlaintextValue(b []byte, p *Point) []byte {
+	b = append(append(append(b, ' '), p.Value...), ' ')
+	return append(strconv.AppendInt(b, p.Time, 10), '\n')
+}
+
+// A codecEncoder names datapoints like a plaintextEncoder and passes them
+// to a Codec as points.
+type codecEncoder struct {
+	w      io.Writer
+	codec  Codec
+	onLine LineFunc
+	value  []byte
+	points []Point
+	namer
+}
+
+func (enc *codecEncoder) encode(dps []datapoint, now int64) {
+	enc.points = enc.points[:0]
+	for i := range dps {
+		dp := &dps[i]
+		enc.value = enc.appendValue(enc.value[:0], dp)
+		p := Point{Path: enc.path(dp), Tags: dp.tags.key(), Value: string(enc.value), Time: now}
+		if nil != enc.onLine {
+			enc.onLine(p.Path+p.Tags, p.Value, p.Time)
+		}
+		enc.points = append(enc.points, p)
+	}
+	if 0 < len(enc.points) {
+		enc.codec.Encode(enc.w, enc.points)
+	}
+}
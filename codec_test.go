@@ -0,0 +1,64 @@
+package graphite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestCodecs(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests{code=200,host=a.b}", r).Inc(2)
+	encode := func(codec Codec) string {
+		var b strings.Builder
+		err := GraphiteOnce(GraphiteConfig{
+			Registry:     r,
+			Prefix:       "app",
+			Sink:         WriterSink(&b),
+			Timestamp:    func() int64 { return 1 },
+			DurationUnit: 1,
+			TagExtractor: splitLabels,
+			Codec:        codec,
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	plain := encode(nil)
+	if found := encode(TaggedPlaintextCodec); plain != found {
+		t.Errorf("tagged plaintext %q, not %q", found, plain)
+	}
+	if expected, found := "app.requests.count.code.200.host.a_b 2 1\n", encode(PlaintextCodec); expected != found {
+		t.Errorf("plaintext %q, not %q", found, expected)
+	}
+
+	path := "app.requests.count;code=200;host=a.b"
+	expected := "\x80\x02](X" + string(rune(len(path))) + "\x00\x00\x00" + path +
+		"J\x01\x00\x00\x00G\x40\x00\x00\x00\x00\x00\x00\x00\x86\x86e."
+	expected = "\x00\x00\x00" + string(rune(len(expected))) + expected
+	if found := encode(PickleCodec); expected != found {
+		t.Errorf("pickle %q, not %q", found, expected)
+	}
+
+	r.Unregister("requests{code=200,host=a.b}")
+	metrics.GetOrRegisterGaugeFloat64("load", r).Update(0.123456789)
+	if expected, found := "app.load.value 0.123456789 1\n", encode(TaggedPlaintextCodec); expected != found {
+		t.Errorf("value of %q not exact, expected %q", found, expected)
+	}
+
+	c := GraphiteConfig{Registry: r, FlushInterval: 1, DurationUnit: 1, Address: "graphite:2003", Protocol: ProtocolJSON, Codec: PickleCodec}
+	if err := c.Validate(); nil == err || !strings.Contains(err.Error(), "Codec") {
+		t.Error("codec of another protocol not reported:", err)
+	}
+	f := ConfigFile{Address: "graphite:2004", Codec: "pickle"}
+	if c, err := f.Config(); nil != err || PickleCodec != c.Codec {
+		t.Error("codec of config file not set:", c.Codec, err)
+	}
+	f.Codec = "msgpack"
+	if _, err := f.Config(); nil == err {
+		t.Error("unknown codec accepted")
+	}
+}
@@ -0,0 +1,39 @@
+package graphite
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// Compressions for GraphiteConfig.Compression.
+const (
+	CompressionNone = ""     // Payloads are sent as they are
+	CompressionGzip = "gzip" // Each batch is a gzip member, announced by Content-Encoding over HTTP
+)
+
+// compressed reports whether batches are compressed before being sent.
+// Datagrams and Prometheus remote write requests, which are compressed with
+// snappy, never are.
+func (c *GraphiteConfig) compressed() bool {
+	return CompressionGzip == c.Compression && !c.datagrams() && ProtocolRemoteWrite != c.Protocol
+}
+
+// A compressor gzips batches into a buffer reused across flushes.
+type compressor struct {
+	buf bytes.Buffer
+	w   *gzip.Writer
+}
+
+// compress returns b compressed. The result is only valid until the next
+// call.
+func (z *compressor) compress(b []byte) []byte {
+	z.buf.Reset()
+	if nil == z.w {
+		z.w = gzip.NewWriter(&z.buf)
+	} else {
+		z.w.Reset(&z.buf)
+	}
+	z.w.Write(b)
+	z.w.Close()
+	return z.buf.Bytes()
+}
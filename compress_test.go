@@ -0,0 +1,70 @@
+package graphite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestCompression(t *testing.T) {
+	type request struct {
+		encoding, body string
+	}
+	requests := make(chan request, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if nil != err {
+			t.Error(err)
+			return
+		}
+		body, _ := io.ReadAll(zr)
+		requests <- request{r.Header.Get("Content-Encoding"), string(body)}
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:    r,
+		Prefix:      "app",
+		Transport:   TransportHTTP,
+		URL:         ts.URL,
+		Compression: CompressionGzip,
+		Timestamp:   func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := (request{"gzip", "app.foo.count 2 1\n"}), <-requests; expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	// Batches written to streams are concatenated gzip members.
+	metrics.GetOrRegisterCounter("bar", r).Inc(3)
+	var b bytes.Buffer
+	err = GraphiteOnce(GraphiteConfig{
+		Registry:        r,
+		Prefix:          "app",
+		Sink:            WriterSink(&b),
+		WriteBufferSize: 1,
+		SortedOutput:    true,
+		Compression:     CompressionGzip,
+		Timestamp:       func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&b)
+	if nil != err {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if expected, found := "app.bar.count 3 1\napp.foo.count 2 1\n", string(body); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
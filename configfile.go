@@ -0,0 +1,303 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A ConfigFile holds the settings of a GraphiteConfig which can be kept in
+// a configuration file, so that operators can change them without touching
+// the code of the service. LoadConfig reads it from JSON, or from YAML and
+// TOML once their decoders are registered with RegisterConfigDecoder, which
+// its yaml and toml tags are for.
+//
+// Durations are strings such as "10s" or "1ms". Fields left out keep the
+// defaults of Config. Schedule is a spec of ParseSchedule, or "aligned" for
+// the AlignedSchedule of FlushInterval. Include and Exclude set the Filter
+// of the GraphiteConfig: patterns of path.Match, in which * also matches
+// dots, such as "http.*". Metrics are exported if their names match any
+// pattern of Include, or if it is empty, and none of Exclude.
+type ConfigFile struct {
+	Address                string            `json:"address" yaml:"address" toml:"address"`
+	SRV                    string            `json:"srv" yaml:"srv" toml:"srv"`
+	Destinations           []string          `json:"destinations" yaml:"destinations" toml:"destinations"`
+	Network                string            `json:"network" yaml:"network" toml:"network"`
+	ResolveTTL             Duration          `json:"resolve_ttl" yaml:"resolve_ttl" toml:"resolve_ttl"`
+	URL                    string            `json:"url" yaml:"url" toml:"url"`
+	Protocol               string            `json:"protocol" yaml:"protocol" toml:"protocol"`
+	Transport              string            `json:"transport" yaml:"transport" toml:"transport"`
+	Prefix                 string            `json:"prefix" yaml:"prefix" toml:"prefix"`
+	FlushInterval          Duration          `json:"flush_interval" yaml:"flush_interval" toml:"flush_interval"`
+	DurationUnit           Duration          `json:"duration_unit" yaml:"duration_unit" toml:"duration_unit"`
+	RateUnit               Duration          `json:"rate_unit" yaml:"rate_unit" toml:"rate_unit"`
+	Percentiles            []float64         `json:"percentiles" yaml:"percentiles" toml:"percentiles"`
+	Buckets                []float64         `json:"buckets" yaml:"buckets" toml:"buckets"`
+	PercentileFormat       string            `json:"percentile_format" yaml:"percentile_format" toml:"percentile_format"`
+	Naming                 string            `json:"naming" yaml:"naming" toml:"naming"`
+	PathOrder              string            `json:"path_order" yaml:"path_order" toml:"path_order"`
+	TypeRoots              bool              `json:"type_roots" yaml:"type_roots" toml:"type_roots"`
+	SuffixMap              map[string]string `json:"suffix_map" yaml:"suffix_map" toml:"suffix_map"`
+	Tags                   map[string]string `json:"tags" yaml:"tags" toml:"tags"`
+	HistogramFields        []string          `json:"histogram_fields" yaml:"histogram_fields" toml:"histogram_fields"`
+	MeterFields            []string          `json:"meter_fields" yaml:"meter_fields" toml:"meter_fields"`
+	TimerFields            []string          `json:"timer_fields" yaml:"timer_fields" toml:"timer_fields"`
+	ExcludeFields          []string          `json:"exclude_fields" yaml:"exclude_fields" toml:"exclude_fields"`
+	SkipInvalidValues      bool              `json:"skip_invalid_values" yaml:"skip_invalid_values" toml:"skip_invalid_values"`
+	SkipUnchanged          bool              `json:"skip_unchanged" yaml:"skip_unchanged" toml:"skip_unchanged"`
+	MetricTTL              Duration          `json:"metric_ttl" yaml:"metric_ttl" toml:"metric_ttl"`
+	ValidateNames          string            `json:"validate_names" yaml:"validate_names" toml:"validate_names"`
+	MaxDatapointsPerSecond int               `json:"max_datapoints_per_second" yaml:"max_datapoints_per_second" toml:"max_datapoints_per_second"`
+	APIKey                 string            `json:"api_key" yaml:"api_key" toml:"api_key"`
+	HTTPHeaders            map[string]string `json:"http_headers" yaml:"http_headers" toml:"http_headers"`
+	HTTPUsername           string            `json:"http_username" yaml:"http_username" toml:"http_username"`
+	HTTPPassword           string            `json:"http_password" yaml:"http_password" toml:"http_password"`
+	ProxyURL               string            `json:"proxy_url" yaml:"proxy_url" toml:"proxy_url"`
+	Compression            string            `json:"compression" yaml:"compression" toml:"compression"`
+	Connections            int               `json:"connections" yaml:"connections" toml:"connections"`
+	DialTimeout            Duration          `json:"dial_timeout" yaml:"dial_timeout" toml:"dial_timeout"`
+	WriteTimeout           Duration          `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"`
+	PersistentConnection   bool              `json:"persistent_connection" yaml:"persistent_connection" toml:"persistent_connection"`
+	KeepAlive              Duration          `json:"keep_alive" yaml:"keep_alive" toml:"keep_alive"`
+	FallbackDelay          Duration          `json:"fallback_delay" yaml:"fallback_delay" toml:"fallback_delay"`
+	RuntimeMetrics         bool              `json:"runtime_metrics" yaml:"runtime_metrics" toml:"runtime_metrics"`
+	ProcessMetrics         bool              `json:"process_metrics" yaml:"process_metrics" toml:"process_metrics"`
+	SelfMetrics            bool              `json:"self_metrics" yaml:"self_metrics" toml:"self_metrics"`
+	Heartbeat              bool              `json:"heartbeat" yaml:"heartbeat" toml:"heartbeat"`
+	StartEpoch             bool              `json:"start_epoch" yaml:"start_epoch" toml:"start_epoch"`
+	VectoredWrites         bool              `json:"vectored_writes" yaml:"vectored_writes" toml:"vectored_writes"`
+	NoMetrics              bool              `json:"no_metrics" yaml:"no_metrics" toml:"no_metrics"`
+	StrictTypes            bool              `json:"strict_types" yaml:"strict_types" toml:"strict_types"`
+	FlushBytes             int               `json:"flush_bytes" yaml:"flush_bytes" toml:"flush_bytes"`
+	FlushDatapoints        int               `json:"flush_datapoints" yaml:"flush_datapoints" toml:"flush_datapoints"`
+	ByteQuota              int               `json:"byte_quota" yaml:"byte_quota" toml:"byte_quota"`
+	InvalidValues          string            `json:"invalid_values" yaml:"invalid_values" toml:"invalid_values"`
+	InvalidValuesByType    map[string]string `json:"invalid_values_by_type" yaml:"invalid_values_by_type" toml:"invalid_values_by_type"`
+	MaxSeries              int               `json:"max_series" yaml:"max_series" toml:"max_series"`
+	Schedule               string            `json:"schedule" yaml:"schedule" toml:"schedule"`
+	Descriptions           Descriptions      `json:"descriptions" yaml:"descriptions" toml:"descriptions"`
+	DescribeInterval       Duration          `json:"describe_interval" yaml:"describe_interval" toml:"describe_interval"`
+	PingDatapoint          bool              `json:"ping_datapoint" yaml:"ping_datapoint" toml:"ping_datapoint"`
+	PingOnStart            bool              `json:"ping_on_start" yaml:"ping_on_start" toml:"ping_on_start"`
+	OptionalFields         []string          `json:"optional_fields" yaml:"optional_fields" toml:"optional_fields"`
+	Codec                  string            `json:"codec" yaml:"codec" toml:"codec"`
+	LogInterval            Duration          `json:"log_interval" yaml:"log_interval" toml:"log_interval"`
+	Include                []string          `json:"include" yaml:"include" toml:"include"`
+	Exclude                []string          `json:"exclude" yaml:"exclude" toml:"exclude"`
+}
+
+// A Duration is a time.Duration written as a string such as "10s" in a
+// ConfigFile.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if nil != err {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// A ConfigDecoder decodes the contents of a configuration file into v, a
+// *ConfigFile, like the Unmarshal functions of YAML and TOML packages.
+type ConfigDecoder func(data []byte, v interface{}) error
+
+var configDecoders = struct {
+	sync.RWMutex
+	m map[string]ConfigDecoder
+}{m: make(map[string]ConfigDecoder)}
+
+// RegisterConfigDecoder teaches LoadConfig to decode the files whose names
+// end with ext, such as ".yaml", using fn, such as yaml.Unmarshal.
+func RegisterConfigDecoder(ext string, fn ConfigDecoder) {
+	configDecoders.Lock()
+	defer configDecoders.Unlock()
+	configDecoders.m[ext] = fn
+}
+
+// decodeJSON decodes JSON data into v, unknown keys being errors, to catch
+// typos.
+func decodeJSON(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+// LoadConfig returns the GraphiteConfig of the ConfigFile at path, see
+// ConfigFile.Config. It is decoded by the ConfigDecoder registered for the
+// extension of path, and as JSON otherwise, unknown keys being errors.
+func LoadConfig(path string) (GraphiteConfig, error) {
+	b, err := os.ReadFile(path)
+	if nil != err {
+		return GraphiteConfig{}, err
+	}
+	configDecoders.RLock()
+	decode, ok := configDecoders.m[filepath.Ext(path)]
+	configDecoders.RUnlock()
+	if !ok {
+		decode = decodeJSON
+	}
+	var f ConfigFile
+	if err := decode(b, &f); nil != err {
+		return GraphiteConfig{}, fmt.Errorf("graphite: %s: %w", path, err)
+	}
+	c, err := f.Config()
+	if nil != err {
+		return c, fmt.Errorf("%w in %s", err, path)
+	}
+	return c, nil
+}
+
+// Config returns the GraphiteConfig of f, flushing every ten seconds with
+// the DurationUnit and Percentiles of Graphite unless f sets them. Its
+// Registry is left for the caller to set. It returns an error if f leaves
+// out where to send metrics, sets a negative interval or unit, a malformed
+// pattern, or a protocol, codec or naming convention which does not exist.
+func (f *ConfigFile) Config() (GraphiteConfig, error) {
+	c := GraphiteConfig{
+		Address:                f.Address,
+		SRV:                    f.SRV,
+		Destinations:           f.Destinations,
+		Network:                f.Network,
+		ResolveTTL:             time.Duration(f.ResolveTTL),
+		URL:                    f.URL,
+		Protocol:               f.Protocol,
+		Transport:              f.Transport,
+		Prefix:                 f.Prefix,
+		FlushInterval:          time.Duration(f.FlushInterval),
+		DurationUnit:           time.Duration(f.DurationUnit),
+		RateUnit:               time.Duration(f.RateUnit),
+		Percentiles:            f.Percentiles,
+		Buckets:                f.Buckets,
+		PercentileFormat:       f.PercentileFormat,
+		Naming:                 f.Naming,
+		PathOrder:              f.PathOrder,
+		TypeRoots:              f.TypeRoots,
+		SuffixMap:              f.SuffixMap,
+		Tags:                   f.Tags,
+		HistogramFields:        f.HistogramFields,
+		MeterFields:            f.MeterFields,
+		TimerFields:            f.TimerFields,
+		ExcludeFields:          f.ExcludeFields,
+		SkipInvalidValues:      f.SkipInvalidValues,
+		SkipUnchanged:          f.SkipUnchanged,
+		MetricTTL:              time.Duration(f.MetricTTL),
+		ValidateNames:          f.ValidateNames,
+		MaxDatapointsPerSecond: f.MaxDatapointsPerSecond,
+		APIKey:                 f.APIKey,
+		HTTPUsername:           f.HTTPUsername,
+		HTTPPassword:           f.HTTPPassword,
+		ProxyURL:               f.ProxyURL,
+		Compression:            f.Compression,
+		Connections:            f.Connections,
+		DialTimeout:            time.Duration(f.DialTimeout),
+		WriteTimeout:           time.Duration(f.WriteTimeout),
+		PersistentConnection:   f.PersistentConnection,
+		KeepAlive:              time.Duration(f.KeepAlive),
+		FallbackDelay:          time.Duration(f.FallbackDelay),
+		RuntimeMetrics:         f.RuntimeMetrics,
+		ProcessMetrics:         f.ProcessMetrics,
+		SelfMetrics:            f.SelfMetrics,
+		Heartbeat:              f.Heartbeat,
+		StartEpoch:             f.StartEpoch,
+		VectoredWrites:         f.VectoredWrites,
+		NoMetrics:              f.NoMetrics,
+		StrictTypes:            f.StrictTypes,
+		FlushBytes:             f.FlushBytes,
+		FlushDatapoints:        f.FlushDatapoints,
+		ByteQuota:              f.ByteQuota,
+		InvalidValues:          f.InvalidValues,
+		InvalidValuesByType:    f.InvalidValuesByType,
+		MaxSeries:              f.MaxSeries,
+		Descriptions:           f.Descriptions,
+		DescribeInterval:       time.Duration(f.DescribeInterval),
+		PingDatapoint:          f.PingDatapoint,
+		PingOnStart:            f.PingOnStart,
+		OptionalFields:         f.OptionalFields,
+		LogInterval:            time.Duration(f.LogInterval),
+	}
+	if 0 != len(f.HTTPHeaders) {
+		c.HTTPHeaders = make(http.Header, len(f.HTTPHeaders))
+		for k, v := range f.HTTPHeaders {
+			c.HTTPHeaders.Set(k, v)
+		}
+	}
+	if 0 == c.FlushInterval {
+		c.FlushInterval = 10 * time.Second
+	}
+	if 0 == c.DurationUnit {
+		c.DurationUnit = time.Nanosecond
+	}
+	if nil == c.Percentiles {
+		c.Percentiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+	}
+	if 0 > c.FlushInterval || 0 > c.DurationUnit {
+		return c, fmt.Errorf("graphite: flush_interval and duration_unit must be positive")
+	}
+	switch f.Schedule {
+	case "":
+	case "aligned":
+		c.Scheduler = alignedSchedule(c.FlushInterval)
+	default:
+		s, err := ParseSchedule(f.Schedule)
+		if nil != err {
+			return c, err
+		}
+		c.Scheduler = s
+	}
+	switch c.Protocol {
+	case ProtocolPlaintext, ProtocolInflux, ProtocolOpenTSDB, ProtocolStatsD, ProtocolRemoteWrite, ProtocolJSON:
+	default:
+		return c, fmt.Errorf("graphite: unknown protocol %q", c.Protocol)
+	}
+	if "" != f.Codec {
+		codec, ok := codecs[f.Codec]
+		if !ok {
+			return c, fmt.Errorf("graphite: unknown codec %q", f.Codec)
+		}
+		c.Codec = codec
+	}
+	for _, p := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); nil != err {
+			return c, fmt.Errorf("graphite: bad pattern %q", p)
+		}
+	}
+	if 0 != len(f.Include) || 0 != len(f.Exclude) {
+		c.Filter = globFilter(f.Include, f.Exclude)
+	}
+	if _, ok := namingFormats[c.Naming]; !ok && NamingDefault != c.Naming {
+		return c, fmt.Errorf("graphite: unknown naming convention %q", c.Naming)
+	}
+	if "" == c.Address && "" == c.SRV && 0 == len(c.Destinations) && "" == c.URL && TransportStdout != c.Transport && TransportDiscard != c.Transport {
+		return c, fmt.Errorf("graphite: none of address, srv, destinations and url is set")
+	}
+	return c, nil
+}
+
+// globFilter returns a FilterFunc exporting the metrics whose names match
+// any of the patterns of include, or any name if it is empty, and none of
+// exclude. The patterns are valid ones of path.Match.
+func globFilter(include, exclude []string) FilterFunc {
+	match := func(patterns []string, name string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return func(name string) bool {
+		return (0 == len(include) || match(include, name)) && !match(exclude, name)
+	}
+}
@@ -0,0 +1,109 @@
+package graphite
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(s string) string {
+		path := filepath.Join(dir, "graphite.json")
+		if err := os.WriteFile(path, []byte(s), 0644); nil != err {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	c, err := LoadConfig(write(`{
+		"destinations": ["a:2003", "b:2003:x"],
+		"prefix": "app.%h",
+		"flush_interval": "30s",
+		"percentiles": [0.5, 0.99],
+		"tags": {"env": "prod"},
+		"exclude_fields": ["min", "max"],
+		"http_headers": {"x-scope": "ops"},
+		"write_timeout": "2s",
+		"persistent_connection": true
+	}`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "app.%h" != c.Prefix || 30*time.Second != c.FlushInterval || time.Nanosecond != c.DurationUnit || 2*time.Second != c.WriteTimeout || !c.PersistentConnection {
+		t.Fatalf("bad config: %+v", c)
+	}
+	if expected := []string{"a:2003", "b:2003:x"}; !reflect.DeepEqual(expected, c.Destinations) {
+		t.Fatalf("expected %v, found %v", expected, c.Destinations)
+	}
+	if expected := []float64{0.5, 0.99}; !reflect.DeepEqual(expected, c.Percentiles) {
+		t.Fatalf("expected %v, found %v", expected, c.Percentiles)
+	}
+	if expected := []string{"min", "max"}; !reflect.DeepEqual(expected, c.ExcludeFields) {
+		t.Fatalf("expected %v, found %v", expected, c.ExcludeFields)
+	}
+	if "prod" != c.Tags["env"] || "ops" != c.HTTPHeaders.Get("X-Scope") {
+		t.Fatalf("bad tags or headers: %v %v", c.Tags, c.HTTPHeaders)
+	}
+
+	for name, s := range map[string]string{
+		"duration": `{"address": "graphite:2003", "flush_interval": "30"}`,
+		"unknown":  `{"address": "graphite:2003", "flush": "30s"}`,
+		"protocol": `{"address": "graphite:2003", "protocol": "carbon"}`,
+		"naming":   `{"address": "graphite:2003", "naming": "java"}`,
+		"negative": `{"address": "graphite:2003", "flush_interval": "-1s"}`,
+		"address":  `{"prefix": "app"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := write(s)
+			if _, err := LoadConfig(path); nil == err || !strings.Contains(err.Error(), path) {
+				t.Fatal("expected an error naming", path, err)
+			}
+		})
+	}
+}
+
+func TestConfigFileFilter(t *testing.T) {
+	f := ConfigFile{Address: "graphite:2003", Include: []string{"http.*", "db.*"}, Exclude: []string{"*.debug"}}
+	c, err := f.Config()
+	if nil != err {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"http.requests": true, "db.pool.size": true, "http.debug": false, "cache.hits": false} {
+		if found := c.Filter(name); expected != found {
+			t.Errorf("%s exported %v, not %v", name, found, expected)
+		}
+	}
+	f.Include = []string{"http.["}
+	if _, err := f.Config(); nil == err {
+		t.Error("malformed pattern accepted")
+	}
+}
+
+func TestRegisterConfigDecoder(t *testing.T) {
+	// A decoder of "key=value" lines, standing in for one of YAML or TOML.
+	RegisterConfigDecoder(".kv", func(data []byte, v interface{}) error {
+		m := make(map[string]string)
+		for _, line := range strings.Fields(string(data)) {
+			k, value, _ := strings.Cut(line, "=")
+			m[k] = value
+		}
+		b, err := json.Marshal(m)
+		if nil != err {
+			return err
+		}
+		return json.Unmarshal(b, v)
+	})
+	path := filepath.Join(t.TempDir(), "graphite.kv")
+	if err := os.WriteFile(path, []byte("address=graphite:2003\nflush_interval=1m\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(path)
+	if nil != err || "graphite:2003" != c.Address || time.Minute != c.FlushInterval {
+		t.Fatalf("bad config: %+v %v", c, err)
+	}
+}
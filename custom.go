@@ -0,0 +1,32 @@
+package graphite
+
+import (
+	"reflect"
+	"sync"
+)
+
+// An EncodeFunc exports a metric of a custom type by calling emit once for
+// each of its series, with the suffix naming the series and its value.
+type EncodeFunc func(metric interface{}, emit func(suffix string, value float64))
+
+var encoders = struct {
+	sync.RWMutex
+	m map[reflect.Type]EncodeFunc
+}{m: make(map[reflect.Type]EncodeFunc)}
+
+// RegisterEncoder teaches the exporter to export metrics of the same type
+// as sample using fn. Encoders registered for a type take precedence over
+// the built-in handling of the go-metrics types.
+func RegisterEncoder(sample interface{}, fn EncodeFunc) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	encoders.m[reflect.TypeOf(sample)] = fn
+}
+
+// lookupEncoder returns the encoder registered for the type of i, if any.
+func lookupEncoder(i interface{}) (EncodeFunc, bool) {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	fn, ok := encoders.m[reflect.TypeOf(i)]
+	return fn, ok
+}
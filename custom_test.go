@@ -0,0 +1,32 @@
+package graphite
+
+import (
+	"bytes"
+	"testing"
+)
+
+type window struct {
+	p50, p99 float64
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder(&window{}, func(metric interface{}, emit func(string, float64)) {
+		w := metric.(*window)
+		emit("p50", w.p50)
+		emit("p99", w.p99)
+	})
+
+	e := NewExporter(GraphiteConfig{})
+	dps := e.appendDatapoints(nil, "foobar", "latency", &window{p50: 1.5, p99: 7})
+
+	var b bytes.Buffer
+	newEncoder(&b, &e.config).encode(dps, 1)
+
+	if expected, found := "foobar.latency.p50 1.500000 1\nfoobar.latency.p99 7.000000 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	if dps = e.appendDatapoints(nil, "foobar", "latency", window{}); 0 != len(dps) {
+		t.Fatal("encoder used for a different type:", dps)
+	}
+}
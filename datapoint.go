@@ -0,0 +1,380 @@
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dt/go-metrics"
+)
+
+// A field identifies one of the series exported for a metric and selects
+// the entry of ExportFormats used to encode it.
+type field int
+
+const (
+	fieldCounter field = iota
+	fieldHistogramCount
+	fieldGauge
+	fieldGaugeFloat64
+	fieldMin
+	fieldMax
+	fieldMean
+	fieldStddev
+	fieldPercentile
+	fieldRate1
+	fieldRate5
+	fieldRate15
+	fieldRateMean
+	fieldHealthy
+	fieldHealthErrors
+	fieldEWMA
+	fieldSum
+	fieldTotal
+	fieldMeterCount
+	fieldTimerCount
+	fieldCounterRate
+	fieldBucket
+	fieldCustom
+	fieldVariance
+	fieldSampleSize
+	fieldMeterRateMean
+)
+
+// format returns the format string used to encode f, falling back to the
+// default when ExportFormats leaves it empty.
+func (f field) format() string {
+	if format := ExportFormats.format(f); "" != format {
+		return format
+	}
+	return defaultFormats.format(f)
+}
+
+// integer reports whether values of f are integers rather than floats.
+func (f field) integer() bool {
+	switch f {
+	case fieldCounter, fieldHistogramCount, fieldGauge, fieldMin, fieldMax, fieldHealthy, fieldHealthErrors, fieldSum, fieldMeterCount, fieldTimerCount, fieldBucket, fieldSampleSize:
+		return true
+	}
+	return false
+}
+
+// A kind identifies the type of metric a datapoint was produced from.
+type kind int
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindGaugeFloat64
+	kindHistogram
+	kindMeter
+	kindTimer
+	kindEWMA
+	kindHealthcheck
+	kindCustom
+)
+
+// A datapoint is a single value of one of the series exported for a metric.
+type datapoint struct {
+	prefix   string  // Prefix of the registry the metric belongs to
+	name     string  // Name of the metric within its registry
+	field    field   // Series of the metric this value belongs to
+	kind     kind    // Type of the metric
+	key      string  // Percentile key, bucket key or suffix of fieldPercentile, fieldBucket and fieldCustom
+	quantile float64 // Percentile of fieldPercentile, between 0 and 1
+	tags     *tagSet // Tags of the metric, if any
+	ivalue   int64   // Value of integer fields
+	fvalue   float64 // Value of floating point fields
+	time     int64   // Time the value was observed in seconds since the epoch, zero for the time of the flush
+
+	unchanged bool          // Whether the value is the same as in the previous flush
+	delta     int64         // Change of integer values since the previous flush
+	since     time.Duration // Time since the previous flush observed the value, zero if none did
+	reset     int           // One more than the index of the reset of the metric in the snapshot, zero if it has none
+}
+
+// fullName returns the name of the metric dp belongs to, prefixed with the
+// prefix of its registry.
+func (dp *datapoint) fullName() string {
+	return fullName(dp.prefix, dp.name)
+}
+
+func fullName(prefix, name string) string {
+	if "" == prefix {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// fullNameLen returns the length of fullName(prefix, name).
+func fullNameLen(prefix, name string) int {
+	if "" == prefix {
+		return len(name)
+	}
+	return len(prefix) + 1 + len(name)
+}
+
+// A series identifies the datapoints of one series across flushes.
+type series struct {
+	prefix, name string
+	field        field
+	key          string
+	tags         string
+}
+
+func (dp *datapoint) series() series {
+	return series{prefix: dp.prefix, name: dp.name, field: dp.field, key: dp.key, tags: dp.tags.key()}
+}
+
+// valid reports whether the value is a finite number.
+func (dp *datapoint) valid() bool {
+	return dp.field.integer() || !(math.IsNaN(dp.fvalue) || math.IsInf(dp.fvalue, 0))
+}
+
+// appendDatapoints appends the datapoints exported for metric i to dps,
+// limited to the fields selected for its type. Metrics of unknown types are
+// skipped, and reported by the error of the flush.
+func (e *Exporter) appendDatapoints(dps []datapoint, prefix, name string, i interface{}) []datapoint {
+	c := &e.config
+	du := float64(c.DurationUnit)
+	ru := 1.0
+	if 0 != c.RateUnit {
+		ru = c.RateUnit.Seconds()
+	}
+	var k kind
+	start := len(dps)
+	integer := func(f field, v int64) {
+		dps = append(dps, datapoint{prefix: prefix, name: name, field: f, kind: k, ivalue: v})
+	}
+	float := func(f field, v float64) {
+		dps = append(dps, datapoint{prefix: prefix, name: name, field: f, kind: k, fvalue: v})
+	}
+	percentiles := func(qs, ps []float64, scale float64) {
+		for psIdx, psKey := range qs {
+			key, ok := e.quantileKeys[psKey]
+			if !ok {
+				key = percentileKey(psKey, c.PercentileFormat)
+			}
+			dps = append(dps, datapoint{prefix: prefix, name: name, field: fieldPercentile, kind: k, key: key, quantile: psKey, fvalue: ps[psIdx] / scale})
+		}
+	}
+	if fn, ok := lookupEncoder(i); ok {
+		k = kindCustom
+		fn(i, func(suffix string, value float64) {
+			dps = append(dps, datapoint{prefix: prefix, name: name, field: fieldCustom, kind: k, key: suffix, fvalue: value})
+		})
+		return dps
+	}
+	buckets := func(h histogramValues, scale float64) {
+		if 0 == len(c.Buckets) {
+			return
+		}
+		var vs []float64
+		if s, ok := sampleOf(h); ok && nil == c.Quantiles {
+			vs = sortedValues(s)
+		} else {
+			vs = c.percentiles(prefix, name, i, h, bucketQuantiles)
+		}
+		for j, count := range bucketCounts(vs, h.Count(), c.Buckets, scale) {
+			dps = append(dps, datapoint{prefix: prefix, name: name, field: fieldBucket, kind: k, key: e.bucketKeys[j], ivalue: count})
+		}
+	}
+	extras := func(h histogramValues, scale float64) {
+		if v, ok := h.(interface{ Variance() float64 }); ok && e.optional(k, fieldVariance) {
+			float(fieldVariance, v.Variance()/(scale*scale))
+		}
+		if e.optional(k, fieldSampleSize) {
+			if s, ok := sampleOf(h); ok {
+				integer(fieldSampleSize, int64(s.Size()))
+			}
+		}
+	}
+	histogram := func(h histogramValues) {
+		qs := c.histogramPercentiles()
+		ps := c.percentiles(prefix, name, i, h, qs)
+		integer(fieldHistogramCount, h.Count())
+		integer(fieldMin, h.Min())
+		integer(fieldMax, h.Max())
+		float(fieldMean, h.Mean())
+		float(fieldStddev, h.StdDev())
+		integer(fieldSum, h.Sum())
+		extras(h, 1)
+		percentiles(qs, ps, 1)
+		buckets(h, 1)
+	}
+	meter := func(m meterValues) {
+		integer(fieldMeterCount, m.Count())
+		float(fieldRate1, m.Rate1()*ru)
+		float(fieldRate5, m.Rate5()*ru)
+		float(fieldRate15, m.Rate15()*ru)
+		float(fieldMeterRateMean, m.RateMean()*ru)
+	}
+	timer := func(t timerValues) {
+		qs := c.timerPercentiles()
+		ps := c.percentiles(prefix, name, i, t, qs)
+		integer(fieldTimerCount, t.Count())
+		integer(fieldMin, t.Min()/int64(du))
+		integer(fieldMax, t.Max()/int64(du))
+		float(fieldMean, t.Mean()/du)
+		float(fieldStddev, t.StdDev()/du)
+		float(fieldTotal, float64(t.Count())*t.Mean()/du)
+		extras(t, du)
+		percentiles(qs, ps, du)
+		buckets(t, du)
+		float(fieldRate1, t.Rate1()*ru)
+		float(fieldRate5, t.Rate5()*ru)
+		float(fieldRate15, t.Rate15()*ru)
+		float(fieldRateMean, t.RateMean()*ru)
+	}
+	switch metric := i.(type) {
+	case metrics.Counter:
+		k = kindCounter
+		integer(fieldCounter, metric.Count())
+	case metrics.Gauge:
+		k = kindGauge
+		integer(fieldGauge, metric.Value())
+	case metrics.GaugeFloat64:
+		k = kindGaugeFloat64
+		float(fieldGaugeFloat64, metric.Value())
+	case metrics.Histogram:
+		k = kindHistogram
+		histogram(metric.Snapshot())
+	case metrics.Meter:
+		k = kindMeter
+		meter(metric.Snapshot())
+	case metrics.Timer:
+		k = kindTimer
+		timer(metric.Snapshot())
+	case metrics.EWMA:
+		k = kindEWMA
+		float(fieldEWMA, metric.Snapshot().Rate()*ru)
+	case metrics.Healthcheck:
+		k = kindHealthcheck
+		metric.Check()
+		healthy := int64(1)
+		if nil != metric.Error() {
+			healthy = 0
+		}
+		integer(fieldHealthy, healthy)
+		if c.HealthcheckErrors {
+			integer(fieldHealthErrors, e.healthFailures(prefix, name, 0 == healthy))
+		}
+	case foreignTimer:
+		k = kindTimer
+		timer(metric)
+	case foreignHistogram:
+		k = kindHistogram
+		histogram(metric)
+	case foreignMeter:
+		k = kindMeter
+		meter(metric)
+	case foreignCounter:
+		k = kindCounter
+		integer(fieldCounter, metric.Count())
+	case foreignGauge:
+		k = kindGauge
+		integer(fieldGauge, metric.Value())
+	case foreignGaugeFloat64:
+		k = kindGaugeFloat64
+		float(fieldGaugeFloat64, metric.Value())
+	case foreignEWMA:
+		k = kindEWMA
+		float(fieldEWMA, metric.Rate()*ru)
+	default:
+		e.snapshot.errs = append(e.snapshot.errs, &MetricError{Name: fullName(prefix, name), Err: fmt.Errorf("%w %T", ErrUnknownType, i)})
+	}
+	return append(dps[:start], e.selectFields(dps[start:], k)...)
+}
+
+// histogramPercentiles returns the percentiles exported from histograms.
+func (c *GraphiteConfig) histogramPercentiles() []float64 {
+	if nil != c.HistogramPercentiles {
+		return c.HistogramPercentiles
+	}
+	return c.Percentiles
+}
+
+// timerPercentiles returns the percentiles exported from timers.
+func (c *GraphiteConfig) timerPercentiles() []float64 {
+	if nil != c.TimerPercentiles {
+		return c.TimerPercentiles
+	}
+	return c.Percentiles
+}
+
+// cleanPercentiles returns a sorted copy of ps without duplicates, nor
+// percentiles outside of 0 to 1 exclusive, so that no two series are
+// exported under the same name. It keeps nil apart from an empty ps.
+func cleanPercentiles(ps []float64) []float64 {
+	if nil == ps {
+		return nil
+	}
+	clean := make([]float64, 0, len(ps))
+	for _, p := range ps {
+		if validPercentile(p) {
+			clean = append(clean, p)
+		}
+	}
+	sort.Float64s(clean)
+	n := 0
+	for _, p := range clean {
+		if 0 == n || clean[n-1] != p {
+			clean[n] = p
+			n++
+		}
+	}
+	return clean[:n]
+}
+
+func validPercentile(p float64) bool {
+	return 0 < p && p < 1
+}
+
+// Styles of percentile keys for GraphiteConfig.PercentileFormat, named after
+// how they render the 99th percentile. Apart from PercentileDefault, each
+// style forms the whole suffix of the exported series instead of being
+// substituted into ExportFormats.Percentile.
+const (
+	PercentileDefault  = ""         // 99-percentile, 999-percentile for 99.9
+	PercentileP        = "p99"      // p99, p999 for 99.9
+	PercentilePDecimal = "p99_9"    // p99, p99_9 for 99.9
+	PercentileUpper    = "upper_99" // upper_99, upper_99_9 for 99.9
+)
+
+// percentileKey returns the key of percentile p, between 0 and 1, in the
+// given style.
+func percentileKey(p float64, style string) string {
+	digits := strconv.FormatFloat(p*100.0, 'f', -1, 64)
+	switch style {
+	case PercentileP:
+		return "p" + strings.Replace(digits, ".", "", 1)
+	case PercentilePDecimal:
+		return "p" + strings.Replace(digits, ".", "_", 1)
+	case PercentileUpper:
+		return "upper_" + strings.Replace(digits, ".", "_", 1)
+	}
+	return strings.Replace(digits, ".", "", 1)
+}
+
+// appendCounterRates appends to dps the rate of every counter among them
+// which was observed by a previous flush, in changes per second since then.
+// Counters which are cleared after every flush changed by their value.
+func appendCounterRates(dps []datapoint, cleared bool) []datapoint {
+	for i, n := 0, len(dps); i < n; i++ {
+		dp := dps[i]
+		if fieldCounter != dp.field || 0 >= dp.since {
+			continue
+		}
+		delta := dp.delta
+		if cleared {
+			delta = dp.ivalue
+		}
+		dp.field, dp.fvalue = fieldCounterRate, float64(delta)/dp.since.Seconds()
+		dp.unchanged = false // A rate of zero is news when the counter stops
+		dps = append(dps, dp)
+	}
+	return dps
+}
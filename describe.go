@@ -0,0 +1,54 @@
+package graphite
+
+import (
+	"sort"
+	"time"
+)
+
+// A Description tells dashboards and alerting tools what a metric measures,
+// so that they can label their axes, see GraphiteConfig.Descriptions.
+type Description struct {
+	Unit string `json:"unit" yaml:"unit" toml:"unit"` // Unit of the values, such as "ms", "bytes" or "requests"
+	Help string `json:"help" yaml:"help" toml:"help"` // What the metric measures, such as "Latency of API requests"
+}
+
+// Descriptions maps the names of metrics to their Description.
+type Descriptions map[string]Description
+
+// snapshotDescriptions appends the companion series of Descriptions to the
+// snapshot, in the order of their names, if DescribeInterval passed since
+// they were last sent. Like the heartbeat they bypass SkipUnchanged,
+// MetricTTL and MaxDatapointsPerSecond.
+func (e *Exporter) snapshotDescriptions(prefix string, now time.Time) {
+	interval := e.config.DescribeInterval
+	if 0 == interval {
+		interval = time.Hour
+	}
+	if !e.described.IsZero() && now.Sub(e.described) < interval {
+		return
+	}
+	e.described = now
+	names := make([]string, 0, len(e.config.Descriptions))
+	for name := range e.config.Descriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d := e.config.Descriptions[name]
+		tags := make(map[string]string, 2)
+		if "" != d.Unit {
+			tags["unit"] = d.Unit
+		}
+		if "" != d.Help {
+			tags["description"] = d.Help
+		}
+		dp := datapoint{prefix: prefix, name: escapeName(name), field: fieldCustom, kind: kindCustom, key: "meta", fvalue: 1}
+		if TagModeFolded == e.config.TagMode {
+			dp.name = foldTags(dp.name, tags)
+		} else {
+			dp.tags = newTagSet(tags)
+		}
+		e.snapshot.dps = append(e.snapshot.dps, dp)
+		e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+	}
+}
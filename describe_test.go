@@ -0,0 +1,38 @@
+package graphite
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestDescriptions(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+	var b strings.Builder
+	clock := &testClock{now: time.Unix(1000, 0)}
+	e := NewExporter(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		Clock:        clock,
+		Timestamp:    func() int64 { return 1 },
+		Descriptions: Descriptions{"requests": {Unit: "requests", Help: "API requests served"}},
+	})
+	for _, expected := range []string{
+		"app.requests.count 1 1\napp.requests.meta;description=API_requests_served;unit=requests 1.000000 1\n",
+		"app.requests.count 1 1\n",
+		"app.requests.count 1 1\napp.requests.meta;description=API_requests_served;unit=requests 1.000000 1\n",
+	} {
+		b.Reset()
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+		if found := b.String(); expected != found {
+			t.Fatalf("expected %q, found %q", expected, found)
+		}
+		clock.now = clock.now.Add(30 * time.Minute)
+	}
+}
@@ -0,0 +1,386 @@
+package graphite
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// network returns the network to connect over, defaulting to UDP for the
+// statsd protocol and TCP otherwise.
+func (c *GraphiteConfig) network() string {
+	switch {
+	case "" != c.Network:
+		return c.Network
+	case ProtocolStatsD == c.Protocol:
+		return "udp"
+	}
+	return "tcp"
+}
+
+// datagrams reports whether the network sends datagrams rather than a
+// stream.
+func (c *GraphiteConfig) datagrams() bool {
+	switch c.network() {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// dial connects to addr, one of Destinations, or if it is empty to Addr, or
+// to Address if no pre-resolved address was given, over the configured
+// network, giving up after DialTimeout or when ctx is done. A failed dial
+// forgets the cached resolution so the next flush resolves Address again,
+// and counts towards BreakerFailures.
+//
+// Connections through a Dialer or proxy are made to the unresolved Address,
+// leaving its resolution to the proxy. Unix sockets are dialed at the path
+// given by Address.
+func (e *Exporter) dial(ctx context.Context, addr string) (net.Conn, error) {
+	now := e.config.clock().Now()
+	if !e.breaker.allow(&e.config, now) {
+		return nil, sendErr(ErrDial, ErrCircuitOpen)
+	}
+	var conn net.Conn
+	var err error
+	if "" != addr {
+		conn, err = e.dialAddr(ctx, addr)
+	} else {
+		addr = e.config.address()
+		conn, err = e.dialNetwork(ctx)
+	}
+	e.breaker.record(&e.config, now, err)
+	if nil != err {
+		return nil, sendErr(ErrDial, timeoutError("dial", addr, err))
+	}
+	if tc, ok := conn.(*net.TCPConn); ok && e.config.Nagle {
+		tc.SetNoDelay(false)
+	}
+	if 0 < e.config.WriteTimeout {
+		conn = &deadlineConn{Conn: conn, timeout: e.config.WriteTimeout}
+	}
+	return conn, nil
+}
+
+func (e *Exporter) dialNetwork(ctx context.Context) (net.Conn, error) {
+	if "" != e.config.SRV {
+		return e.dialSRV(ctx)
+	}
+	network := e.config.network()
+	if d, err := e.config.dialer(); nil != err {
+		return nil, err
+	} else if nil != d {
+		return dialContext(ctx, d, network, e.config.address())
+	}
+	d := &net.Dialer{Timeout: e.config.DialTimeout, KeepAlive: e.config.KeepAlive, FallbackDelay: e.config.FallbackDelay}
+	switch network {
+	case "unix", "unixgram":
+		return d.DialContext(ctx, network, e.config.Address)
+	}
+	addrs, err := e.resolve(ctx)
+	if nil != err {
+		return nil, err
+	}
+	conn, err := dialParallel(ctx, d, network, addrs, e.config.FallbackDelay)
+	if nil != err {
+		e.resolver.Lock()
+		e.addr = nil
+		e.resolver.Unlock()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// address returns the address to connect to, without resolving it.
+func (c *GraphiteConfig) address() string {
+	switch {
+	case nil != c.Addr:
+		return c.Addr.String()
+	case "" != c.SRV:
+		return c.SRV
+	}
+	return c.Address
+}
+
+// lookupSRV resolves SRV records, replaced by tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// dialSRV connects to the first of the servers SRV resolves to which accepts
+// the connection, in the order of their priorities, shuffled by weight. The
+// servers are resolved again once ResolveTTL has passed, or after failing
+// to connect to any of them.
+func (e *Exporter) dialSRV(ctx context.Context) (net.Conn, error) {
+	addrs, err := e.resolveSRV(ctx)
+	if nil != err {
+		return nil, err
+	}
+	var conn net.Conn
+	for _, addr := range addrs {
+		if conn, err = e.dialAddr(ctx, addr); nil == err {
+			return conn, nil
+		}
+	}
+	e.resolver.Lock()
+	e.srv = nil
+	e.resolver.Unlock()
+	return nil, err
+}
+
+// resolveSRV returns the host:port addresses of the servers SRV resolves to,
+// reusing those resolved less than ResolveTTL ago.
+func (e *Exporter) resolveSRV(ctx context.Context) ([]string, error) {
+	e.resolver.Lock()
+	defer e.resolver.Unlock()
+	now := e.config.clock().Now()
+	if nil != e.srv && now.Sub(e.resolved) < e.config.ResolveTTL {
+		return e.srv, nil
+	}
+	_, records, err := lookupSRV(ctx, "", "", e.config.SRV)
+	if nil != err {
+		return nil, err
+	}
+	if 0 == len(records) {
+		return nil, &net.DNSError{Err: "no SRV records", Name: e.config.SRV, IsNotFound: true}
+	}
+	addrs := make([]string, len(records))
+	for i, r := range records {
+		addrs[i] = net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+	}
+	e.srv, e.resolved = addrs, now
+	return addrs, nil
+}
+
+// dialAddr connects to the host:port address addr, through the Dialer or
+// proxy if one is configured.
+func (e *Exporter) dialAddr(ctx context.Context, addr string) (net.Conn, error) {
+	network := e.config.network()
+	if d, err := e.config.dialer(); nil != err {
+		return nil, err
+	} else if nil != d {
+		return dialContext(ctx, d, network, addr)
+	}
+	d := &net.Dialer{Timeout: e.config.DialTimeout, KeepAlive: e.config.KeepAlive, FallbackDelay: e.config.FallbackDelay}
+	return d.DialContext(ctx, network, addr)
+}
+
+// An addrList holds the addresses Address resolved to, split like Happy
+// Eyeballs into those of the family of the first one, which are dialed
+// first, and those of the other family, which are dialed in parallel once
+// the first ones did not connect within FallbackDelay.
+type addrList struct {
+	primaries, fallbacks []string
+}
+
+// lookupIPAddr resolves host names, replaced by tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// resolve returns the addresses to connect to, resolving Address when there
+// is no cached resolution younger than ResolveTTL.
+func (e *Exporter) resolve(ctx context.Context) (*addrList, error) {
+	if nil != e.config.Addr {
+		return &addrList{primaries: []string{e.config.Addr.String()}}, nil
+	}
+	e.resolver.Lock()
+	defer e.resolver.Unlock()
+	now := e.config.clock().Now()
+	if nil != e.addr && now.Sub(e.resolved) < e.config.ResolveTTL {
+		return e.addr, nil
+	}
+	addrs, err := resolveAddrs(ctx, e.config.network(), e.config.Address)
+	if nil != err {
+		return nil, err
+	}
+	e.addr, e.resolved = addrs, now
+	return addrs, nil
+}
+
+// resolveAddrs resolves the host:port address to the addresses of network
+// it stands for, IPv4 or IPv6 only if network is "tcp4", "tcp6", "udp4" or
+// "udp6". The host may be an IPv6 literal, with a zone.
+func resolveAddrs(ctx context.Context, network, address string) (*addrList, error) {
+	host, service, err := net.SplitHostPort(address)
+	if nil != err {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, network, service)
+	if nil != err {
+		return nil, err
+	}
+	var ips []net.IPAddr
+	zone := ""
+	if i := strings.LastIndexByte(host, '%'); 0 <= i {
+		host, zone = host[:i], host[i+1:]
+	}
+	if ip := net.ParseIP(host); nil != ip {
+		ips = []net.IPAddr{{IP: ip, Zone: zone}}
+	} else if ips, err = lookupIPAddr(ctx, host); nil != err {
+		return nil, err
+	}
+	a := &addrList{}
+	primaryV4 := false
+	for _, ip := range ips {
+		v4 := nil != ip.IP.To4()
+		if strings.HasSuffix(network, "4") && !v4 || strings.HasSuffix(network, "6") && v4 {
+			continue
+		}
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		switch {
+		case 0 == len(a.primaries):
+			primaryV4 = v4
+			fallthrough
+		case primaryV4 == v4:
+			a.primaries = append(a.primaries, addr)
+		default:
+			a.fallbacks = append(a.fallbacks, addr)
+		}
+	}
+	if 0 == len(a.primaries) {
+		return nil, &net.AddrError{Err: "no suitable address", Addr: host}
+	}
+	return a, nil
+}
+
+// dialParallel connects to the first of addrs which accepts the connection,
+// dialing their primaries in turn and, once they failed or did not connect
+// within delay, their fallbacks in parallel, like net.Dialer does for host
+// names. A negative delay dials the fallbacks after the primaries, as do
+// datagram networks, which connect without waiting for the server.
+func dialParallel(ctx context.Context, d *net.Dialer, network string, addrs *addrList, delay time.Duration) (net.Conn, error) {
+	if 0 == len(addrs.fallbacks) || 0 > delay || strings.HasPrefix(network, "udp") {
+		return dialSerial(ctx, d, network, append(append([]string(nil), addrs.primaries...), addrs.fallbacks...))
+	}
+	if 0 == delay {
+		delay = 300 * time.Millisecond
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	dial := func(list []string) {
+		conn, err := dialSerial(ctx, d, network, list)
+		results <- result{conn, err}
+	}
+	go dial(addrs.primaries)
+	pending, fallback := 1, time.NewTimer(delay)
+	defer fallback.Stop()
+	started := false
+	var first error
+	for {
+		select {
+		case <-fallback.C:
+		case r := <-results:
+			pending--
+			if nil == r.err {
+				go func(n int) {
+					for ; 0 < n; n-- {
+						if r := <-results; nil == r.err {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if nil == first {
+				first = r.err
+			}
+			if started && 0 == pending {
+				return nil, first
+			}
+		}
+		if !started {
+			started = true
+			pending++
+			go dial(addrs.fallbacks)
+		}
+	}
+}
+
+// dialSerial connects to the first of addrs which accepts the connection,
+// returning the error of the first one otherwise.
+func dialSerial(ctx context.Context, d *net.Dialer, network string, addrs []string) (net.Conn, error) {
+	var first error
+	for _, addr := range addrs {
+		conn, err := d.DialContext(ctx, network, addr)
+		if nil == err {
+			return conn, nil
+		}
+		if nil == first {
+			first = err
+		}
+		if nil != ctx.Err() {
+			break
+		}
+	}
+	return nil, first
+}
+
+// A TimeoutError is returned by flushes which gave up connecting to or
+// writing to the server after DialTimeout or WriteTimeout.
+type TimeoutError struct {
+	Op   string // "dial" or "write"
+	Addr string // Address of the server
+	Err  error  // Error of the connection
+}
+
+func (e *TimeoutError) Error() string {
+	return "graphite: " + e.Op + " " + e.Addr + " timed out: " + e.Err.Error()
+}
+
+// Timeout reports true, so that a TimeoutError satisfies net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary reports true, as the next flush may well succeed.
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return ErrTimeout == target
+}
+
+// timeoutError returns err as a TimeoutError if it is a timeout, and err
+// unchanged otherwise.
+func timeoutError(op, addr string, err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return &TimeoutError{Op: op, Addr: addr, Err: err}
+	}
+	return err
+}
+
+// A deadlineConn limits each write to a connection to timeout.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Write(b)
+	if nil != err {
+		err = timeoutError("write", c.Conn.RemoteAddr().String(), err)
+	}
+	return n, err
+}
+
+// writeBuffers writes bufs with a single deadline for all of them.
+func (c *deadlineConn) writeBuffers(bufs *net.Buffers) (int64, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	n, err := bufs.WriteTo(c.Conn)
+	if nil != err {
+		err = timeoutError("write", c.Conn.RemoteAddr().String(), err)
+	}
+	return n, err
+}
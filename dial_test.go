@@ -0,0 +1,336 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// pipeDialer connects to a peer which never reads.
+type pipeDialer struct{}
+
+func (pipeDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, _ := net.Pipe()
+	return conn, nil
+}
+
+func TestWriteTimeout(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:     r,
+		Address:      "graphite:2003",
+		Dialer:       pipeDialer{},
+		WriteTimeout: 10 * time.Millisecond,
+	})
+	var te *TimeoutError
+	if !errors.As(err, &te) || "write" != te.Op {
+		t.Fatal("expected a write timeout:", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// A proxy which accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if nil != err {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	err = GraphiteOnce(GraphiteConfig{
+		Registry:    metrics.NewRegistry(),
+		Address:     "graphite:2003",
+		ProxyURL:    "socks5://" + ln.Addr().String(),
+		DialTimeout: 10 * time.Millisecond,
+	})
+	var te *TimeoutError
+	if !errors.As(err, &te) || "dial" != te.Op || "graphite:2003" != te.Addr {
+		t.Fatal("expected a dial timeout:", err)
+	}
+}
+
+func TestPersistentConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan bool, 10)
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if nil != err {
+				return
+			}
+			accepted <- true
+			go func() {
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if nil != err {
+						return
+					}
+					lines <- line
+				}
+			}()
+		}
+	}()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	e := NewExporter(GraphiteConfig{
+		Registry:             r,
+		Addr:                 ln.Addr().(*net.TCPAddr),
+		Prefix:               "app",
+		PersistentConnection: true,
+		KeepAlive:            time.Second,
+		Nagle:                true,
+	})
+	for i := 0; i < 2; i++ {
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+		<-lines
+	}
+	if 1 != len(accepted) {
+		t.Fatal("expected a single connection, found", len(accepted))
+	}
+
+	if err := e.Close(); nil != err {
+		t.Fatal(err)
+	}
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	<-lines
+	if 2 != len(accepted) {
+		t.Fatal("expected a new connection after Close, found", len(accepted))
+	}
+}
+
+func TestGraphiteOnceContext(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := GraphiteOnceContext(ctx, GraphiteConfig{
+		Registry: r,
+		Address:  "graphite:2003",
+		Dialer:   pipeDialer{},
+	})
+	if context.DeadlineExceeded != err {
+		t.Fatal("expected the deadline to interrupt writing:", err)
+	}
+
+	// A proxy which accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if nil != err {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err = GraphiteOnceContext(ctx, GraphiteConfig{
+		Registry: r,
+		Address:  "graphite:2003",
+		ProxyURL: "socks5://" + ln.Addr().String(),
+	})
+	if context.Canceled != err {
+		t.Fatal("expected cancellation to interrupt dialing:", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	clock := &testClock{now: time.Unix(1000, 0)}
+	e := NewExporter(GraphiteConfig{
+		Registry:        r,
+		Addr:            ln.Addr().(*net.TCPAddr),
+		Clock:           clock,
+		BreakerFailures: 2,
+		BreakerCooldown: time.Minute,
+	})
+	for i := 0; i < 2; i++ {
+		if err := e.Once(); nil == err || errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("expected a failed dial:", err)
+		}
+	}
+	if err := e.Once(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected the breaker to be open:", err)
+	}
+	if expected, found := uint64(1), e.CircuitDropped(); expected != found {
+		t.Fatal("bad dropped count:", expected, found)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if err := e.Once(); nil == err || errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected a probing dial after the cooldown:", err)
+	}
+	if err := e.Once(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected the breaker to open again after a failed probe:", err)
+	}
+}
+
+func TestSRV(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	closed.Close()
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	port := func(a net.Addr) uint16 { return uint16(a.(*net.TCPAddr).Port) }
+	lookups := 0
+	defer func(f func(context.Context, string, string, string) (string, []*net.SRV, error)) { lookupSRV = f }(lookupSRV)
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if "_carbon._tcp.metrics.internal" != name {
+			t.Errorf("bad SRV name %q", name)
+		}
+		lookups++
+		return "", []*net.SRV{
+			{Target: "127.0.0.1.", Port: port(closed.Addr()), Priority: 1},
+			{Target: "127.0.0.1.", Port: port(l.Addr()), Priority: 2},
+		}, nil
+	}
+
+	c.Addr = nil
+	c.SRV = "_carbon._tcp.metrics.internal"
+	c.ResolveTTL = time.Hour
+	e := NewExporter(c)
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+		wg.Wait()
+	}
+
+	if expected, found := 4.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if 1 != lookups {
+		t.Fatal("expected the SRV records to be cached within ResolveTTL:", lookups)
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if "carbon.internal" != host {
+			t.Errorf("bad host %q", host)
+		}
+		// The IPv6 address is not listened on, so the IPv4 one is the
+		// fallback connected to.
+		return []net.IPAddr{{IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	addrs, err := resolveAddrs(context.Background(), "tcp", "carbon.internal:2003")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected := (addrList{[]string{"[::1]:2003"}, []string{"127.0.0.1:2003"}}); !reflect.DeepEqual(expected, *addrs) {
+		t.Fatal("bad addresses:", expected, *addrs)
+	}
+	if addrs, err := resolveAddrs(context.Background(), "tcp4", "carbon.internal:2003"); nil != err || 0 != len(addrs.fallbacks) || "127.0.0.1:2003" != addrs.primaries[0] {
+		t.Fatal("bad IPv4 addresses:", addrs, err)
+	}
+	if addrs, err := resolveAddrs(context.Background(), "tcp", "[fe80::1%eth0]:2003"); nil != err || "[fe80::1%eth0]:2003" != addrs.primaries[0] {
+		t.Fatal("bad IPv6 literal:", addrs, err)
+	}
+
+	c.Address = net.JoinHostPort("carbon.internal", strconv.Itoa(l.Addr().(*net.TCPAddr).Port))
+	c.Addr = nil
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if nil != err {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		lines <- string(b)
+	}()
+	c := GraphiteConfig{
+		Registry:      metrics.NewRegistry(),
+		Address:       ln.Addr().String(),
+		Prefix:        "app",
+		FlushInterval: time.Second,
+		DurationUnit:  time.Millisecond,
+		Clock:         &testClock{now: time.Unix(1000, 0)},
+		PingDatapoint: true,
+	}
+	if err := Ping(c); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.exporter.ping 1.000000 1000\n", <-lines; expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	ln.Close()
+	if err := Ping(c); !errors.Is(err, ErrDial) || !strings.Contains(err.Error(), c.Address) {
+		t.Fatal("expected an error connecting to", c.Address, err)
+	}
+	c.FlushInterval = 0
+	if err := Ping(c); nil == err || errors.Is(err, ErrDial) {
+		t.Fatal("expected the configuration to be invalid:", err)
+	}
+}
@@ -0,0 +1,233 @@
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Protocols for GraphiteConfig.Protocol.
+const (
+	ProtocolPlaintext   = ""                        // Graphite's plaintext protocol, formatted by ExportFormats
+	ProtocolInflux      = "influx"                  // InfluxDB line protocol
+	ProtocolOpenTSDB    = "opentsdb"                // OpenTSDB telnet protocol
+	ProtocolStatsD      = "statsd"                  // statsd datagrams, sent over UDP
+	ProtocolRemoteWrite = "prometheus-remote-write" // Prometheus remote write, POSTed to URL
+	ProtocolJSON        = "json"                    // One JSON object per line, with name, value and timestamp
+)
+
+// An encoder writes the datapoints of one metric in a wire protocol.
+type encoder interface {
+	encode(dps []datapoint, now int64)
+	formatErr(dps []datapoint) error
+	sweep()
+}
+
+func newEncoder(w io.Writer, c *GraphiteConfig) encoder {
+	switch c.Protocol {
+	case ProtocolInflux:
+		return &influxEncoder{w: w, namer: newNamer(c), tags: c.Tags}
+	case ProtocolOpenTSDB:
+		return newOpenTSDBEncoder(w, c)
+	case ProtocolStatsD:
+		return &statsdEncoder{w: w, du: float64(c.DurationUnit), namer: newNamer(c)}
+	case ProtocolRemoteWrite:
+		return newRemoteWriteEncoder(w, c)
+	case ProtocolJSON:
+		return &jsonEncoder{w: w, namer: newNamer(c)}
+	}
+	if nil != c.Codec {
+		return &codecEncoder{w: w, codec: c.Codec, onLine: c.OnLine, namer: newNamer(c)}
+	}
+	return &plaintextEncoder{w: w, onLine: c.OnLine, namer: newNamer(c)}
+}
+
+// A plaintextEncoder writes datapoints in Graphite's plaintext protocol.
+type plaintextEncoder struct {
+	w      io.Writer
+	onLine LineFunc
+	value  []byte
+	namer
+}
+
+func (enc *plaintextEncoder) encode(dps []datapoint, now int64) {
+	for i := range dps {
+		if nil != enc.onLine {
+			enc.value = enc.appendValue(enc.value[:0], &dps[i])
+			enc.onLine(enc.path(&dps[i])+dps[i].tags.key(), string(enc.value), now)
+		}
+		enc.line(enc.w, &dps[i], now)
+	}
+}
+
+// A namer renders datapoints with the format strings in ExportFormats,
+// applying the naming options of a GraphiteConfig.
+type namer struct {
+	suffixes   map[string]string // See GraphiteConfig.SuffixMap
+	percentile string            // Format of percentiles, overriding ExportFormats
+	templates  map[templateKey]*template
+	invalid    map[templateKey]bool // Format strings fmt cannot render
+	bad        string               // Invalid format string used since the previous formatErr
+	buf, tmp   []byte
+	cache      nameCache            // Names of the series encoded by recent flushes
+	digits     int                  // See GraphiteConfig.FloatPrecision
+	formats    *ExportFormatStrings // See GraphiteConfig.Naming, nil for ExportFormats
+	join       Namer                // See GraphiteConfig.Namer, nil for the layout of the format strings
+	roots      bool                 // See GraphiteConfig.TypeRoots, only set along with join
+}
+
+func newNamer(c *GraphiteConfig) namer {
+	n := namer{suffixes: c.SuffixMap, digits: c.FloatPrecision}
+	if ProtocolPlaintext == c.Protocol {
+		n.join, n.roots = c.Namer, c.TypeRoots
+		if n.roots && nil == n.join {
+			n.join = DefaultNamer
+		}
+		if nil == n.join {
+			n.formats = namingFormats[c.Naming]
+		}
+	}
+	if PercentileDefault != c.PercentileFormat {
+		n.percentile = "%s.%s.%s %.2f %d\n"
+	}
+	return n
+}
+
+// format returns the format string dp is rendered with.
+func (n *namer) format(dp *datapoint) string {
+	if fieldPercentile == dp.field && "" != n.percentile {
+		return n.percentile
+	}
+	if nil != n.formats {
+		if format := n.formats.format(dp.field); "" != format {
+			return format
+		}
+	}
+	return dp.field.format()
+}
+
+// formatErr returns an error for the metric of dps if any of them was
+// rendered with an invalid format string since the previous call.
+func (n *namer) formatErr(dps []datapoint) error {
+	if "" == n.bad {
+		return nil
+	}
+	err := &MetricError{Name: dps[0].fullName(), Err: fmt.Errorf("bad format string %q", n.bad)}
+	n.bad = ""
+	return err
+}
+
+// line writes dp to w as a plaintext line with the timestamp now, followed
+// by its Graphite tags, if any.
+func (n *namer) line(w io.Writer, dp *datapoint, now int64) {
+	if nil != n.join {
+		path := n.path(dp)
+		n.buf = n.appendFormat(n.buf[:0], dp, n.format(dp), now)
+		end := bytes.IndexByte(n.buf, ' ')
+		if end < 0 {
+			end = len(n.buf)
+		}
+		n.tmp = append(append(append(n.tmp[:0], path...), dp.tags.key()...), n.buf[end:]...)
+		w.Write(n.tmp)
+		return
+	}
+	n.buf = n.appendFormat(n.buf[:0], dp, n.format(dp), now)
+	line := n.buf
+	end := bytes.IndexByte(line, ' ')
+	if end < 0 {
+		end = len(line)
+	}
+	suffix, mapped := n.mapSuffix(dp, line[:end])
+	if mapped || nil != dp.tags {
+		n.tmp = append(n.tmp[:0], line[:end]...)
+		if mapped {
+			n.tmp = append(n.tmp[:len(dp.prefix)+len(dp.name)+2], suffix...)
+		}
+		n.tmp = append(append(n.tmp, dp.tags.key()...), line[end:]...)
+		line = n.tmp
+	}
+	w.Write(line)
+}
+
+// mapSuffix returns the replacement SuffixMap holds for the suffix of
+// path, the series name of dp, if any.
+func (n *namer) mapSuffix(dp *datapoint, path []byte) (string, bool) {
+	if 0 == len(n.suffixes) {
+		return "", false
+	}
+	base := len(dp.prefix) + len(dp.name) + 2
+	if len(path) <= base || !hasSeriesPrefix(path, dp) {
+		return "", false
+	}
+	suffix, ok := n.suffixes[string(path[base:])]
+	return suffix, ok
+}
+
+// hasSeriesPrefix reports whether path starts with the prefix and name of
+// dp, each followed by a dot.
+func hasSeriesPrefix(path []byte, dp *datapoint) bool {
+	p := len(dp.prefix)
+	return string(path[:p]) == dp.prefix && '.' == path[p] &&
+		string(path[p+1:p+1+len(dp.name)]) == dp.name && '.' == path[p+1+len(dp.name)]
+}
+
+// path returns the plaintext series name of dp.
+func (n *namer) path(dp *datapoint) string {
+	k := nameKey{kind: namePath, s: dp.series()}
+	if name, ok := n.cache.get(k); ok {
+		return name
+	}
+	if nil != n.join {
+		name := dp.name
+		if n.roots {
+			name = typeRoots[dp.kind] + name
+		}
+		name = n.join.Join(dp.prefix, name, n.suffix(dp))
+		if "" == n.bad {
+			n.cache.put(k, name)
+		}
+		return name
+	}
+	n.buf = n.appendFormat(n.buf[:0], dp, n.format(dp), 0)
+	path := n.buf
+	if end := bytes.IndexByte(path, ' '); end >= 0 {
+		path = path[:end]
+	}
+	name := string(path)
+	if suffix, ok := n.mapSuffix(dp, path); ok {
+		name = dp.prefix + "." + dp.name + "." + suffix
+	}
+	if "" == n.bad {
+		n.cache.put(k, name)
+	}
+	return name
+}
+
+// suffix returns the last part of the plaintext series name of dp, such
+// as "count" or "99-percentile", which names the field dp represents in
+// protocols which don't use dotted paths.
+func (n *namer) suffix(dp *datapoint) string {
+	k := nameKey{kind: nameSuffix, s: series{field: dp.field, key: dp.key}}
+	if name, ok := n.cache.get(k); ok {
+		return name
+	}
+	n.buf = n.appendFormat(n.buf[:0], &datapoint{field: dp.field, key: dp.key}, n.format(dp), 0)
+	path := n.buf
+	if end := bytes.IndexByte(path, ' '); end >= 0 {
+		path = path[:end]
+	}
+	suffix := string(bytes.TrimPrefix(path, []byte("..")))
+	if s, ok := n.suffixes[suffix]; ok {
+		suffix = s
+	}
+	if "" == n.bad {
+		n.cache.put(k, suffix)
+	}
+	return suffix
+}
+
+// sweep drops the cached names of the series which were not encoded since
+// the previous sweep.
+func (n *namer) sweep() {
+	n.cache.sweep()
+}
@@ -0,0 +1,136 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+)
+
+// An Endpoint is a destination sent the registries of an Exporter along with
+// its own, with settings of its own, such as plaintext to an on-premise
+// carbon server and tagged HTTP to hosted Graphite. Its zero fields keep the
+// values of the GraphiteConfig; setting any of Address, URL or Sink replaces
+// every address of it.
+//
+// Prefix replaces that of the primary Registry and of the series of the
+// exporter itself; Registries keep theirs. Each Endpoint keeps its own
+// connections and state, such as for SkipUnchanged, and its errors are
+// logged rather than returned, so that an unreachable Endpoint does not fail
+// the flushes of the others. With ResetAfterFlush, metrics are reset once
+// the GraphiteConfig's destination was sent them, after every Endpoint took
+// its snapshot.
+type Endpoint struct {
+	Name            string            // Names the endpoint in logged errors, its address if empty
+	Address         string            // host:port or unix socket path to connect to
+	URL             string            // Endpoint of HTTP based protocols
+	Sink            Sink              // Destination of flushes instead of Address or URL
+	Protocol        string            // Wire protocol, one of the Protocol constants
+	Transport       string            // How metrics are sent, one of the Transport constants
+	Prefix          string            // Prefix of the primary Registry, may contain placeholders
+	Tags            map[string]string // Tags added to every metric by protocols which support them
+	Filter          FilterFunc        // Selects the metrics sent to the endpoint by name
+	HistogramFields []string          // Fields exported for histograms
+	MeterFields     []string          // Fields exported for meters
+	TimerFields     []string          // Fields exported for timers
+	ExcludeFields   []string          // Fields no type of metric exports
+	Codec           Codec             // Wire format of the plaintext protocol, that of the exporter if nil
+}
+
+// A FilterFunc reports whether the named metric is exported, such as to
+// send only business metrics to a hosted service.
+type FilterFunc func(name string) bool
+
+// config returns the configuration of an exporter sending to p, that of c
+// with the settings of p. Hooks of c are left to the exporter of c, so that
+// they are called once per flush.
+func (p *Endpoint) config(c GraphiteConfig) GraphiteConfig {
+	c.Endpoints, c.Mirror, c.Annotations = nil, nil, nil
+	c.BeforeFlush, c.AfterFlush, c.OnFailure = nil, nil, nil
+	if "" != p.Address || "" != p.URL || nil != p.Sink {
+		c.Addr, c.SRV, c.Destinations = nil, "", nil
+		c.Address, c.URL, c.Sink = p.Address, p.URL, p.Sink
+	}
+	for _, s := range []struct {
+		dst *string
+		src string
+	}{
+		{&c.Protocol, p.Protocol},
+		{&c.Transport, p.Transport},
+		{&c.Prefix, p.Prefix},
+	} {
+		if "" != s.src {
+			*s.dst = s.src
+		}
+	}
+	if nil != p.Tags {
+		c.Tags = p.Tags
+	}
+	if nil != p.Filter {
+		c.Filter = p.Filter
+	}
+	if nil != p.Codec || "" != p.Protocol {
+		c.Codec = p.Codec
+	}
+	for _, s := range []struct {
+		dst *[]string
+		src []string
+	}{
+		{&c.HistogramFields, p.HistogramFields},
+		{&c.MeterFields, p.MeterFields},
+		{&c.TimerFields, p.TimerFields},
+		{&c.ExcludeFields, p.ExcludeFields},
+	} {
+		if nil != s.src {
+			*s.dst = s.src
+		}
+	}
+	return c
+}
+
+// An endpointExporter is the exporter of an Endpoint, along with its name in
+// logged errors.
+type endpointExporter struct {
+	name string
+	e    *Exporter
+}
+
+// newEndpoints returns the exporters of the Endpoints of c, named by their
+// Name, URL, Address or index.
+func newEndpoints(c GraphiteConfig) []endpointExporter {
+	if 0 == len(c.Endpoints) {
+		return nil
+	}
+	es := make([]endpointExporter, len(c.Endpoints))
+	for i := range c.Endpoints {
+		p := &c.Endpoints[i]
+		es[i].name = p.Name
+		for _, name := range []string{p.URL, p.Address, fmt.Sprint(i)} {
+			if "" == es[i].name {
+				es[i].name = name
+			}
+		}
+		es[i].e = NewExporter(p.config(c))
+		es[i].e.endpoint = true
+	}
+	return es
+}
+
+// flushEndpoints flushes every Endpoint, logging their errors.
+func (e *Exporter) flushEndpoints(ctx context.Context, async bool) {
+	for _, ep := range e.endpoints {
+		if err := ep.e.flush(ctx, async); nil != err {
+			e.logError(fmt.Errorf("graphite: endpoint %s: %w", ep.name, err))
+		}
+	}
+}
+
+// closeEndpoints closes the connections of every Endpoint, returning the
+// first error.
+func (e *Exporter) closeEndpoints() error {
+	var err error
+	for _, ep := range e.endpoints {
+		if cerr := ep.e.Close(); nil == err {
+			err = cerr
+		}
+	}
+	return err
+}
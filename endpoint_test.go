@@ -0,0 +1,42 @@
+package graphite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestEndpoints(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterCounter("bar", r).Inc(3)
+
+	var primary, hosted strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:        r,
+		Prefix:          "app",
+		Sink:            WriterSink(&primary),
+		Timestamp:       func() int64 { return 1 },
+		SortedOutput:    true,
+		ResetAfterFlush: true,
+		Endpoints: []Endpoint{{
+			Sink:     WriterSink(&hosted),
+			Prefix:   "hosted",
+			Protocol: ProtocolJSON,
+			Filter:   func(name string) bool { return "foo" == name },
+		}},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.bar.count 3 1\napp.foo.count 2 1\n", primary.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+	if found := hosted.String(); !strings.Contains(found, `"hosted.foo.count"`) || strings.Contains(found, "bar") {
+		t.Fatalf("bad endpoint output: %q", found)
+	}
+	if found := metrics.GetOrRegisterCounter("foo", r).Count(); 0 != found {
+		t.Fatal("counter not reset:", found)
+	}
+}
@@ -0,0 +1,173 @@
+package graphite
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFromEnv returns a GraphiteConfig read from environment variables,
+// for services configured by their environment. Its Registry is left for
+// the caller to set. The variables and the settings they hold are:
+//
+//	GRAPHITE_ADDR             Address, such as "graphite:2003"
+//	GRAPHITE_SRV              SRV
+//	GRAPHITE_DESTINATIONS     Destinations, separated by commas
+//	GRAPHITE_URL              URL
+//	GRAPHITE_PROTOCOL         Protocol
+//	GRAPHITE_TRANSPORT        Transport
+//	GRAPHITE_PREFIX           Prefix
+//	GRAPHITE_FLUSH_INTERVAL   FlushInterval, such as "10s"; ten seconds if unset
+//	GRAPHITE_DURATION_UNIT    DurationUnit, such as "1ms"; nanoseconds if unset
+//	GRAPHITE_PERCENTILES      Percentiles between 0 and 1, separated by commas; those of Graphite if unset
+//	GRAPHITE_TAGS             Tags, as key=value pairs separated by commas
+//	GRAPHITE_API_KEY          APIKey
+//	GRAPHITE_NAMING           Naming
+//	GRAPHITE_DIAL_TIMEOUT     DialTimeout
+//	GRAPHITE_WRITE_TIMEOUT    WriteTimeout
+//	GRAPHITE_PERSISTENT       PersistentConnection, such as "true"
+//
+// It returns an error naming the first variable which cannot be parsed, or
+// if none of the variables says where to send metrics.
+func ConfigFromEnv() (GraphiteConfig, error) {
+	c := GraphiteConfig{
+		FlushInterval: 10 * time.Second,
+		DurationUnit:  time.Nanosecond,
+		Percentiles:   []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+	}
+	env := envParser{}
+	env.string("GRAPHITE_ADDR", &c.Address)
+	env.string("GRAPHITE_SRV", &c.SRV)
+	env.list("GRAPHITE_DESTINATIONS", &c.Destinations)
+	env.string("GRAPHITE_URL", &c.URL)
+	env.string("GRAPHITE_PROTOCOL", &c.Protocol)
+	env.string("GRAPHITE_TRANSPORT", &c.Transport)
+	env.string("GRAPHITE_PREFIX", &c.Prefix)
+	env.duration("GRAPHITE_FLUSH_INTERVAL", &c.FlushInterval)
+	env.duration("GRAPHITE_DURATION_UNIT", &c.DurationUnit)
+	env.percentiles("GRAPHITE_PERCENTILES", &c.Percentiles)
+	env.tags("GRAPHITE_TAGS", &c.Tags)
+	env.string("GRAPHITE_API_KEY", &c.APIKey)
+	env.string("GRAPHITE_NAMING", &c.Naming)
+	env.duration("GRAPHITE_DIAL_TIMEOUT", &c.DialTimeout)
+	env.duration("GRAPHITE_WRITE_TIMEOUT", &c.WriteTimeout)
+	env.bool("GRAPHITE_PERSISTENT", &c.PersistentConnection)
+	if nil != env.err {
+		return c, env.err
+	}
+	if 0 >= c.FlushInterval {
+		return c, envError("GRAPHITE_FLUSH_INTERVAL", "must be positive")
+	}
+	if 0 >= c.DurationUnit {
+		return c, envError("GRAPHITE_DURATION_UNIT", "must be positive")
+	}
+	switch c.Protocol {
+	case ProtocolPlaintext, ProtocolInflux, ProtocolOpenTSDB, ProtocolStatsD, ProtocolRemoteWrite, ProtocolJSON:
+	default:
+		return c, envError("GRAPHITE_PROTOCOL", fmt.Sprintf("%q is not a known protocol", c.Protocol))
+	}
+	if _, ok := namingFormats[c.Naming]; !ok && NamingDefault != c.Naming {
+		return c, envError("GRAPHITE_NAMING", fmt.Sprintf("%q is not a known naming convention", c.Naming))
+	}
+	if "" == c.Address && "" == c.SRV && 0 == len(c.Destinations) && "" == c.URL && TransportStdout != c.Transport && TransportDiscard != c.Transport {
+		return c, fmt.Errorf("graphite: none of GRAPHITE_ADDR, GRAPHITE_SRV, GRAPHITE_DESTINATIONS and GRAPHITE_URL is set")
+	}
+	return c, nil
+}
+
+func envError(name, msg string) error {
+	return fmt.Errorf("graphite: %s %s", name, msg)
+}
+
+// An envParser parses environment variables into the fields of a
+// GraphiteConfig, leaving those of unset variables unchanged and keeping the
+// first error.
+type envParser struct {
+	err error
+}
+
+// lookup returns the value of the named variable, if it is set to anything
+// but spaces and no error occurred yet.
+func (p *envParser) lookup(name string) (string, bool) {
+	if nil != p.err {
+		return "", false
+	}
+	v := strings.TrimSpace(os.Getenv(name))
+	return v, "" != v
+}
+
+func (p *envParser) fail(name, v string, err error) {
+	p.err = envError(name, fmt.Sprintf("%q is invalid: %v", v, err))
+}
+
+func (p *envParser) string(name string, s *string) {
+	if v, ok := p.lookup(name); ok {
+		*s = v
+	}
+}
+
+func (p *envParser) list(name string, l *[]string) {
+	if v, ok := p.lookup(name); ok {
+		*l = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); "" != s {
+				*l = append(*l, s)
+			}
+		}
+	}
+}
+
+func (p *envParser) duration(name string, d *time.Duration) {
+	if v, ok := p.lookup(name); ok {
+		var err error
+		if *d, err = time.ParseDuration(v); nil != err {
+			p.fail(name, v, err)
+		}
+	}
+}
+
+func (p *envParser) bool(name string, b *bool) {
+	if v, ok := p.lookup(name); ok {
+		var err error
+		if *b, err = strconv.ParseBool(v); nil != err {
+			p.fail(name, v, err)
+		}
+	}
+}
+
+func (p *envParser) percentiles(name string, ps *[]float64) {
+	var l []string
+	if p.list(name, &l); nil == l {
+		return
+	}
+	*ps = nil
+	for _, s := range l {
+		f, err := strconv.ParseFloat(s, 64)
+		if nil == err && !validPercentile(f) {
+			err = fmt.Errorf("%v is not between 0 and 1 exclusive", f)
+		}
+		if nil != err {
+			p.fail(name, s, err)
+			return
+		}
+		*ps = append(*ps, f)
+	}
+}
+
+func (p *envParser) tags(name string, tags *map[string]string) {
+	var l []string
+	if p.list(name, &l); nil == l {
+		return
+	}
+	*tags = make(map[string]string, len(l))
+	for _, s := range l {
+		k, v, ok := strings.Cut(s, "=")
+		if k, v = strings.TrimSpace(k), strings.TrimSpace(v); !ok || "" == k {
+			p.fail(name, s, fmt.Errorf("expected key=value"))
+			return
+		}
+		(*tags)[k] = v
+	}
+}
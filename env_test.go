@@ -0,0 +1,46 @@
+package graphite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("GRAPHITE_ADDR", "graphite:2003")
+	t.Setenv("GRAPHITE_PREFIX", "app.%h")
+	t.Setenv("GRAPHITE_FLUSH_INTERVAL", "30s")
+	t.Setenv("GRAPHITE_PERCENTILES", "0.5, 0.99")
+	t.Setenv("GRAPHITE_TAGS", "env=prod,dc=eu")
+	t.Setenv("GRAPHITE_PERSISTENT", "true")
+	c, err := ConfigFromEnv()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if "graphite:2003" != c.Address || "app.%h" != c.Prefix || 30*time.Second != c.FlushInterval || time.Nanosecond != c.DurationUnit || !c.PersistentConnection {
+		t.Fatalf("bad config: %+v", c)
+	}
+	if expected := []float64{0.5, 0.99}; !reflect.DeepEqual(expected, c.Percentiles) {
+		t.Fatalf("expected %v, found %v", expected, c.Percentiles)
+	}
+	if expected := map[string]string{"env": "prod", "dc": "eu"}; !reflect.DeepEqual(expected, c.Tags) {
+		t.Fatalf("expected %v, found %v", expected, c.Tags)
+	}
+
+	for name, value := range map[string]string{
+		"GRAPHITE_FLUSH_INTERVAL": "30",
+		"GRAPHITE_PERCENTILES":    "99",
+		"GRAPHITE_TAGS":           "env",
+		"GRAPHITE_PERSISTENT":     "maybe",
+		"GRAPHITE_PROTOCOL":       "carbon",
+		"GRAPHITE_ADDR":           "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := ConfigFromEnv(); nil == err || !strings.Contains(err.Error(), name) {
+				t.Fatal("expected an error naming", name, err)
+			}
+		})
+	}
+}
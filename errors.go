@@ -0,0 +1,96 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of errors returned by flushes, matched with errors.Is, so that
+// callers can tell connection failures from problems with the data. The
+// errors of flushes wrap one of them along with their cause.
+var (
+	ErrDial    = errors.New("graphite: cannot connect")       // Connecting to the server, or opening the Sink, failed
+	ErrWrite   = errors.New("graphite: cannot send")          // Writing or POSTing a batch failed
+	ErrEncode  = errors.New("graphite: cannot export metric") // A metric could not be exported, see MetricError
+	ErrTimeout = errors.New("graphite: timed out")            // DialTimeout or WriteTimeout expired, see TimeoutError
+
+	// ErrNoMetrics is an error of the FlushError of a flush for which no
+	// registry held any metric matching Filter, see NoMetrics.
+	ErrNoMetrics = errors.New("graphite: no metrics to export")
+
+	// ErrMaxSeries is wrapped by an error of the FlushError of a flush which
+	// had more series than MaxSeries.
+	ErrMaxSeries = errors.New("graphite: too many series")
+
+	// ErrUnknownType is wrapped by the MetricError of a metric whose type
+	// cannot be exported, see StrictTypes.
+	ErrUnknownType = errors.New("unknown metric type")
+)
+
+// A sendError is an error sending a flush, of the kind ErrDial or ErrWrite.
+// Its message is the one of its cause.
+type sendError struct {
+	kind, err error
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sendError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// sendErr wraps err, if any, as an error of the given kind unless it
+// already is one.
+func sendErr(kind, err error) error {
+	if nil == err || errors.Is(err, kind) {
+		return err
+	}
+	return &sendError{kind, err}
+}
+
+// A MetricError reports a metric which could not be exported.
+type MetricError struct {
+	Name string // Name of the metric, prefixed with the prefix of its registry
+	Err  error
+}
+
+func (e *MetricError) Error() string {
+	return fmt.Sprintf("graphite: cannot export '%s': %v", e.Name, e.Err)
+}
+
+func (e *MetricError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrEncode.
+func (e *MetricError) Is(target error) bool {
+	return ErrEncode == target
+}
+
+// A FlushError is returned by a flush which sent only some of the metrics.
+// It holds a MetricError for every metric which could not be exported, and
+// an ErrMaxSeries if MaxSeries dropped some or ErrNoMetrics, followed by the
+// error sending the others, if any.
+type FlushError struct {
+	Errors []error
+}
+
+func (e *FlushError) Error() string {
+	if 1 == len(e.Errors) {
+		return e.Errors[0].Error()
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("graphite: %d errors: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the flush, so that errors.Is and errors.As
+// match any of them.
+func (e *FlushError) Unwrap() []error {
+	return e.Errors
+}
@@ -0,0 +1,54 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// PostEvent posts an event to the events API of graphite-web, such as a
+// deploy or an incident, to annotate graphs with. what is its title, tags
+// select it in the events() function of graphite-web, such as "deploy",
+// and data describes it.
+func (c *RenderClient) PostEvent(ctx context.Context, what string, tags []string, data string) error {
+	b, err := json.Marshal(struct {
+		What string   `json:"what"`
+		Tags []string `json:"tags,omitempty"`
+		Data string   `json:"data,omitempty"`
+	}{what, tags, data})
+	if nil != err {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", "/events/", bytes.NewReader(b))
+	if nil != err {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// annotationTimeout limits posting the events of Annotations, so that an
+// unresponsive graphite-web does not hold up Run or Close.
+const annotationTimeout = 10 * time.Second
+
+// annotate posts the event of Annotations for the exporter having started
+// or stopped, tagged "deploy" and with the AnnotationVersion, logging any
+// error.
+func (e *Exporter) annotate(action string) {
+	c := &e.config
+	what := action
+	if "" != c.Prefix {
+		what = c.Prefix + " " + action
+	}
+	tags := []string{"deploy"}
+	data := ""
+	if "" != c.AnnotationVersion {
+		tags = append(tags, c.AnnotationVersion)
+		data = "version " + c.AnnotationVersion
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), annotationTimeout)
+	defer cancel()
+	if err := c.Annotations.PostEvent(ctx, what, tags, data); nil != err {
+		e.logError(err)
+	}
+}
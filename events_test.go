@@ -0,0 +1,68 @@
+package graphite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestPostEvent(t *testing.T) {
+	bodies := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "POST" != r.Method || "/events/" != r.URL.Path || "secret" != r.Header.Get("Authorization") {
+			t.Errorf("bad request: %s %s", r.Method, r.URL)
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer ts.Close()
+
+	c := &RenderClient{URL: ts.URL + "/", Header: http.Header{"Authorization": {"secret"}}}
+	if err := c.PostEvent(context.Background(), "deployed v2", []string{"deploy", "web"}, "by ci"); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := `{"what":"deployed v2","tags":["deploy","web"],"data":"by ci"}`, <-bodies; expected != found {
+		t.Fatalf("expected %s, found %s", expected, found)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	bodies := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer ts.Close()
+
+	clock := &testClock{c: make(chan time.Time)}
+	e := NewExporter(GraphiteConfig{
+		Registry:          metrics.NewRegistry(),
+		Prefix:            "web",
+		Clock:             clock,
+		Sink:              WriterSink(io.Discard),
+		Annotations:       &RenderClient{URL: ts.URL},
+		AnnotationVersion: "v1.2",
+		AnnotateClose:     true,
+	})
+	done := make(chan bool)
+	go func() {
+		e.Run()
+		close(done)
+	}()
+	if expected, found := `{"what":"web started","tags":["deploy","v1.2"],"data":"version v1.2"}`, <-bodies; expected != found {
+		t.Fatalf("expected %s, found %s", expected, found)
+	}
+	close(clock.c)
+	<-done
+	if err := e.Close(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := `{"what":"web stopped","tags":["deploy","v1.2"],"data":"version v1.2"}`, <-bodies; expected != found {
+		t.Fatalf("expected %s, found %s", expected, found)
+	}
+}
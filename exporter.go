@@ -0,0 +1,878 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dt/go-metrics"
+)
+
+// Exporter reports the metrics described by a GraphiteConfig and keeps the
+// state which needs to survive between flushes, such as resolved addresses.
+type Exporter struct {
+	mu            sync.Mutex
+	config        GraphiteConfig
+	addr          *addrList
+	resolved      time.Time
+	srv           []string   // Addresses SRV resolved to
+	resolver      sync.Mutex // Guards addr, srv and resolved, which shards dial in parallel
+	flushes       uint64
+	lastTS        int64                   // Timestamp of the last flush, see Verify
+	failed        uint64                  // Consecutive failed sends, accessed atomically
+	skipped       uint64                  // Flushes skipped by Run as they overlapped the previous one, accessed atomically
+	quotaDropped  uint64                  // Datapoints dropped by ByteQuota, accessed atomically
+	seriesDropped uint64                  // Datapoints dropped by MaxSeries, accessed atomically
+	inflight      uint32                  // Whether a send is in progress with AsyncSend, accessed atomically
+	statusMu      sync.Mutex              // Guards status, which sends with AsyncSend update
+	status        Status                  // Outcome of the flushes sent so far
+	bandwidth     bandwidth               // Bytes sent within the last hour, guarded by statusMu
+	clock         Clock                   // Clock of the configuration, which UpdateConfig keeps, read by Status without mu
+	values        map[series]observation  // Values seen by previous flushes
+	updates       map[metric]update       // Last change of each metric
+	failures      map[metric]failureCount // Failures of each healthcheck
+	scratch       []datapoint             // Datapoints of the metric being snapshotted
+	snapshot      snapshot                // Datapoints of the current flush
+	encoder       encoder                 // Encodes the snapshot into the payload
+	shards        shardRouter             // Encoded datapoints of the current flush, see Connections
+	sending       sync.WaitGroup          // Sends in progress with AsyncSend
+	limiter       *rateLimiter            // Enforces MaxDatapointsPerSecond, nil if unlimited
+	namer         namer                   // Names rolled up series
+	fields        map[kind]*fieldSet      // Fields selected for each kind of metric
+	excluded      *fieldSet               // Fields excluded for every kind of metric, see ExcludeFields
+	extras        *fieldSet               // Optional fields exported for histograms and timers, see OptionalFields
+	bucketKeys    []string                // Keys of the series of Buckets
+	quantileKeys  map[float64]string      // Keys of the series of the percentiles of histograms and timers
+	sorted        []namedMetric           // Metrics of the registry being snapshotted, with SortedOutput
+	prefixes      map[string]string       // Expanded prefixes returned by PrefixFunc
+	tags          *tagSet                 // Tags of the metric being snapshotted, with TagExtractor
+	pending       []pendingMetric         // Metrics of the registry being snapshotted, with EncodeWorkers
+	breaker       breaker                 // Pauses dialing after repeated failures, see BreakerFailures
+
+	pendingMu     sync.Mutex      // Guards pendingConfig and pendingPrefix, which are set while flushing
+	pendingConfig *GraphiteConfig // Configuration applied by the next flush, see UpdateConfig
+	pendingPrefix *string         // Prefix applied by the next flush, see SetPrefix
+
+	aggregates map[series]*aggregate // Values of the current rollup interval
+	rolled     time.Time             // Start of the current rollup interval
+
+	described time.Time // Time Descriptions were last sent, see DescribeInterval
+
+	funcsMu sync.Mutex                      // Guards funcs, which are registered while flushing
+	funcs   map[string]metrics.GaugeFloat64 // Gauges of RegisterGaugeFunc
+
+	errorLog errorLog         // Throttles the errors logged, see LogInterval
+	endTrace func(FlushStats) // Ends the trace of the flush being sent, see Tracer
+
+	matched   int                // Metrics which matched Filter so far this flush, see NoMetrics
+	capped    bool               // Whether MaxSeries applies to the datapoints being snapshotted
+	overflow  int                // Datapoints dropped by MaxSeries this flush
+	endpoints []endpointExporter // Exporters of Endpoints
+	endpoint  bool               // Whether e sends an Endpoint, leaving ResetAfterFlush to the exporter of its GraphiteConfig
+	transient bool               // Whether e sends a single flush, of GraphiteOnce, releasing its buffers once sent
+	tracking  bool               // Whether flushes observe the values of series, see tracksValues
+}
+
+// NewExporter returns an Exporter for the given configuration. Nothing is
+// sent until Run or Once is called.
+func NewExporter(c GraphiteConfig) *Exporter {
+	e := &Exporter{
+		clock:    c.clock(),
+		shards:   newShardRouter(&c),
+		values:   make(map[series]observation),
+		updates:  make(map[metric]update),
+		failures: make(map[metric]failureCount),
+	}
+	e.configure(c)
+	e.endpoints = newEndpoints(c)
+	if 0 < c.MaxDatapointsPerSecond {
+		e.limiter = newRateLimiter(c.MaxDatapointsPerSecond, c.FlushInterval)
+	}
+	return e
+}
+
+// configure makes c the configuration of e, expanding its prefixes and
+// deriving the state which depends on it.
+func (e *Exporter) configure(c GraphiteConfig) {
+	c.Prefix = c.expandPrefix(c.Prefix)
+	if "" != c.ExpvarPrefix {
+		c.ExpvarPrefix = c.expandPrefix(c.ExpvarPrefix)
+	}
+	if "" != c.TaggedPrefix {
+		c.TaggedPrefix = c.expandPrefix(c.TaggedPrefix)
+	}
+	c.Registries = c.flattenBindings(nil, "", c.Registries)
+	c.Percentiles = cleanPercentiles(c.Percentiles)
+	c.HistogramPercentiles = cleanPercentiles(c.HistogramPercentiles)
+	c.TimerPercentiles = cleanPercentiles(c.TimerPercentiles)
+	c.Buckets = cleanBuckets(c.Buckets)
+	if PathReversedDomain == c.PathOrder {
+		c.Namer = ReversedDomainNamer(fqdn(), c.Namer)
+	}
+	percentiles := append(append([]float64(nil), c.histogramPercentiles()...), c.timerPercentiles()...)
+	e.config = c
+	e.fields = map[kind]*fieldSet{
+		kindHistogram: newFieldSet(c.HistogramFields, c.histogramPercentiles()),
+		kindMeter:     newFieldSet(c.MeterFields, nil),
+		kindTimer:     newFieldSet(c.TimerFields, c.timerPercentiles()),
+	}
+	e.excluded = newFieldSet(c.ExcludeFields, percentiles)
+	e.extras = newFieldSet(c.OptionalFields, nil)
+	e.tracking = c.tracksValues()
+	e.bucketKeys = bucketKeys(c.Buckets)
+	e.quantileKeys = make(map[float64]string, len(percentiles))
+	for _, p := range percentiles {
+		e.quantileKeys[p] = percentileKey(p, c.PercentileFormat)
+	}
+	e.encoder, e.prefixes = nil, nil
+	if nil != e.shards.ring {
+		e.shards.namer = newNamer(&e.config)
+	}
+	if 0 < c.RollupInterval {
+		e.namer = newNamer(&e.config)
+		if nil == e.aggregates {
+			e.aggregates = make(map[series]*aggregate)
+		}
+	}
+}
+
+// UpdateConfig replaces the configuration of a running exporter with c,
+// such as to change its FlushInterval, Prefix, Percentiles or fields
+// without restarting it. It takes effect atomically at the start of the
+// next flush, which Run ticks at the new FlushInterval after. Connections
+// kept with PersistentConnection are closed then, so that a new Address
+// applies too.
+//
+// Connections, Destinations, Endpoints, Clock, Scheduler and
+// MaxDatapointsPerSecond keep the values the exporter was created with.
+func (e *Exporter) UpdateConfig(c GraphiteConfig) {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+	e.pendingConfig, e.pendingPrefix = &c, nil
+}
+
+// SetPrefix replaces the Prefix of a running exporter, such as when the
+// tenant it includes changes, atomically at the start of the next flush.
+// It may contain placeholders.
+func (e *Exporter) SetPrefix(prefix string) {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+	if nil != e.pendingConfig {
+		e.pendingConfig.Prefix = prefix
+		return
+	}
+	e.pendingPrefix = &prefix
+}
+
+// applyUpdate applies the configuration passed to UpdateConfig or the
+// prefix passed to SetPrefix since the previous flush, if any.
+func (e *Exporter) applyUpdate() {
+	e.pendingMu.Lock()
+	c, prefix := e.pendingConfig, e.pendingPrefix
+	e.pendingConfig, e.pendingPrefix = nil, nil
+	e.pendingMu.Unlock()
+	if nil != prefix {
+		e.config.Prefix = e.config.expandPrefix(*prefix)
+	}
+	if nil == c {
+		return
+	}
+	c.Connections, c.Destinations = e.config.Connections, e.config.Destinations
+	c.Clock, c.Scheduler, c.MaxDatapointsPerSecond = e.config.Clock, e.config.Scheduler, e.config.MaxDatapointsPerSecond
+	e.closeConns()
+	e.resolver.Lock()
+	e.addr, e.srv = nil, nil
+	e.resolver.Unlock()
+	e.configure(*c)
+}
+
+// Run is a blocking loop which flushes metrics every FlushInterval, logging
+// any errors encountered through Logger, throttled by LogInterval. It
+// returns once MaxConsecutiveFailures sends have failed in a row, if set, or
+// right away with PingOnStart if the Ping failed. With Annotations, it first
+// posts a "started" event, marking deploys on graphs.
+//
+// Ticks which were due while the previous flush was still being taken or
+// sent are skipped rather than flushed right after it, and counted by
+// Skipped. With a Scheduler, it flushes at the times of the Scheduler
+// instead.
+func (e *Exporter) Run() {
+	e.mu.Lock()
+	ping := e.config.PingOnStart
+	e.mu.Unlock()
+	if ping {
+		if err := e.Ping(context.Background()); nil != err {
+			e.logError(err)
+			return
+		}
+	}
+	e.mu.Lock()
+	clock, interval, scheduler := e.config.clock(), e.config.FlushInterval, e.config.Scheduler
+	if nil != e.config.Annotations {
+		e.annotate("started")
+	}
+	e.mu.Unlock()
+	if nil != scheduler {
+		e.runScheduled(clock, scheduler)
+		return
+	}
+	t := clock.NewTicker(interval)
+	defer func() { t.Stop() }()
+	var last time.Time // End of the previous flush
+	for {
+		tick, ok := <-t.C()
+		if !ok {
+			return
+		}
+		if tick.Before(last) || 0 != atomic.LoadUint32(&e.inflight) {
+			atomic.AddUint64(&e.skipped, 1)
+			continue
+		}
+		if err := e.Once(); nil != err {
+			e.logError(err)
+		}
+		last = clock.Now()
+		e.mu.Lock()
+		d, max := e.config.FlushInterval, e.config.MaxConsecutiveFailures
+		e.mu.Unlock()
+		if 0 < max && uint64(max) <= atomic.LoadUint64(&e.failed) {
+			return
+		}
+		if d != interval {
+			t.Stop()
+			t, interval = clock.NewTicker(d), d
+		}
+	}
+}
+
+// Once performs a single submission to Graphite, returning a non-nil error
+// on failed connections, or a *FlushError if some metrics could not be
+// exported while the others were sent. With AsyncSend it returns once the
+// registries have been snapshotted, and errors sending them are logged
+// instead.
+func (e *Exporter) Once() error {
+	return e.OnceContext(context.Background())
+}
+
+// OnceContext is like Once, but gives up connecting to and writing to the
+// server when ctx is done, returning its error. With AsyncSend, ctx applies
+// to the send which continues after OnceContext returned.
+func (e *Exporter) OnceContext(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.flush(ctx, e.config.AsyncSend)
+}
+
+// Flush performs a submission right away, outside the FlushInterval of Run,
+// such as before a controlled shutdown or once a batch job has completed.
+// Unlike Once it waits for the metrics to be sent even with AsyncSend,
+// returning the error sending them.
+func (e *Exporter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.flush(context.Background(), false)
+}
+
+// Close waits for any flush in progress and closes the connections kept
+// with PersistentConnection. A later flush connects again. With
+// AnnotateClose, it posts a "stopped" event to Annotations.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sending.Wait()
+	if nil != e.config.Annotations && e.config.AnnotateClose {
+		e.annotate("stopped")
+	}
+	err := e.closeConns()
+	if cerr := e.closeEndpoints(); nil == err {
+		err = cerr
+	}
+	return err
+}
+
+// closeConns closes the connections kept with PersistentConnection.
+func (e *Exporter) closeConns() error {
+	var err error
+	for i := range e.shards.shards {
+		s := &e.shards.shards[i]
+		if nil == s.conn {
+			continue
+		}
+		if cerr := s.conn.Close(); nil == err {
+			err = cerr
+		}
+		s.conn = nil
+	}
+	return err
+}
+
+// flush takes a snapshot of every registry, then encodes it into the
+// payload buffer before anything is sent, so that registries are iterated
+// without waiting for the network and a failure to connect or write affects
+// the flush as a whole rather than the series encoded after it. If async is
+// set, the snapshot is encoded and sent on a separate goroutine, which the
+// next flush waits for.
+func (e *Exporter) flush(ctx context.Context, async bool) error {
+	e.sending.Wait()
+	e.applyUpdate()
+	ctx = e.startTrace(ctx)
+	c := &e.config
+	if nil != c.BeforeFlush {
+		c.BeforeFlush()
+	}
+	e.flushEndpoints(ctx, async)
+	now := c.clock().Now()
+	e.flushes++
+	if nil != e.limiter {
+		e.limiter.refill(now)
+	}
+	e.snapshot.reset()
+	e.pending, e.matched = e.pending[:0], 0
+	e.capped, e.overflow = 0 < c.MaxSeries, 0
+	for _, b := range c.bindings() {
+		e.snapshotRegistry(b, now)
+	}
+	matched := e.matched // Gauge funcs and the metrics of the exporter itself do not count
+	if c.deferSnapshots() {
+		e.snapshotPending(now)
+	}
+	e.snapshotGaugeFuncs(c.Prefix, now)
+	if c.Expvar {
+		e.snapshotExpvar(c.expvarPrefix(), now)
+	}
+	if c.RuntimeMetrics {
+		e.snapshotRuntime(c.Prefix, now)
+	}
+	if c.ProcessMetrics {
+		e.snapshotProcess(c.Prefix, now)
+	}
+	if 0 < c.RollupInterval {
+		e.appendRollups(now)
+	}
+	if nil != c.Priority && (e.capped || nil != e.limiter) {
+		e.throttle()
+	}
+	e.capped = false
+	if 0 < e.overflow {
+		e.reportOverflow()
+	}
+	e.forget()
+	ts := now.Unix()
+	if nil != c.Timestamp {
+		ts = c.Timestamp()
+	}
+	e.lastTS = ts
+	if c.SelfMetrics {
+		e.snapshotSelf(c.Prefix, now)
+	}
+	if c.Heartbeat {
+		e.snapshotHeartbeat(c.Prefix)
+	}
+	if c.StartEpoch {
+		e.snapshotStartEpoch(c.Prefix)
+	}
+	if 0 != len(c.Metadata) {
+		e.snapshotMetadata(c.Prefix)
+	}
+	if 0 != len(c.Descriptions) {
+		e.snapshotDescriptions(c.Prefix, now)
+	}
+	if c.NoMetrics && 0 == matched {
+		e.snapshotNoMetrics(c.Prefix)
+	}
+	if c.StrictTypes {
+		if err := e.unknownTypes(); nil != err {
+			e.countFailure(err)
+			e.recordStatus(FlushStats{Time: now, Datapoints: len(e.snapshot.dps), Err: err}, 0, 0)
+			return err
+		}
+	}
+	if async {
+		e.sending.Add(1)
+		atomic.StoreUint32(&e.inflight, 1)
+		go func() {
+			defer e.sending.Done()
+			defer atomic.StoreUint32(&e.inflight, 0)
+			if err := e.encodeAndSend(ctx, now, ts); nil != err {
+				e.logError(err)
+			}
+		}()
+		return nil
+	}
+	return e.encodeAndSend(ctx, now, ts)
+}
+
+// encodeAndSend encodes the snapshot with the timestamp ts, in seconds since
+// the epoch, and sends it, resetting the metrics whose datapoints were
+// encoded once it was sent successfully. With FlushBytes or
+// FlushDatapoints, the part encoded so far is sent whenever it reaches them,
+// and the rest is not encoded once a part failed. With ByteQuota, the
+// metrics are encoded in the order of their Priority, and those from the
+// first one exceeding it on are dropped. The flush which started at start is
+// recorded in Status.
+func (e *Exporter) encodeAndSend(ctx context.Context, start time.Time, ts int64) error {
+	c := &e.config
+	e.shards.reset(c.batchSize())
+	if nil == e.encoder {
+		e.encoder = newEncoder(&e.shards, c)
+	}
+	each := e.snapshot.each
+	if 0 < c.ByteQuota && nil != c.Priority {
+		each = e.snapshot.byPriority(c.Priority)
+	}
+	var err error
+	var mark []int
+	sent, encoded, bytes, sentBytes, parts, dropped := 0, 0, 0, 0, 0, 0
+	each(func(dps []datapoint) {
+		if nil != err {
+			return
+		}
+		if 0 < c.ByteQuota {
+			if 0 != dropped {
+				dropped += len(dps)
+				return
+			}
+			mark = e.shards.mark(mark[:0])
+		}
+		at := ts
+		if 0 != dps[0].time {
+			at = dps[0].time
+		}
+		if nil != e.shards.ring {
+			for i := range dps {
+				e.shards.routeSeries(&dps[i])
+				e.encoder.encode(dps[i:i+1], at)
+			}
+		} else {
+			e.shards.route(&dps[0])
+			e.encoder.encode(dps, at)
+		}
+		if 0 < c.ByteQuota && bytes+e.shards.size() > c.ByteQuota && !c.critical(dps[0].name) {
+			e.shards.rollback(mark)
+			dropped += len(dps)
+			return
+		}
+		if ferr := e.encoder.formatErr(dps); nil != ferr {
+			e.snapshot.errs = append(e.snapshot.errs, ferr)
+		}
+		e.snapshot.encode(dps)
+		encoded += len(dps)
+		if c.sendEarly(e.shards.size(), encoded) {
+			bytes += e.shards.size()
+			if err = e.sendShards(ctx); nil == err {
+				sent, sentBytes = sent+encoded, bytes
+			}
+			e.shards.reset(c.batchSize())
+			encoded = 0
+			parts++
+		}
+	})
+	e.encoder.sweep()
+	if nil != e.shards.ring {
+		e.shards.namer.sweep()
+	}
+	if 0 != dropped {
+		atomic.AddUint64(&e.quotaDropped, uint64(dropped))
+	}
+	if nil == err && (0 < encoded || 0 == parts) {
+		bytes += e.shards.size()
+		if err = e.sendShards(ctx); nil == err {
+			sent, sentBytes = sent+encoded, bytes
+		}
+	}
+	e.countFailure(err)
+	if errors.Is(err, ErrCircuitOpen) {
+		atomic.AddUint64(&e.breaker.dropped, uint64(len(e.snapshot.dps)-sent-dropped))
+	}
+	if nil == err {
+		for _, r := range e.snapshot.resets {
+			if r.encoded {
+				r.apply()
+			}
+		}
+	}
+	if 0 != len(e.snapshot.errs) {
+		errs := append([]error(nil), e.snapshot.errs...)
+		if nil != err {
+			errs = append(errs, err)
+		}
+		err = &FlushError{Errors: errs}
+	}
+	e.recordStatus(FlushStats{Time: start, Datapoints: len(e.snapshot.dps), Bytes: bytes, Err: err}, sent, sentBytes)
+	if e.transient {
+		e.release()
+	}
+	return err
+}
+
+// sendEarly reports whether the part of a flush encoded so far, of the given
+// size in bytes and number of datapoints, is to be sent before encoding the
+// rest, see FlushBytes and FlushDatapoints.
+func (c *GraphiteConfig) sendEarly(size, datapoints int) bool {
+	return 0 < c.FlushBytes && c.FlushBytes <= size || 0 < c.FlushDatapoints && c.FlushDatapoints <= datapoints
+}
+
+// unknownTypes returns a *FlushError holding the MetricError of every
+// metric of the snapshot whose type is not supported, or nil if there is
+// none.
+func (e *Exporter) unknownTypes() error {
+	var errs []error
+	for _, err := range e.snapshot.errs {
+		if errors.Is(err, ErrUnknownType) {
+			errs = append(errs, err)
+		}
+	}
+	if 0 == len(errs) {
+		return nil
+	}
+	return &FlushError{Errors: errs}
+}
+
+// countFailure counts the sends which failed in a row, calling OnFailure
+// with err once there are MaxConsecutiveFailures of them.
+func (e *Exporter) countFailure(err error) {
+	if nil == err {
+		atomic.StoreUint64(&e.failed, 0)
+		return
+	}
+	n := atomic.AddUint64(&e.failed, 1)
+	if max := e.config.MaxConsecutiveFailures; 0 < max && uint64(max) == n && nil != e.config.OnFailure {
+		e.config.OnFailure(err)
+	}
+}
+
+// batchSize returns the maximum size of the batches a payload is sent in,
+// zero if it is sent whole.
+func (c *GraphiteConfig) batchSize() int {
+	switch {
+	case c.overHTTP():
+		return c.HTTPBatchSize
+	case c.datagrams():
+		return statsdPacketSize
+	}
+	return c.WriteBufferSize
+}
+
+// A payload buffers the encoded datapoints of a flush, grouped into batches
+// of at most size bytes, or a single batch if size is zero. Writes are never
+// split, so each must be a whole unit of the protocol, such as a line.
+type payload struct {
+	size int
+	buf  []byte
+	ends []int // End offsets of all batches but the last
+}
+
+// reset empties p, keeping its memory for the next flush.
+func (p *payload) reset(size int) {
+	if nil == p.buf {
+		p.buf = pooledBytes()
+	}
+	p.size, p.buf, p.ends = size, p.buf[:0], p.ends[:0]
+}
+
+func (p *payload) Write(b []byte) (int, error) {
+	start := 0
+	if 0 < len(p.ends) {
+		start = p.ends[len(p.ends)-1]
+	}
+	if 0 < p.size && start < len(p.buf) && len(p.buf)-start+len(b) > p.size {
+		p.ends = append(p.ends, len(p.buf))
+	}
+	p.buf = append(p.buf, b...)
+	return len(b), nil
+}
+
+// truncate drops the bytes of p from offset n on.
+func (p *payload) truncate(n int) {
+	p.buf = p.buf[:n]
+	for 0 < len(p.ends) && p.ends[len(p.ends)-1] >= n {
+		p.ends = p.ends[:len(p.ends)-1]
+	}
+}
+
+// each calls fn with every batch of p.
+func (p *payload) each(fn func([]byte)) {
+	start := 0
+	for _, end := range p.ends {
+		fn(p.buf[start:end])
+		start = end
+	}
+	if start < len(p.buf) {
+		fn(p.buf[start:])
+	}
+}
+
+// snapshotRegistry appends the datapoints of every metric in the registry
+// of b which are to be exported to the snapshot, or queues the metrics for
+// snapshotPending.
+func (e *Exporter) snapshotRegistry(b RegistryBinding, now time.Time) {
+	c := &e.config
+	each := b.Registry.Each
+	if c.SortedOutput {
+		each = e.sortedEach(b.Registry)
+	}
+	each(func(name string, i interface{}) {
+		legacy := name
+		if nil != c.TagExtractor {
+			var tags map[string]string
+			name, tags = c.TagExtractor(name, i)
+			if TagModeFolded == c.TagMode {
+				name = foldTags(name, tags)
+			} else {
+				e.tags = newTagSet(tags)
+			}
+		}
+		prefix := b.Prefix
+		if nil != b.prefixFunc {
+			prefix = e.metricPrefix(b.prefixFunc(name))
+		}
+		if TagModeBoth == c.TagMode && nil != c.TagExtractor {
+			tags := e.tags
+			e.tags = nil
+			e.snapshotOrQueue(prefix, legacy, i, now)
+			tagged := prefix
+			if "" != b.taggedPrefix {
+				tagged = b.taggedPrefix
+			}
+			if nil == tags && legacy == name && tagged == prefix {
+				return // Both forms are the same series
+			}
+			prefix, e.tags = tagged, tags
+		}
+		e.snapshotOrQueue(prefix, name, i, now)
+		e.tags = nil
+	})
+}
+
+// snapshotOrQueue snapshots the named metric i, or queues it for
+// snapshotPending.
+func (e *Exporter) snapshotOrQueue(prefix, name string, i interface{}, now time.Time) {
+	if e.config.deferSnapshots() {
+		e.queue(prefix, name, i)
+	} else {
+		e.snapshotMetric(prefix, name, i, now)
+	}
+}
+
+// metricPrefix returns prefix, returned by PrefixFunc, with its placeholders
+// expanded, remembering it for the following flushes.
+func (e *Exporter) metricPrefix(prefix string) string {
+	p, ok := e.prefixes[prefix]
+	if !ok {
+		if nil == e.prefixes {
+			e.prefixes = make(map[string]string)
+		}
+		p = e.config.expandPrefix(prefix)
+		e.prefixes[prefix] = p
+	}
+	return p
+}
+
+// snapshotMetric appends the datapoints of metric i to be exported to the
+// snapshot.
+func (e *Exporter) snapshotMetric(prefix, name string, i interface{}, now time.Time) {
+	prefix, name, ok := e.exportedName(prefix, name)
+	if !ok {
+		return
+	}
+	e.scratch = e.appendDatapoints(e.scratch[:0], prefix, name, i)
+	e.snapshotDatapoints(prefix, name, i, e.scratch, now)
+}
+
+// exportedName returns the prefix and name the named metric is exported
+// under this flush, or false if it is to be skipped.
+func (e *Exporter) exportedName(prefix, name string) (string, string, bool) {
+	c := &e.config
+	if nil != c.Filter && !c.Filter(name) {
+		return prefix, name, false
+	}
+	e.matched++
+	if c.skipped(name, e.flushes) {
+		return prefix, name, false
+	}
+	if ValidateNone != c.ValidateNames {
+		var ok bool
+		if prefix, name, ok = e.validateName(prefix, name); !ok {
+			return prefix, name, false
+		}
+	}
+	if 0 < c.MaxNameLength && fullNameLen(prefix, name) > c.MaxNameLength {
+		var ok bool
+		if name, ok = e.limitName(prefix, name); !ok {
+			return prefix, name, false
+		}
+	}
+	return prefix, escapeName(name), true
+}
+
+// snapshotDatapoints appends dps, the datapoints of metric i, to the
+// snapshot, leaving out those which are not to be exported.
+func (e *Exporter) snapshotDatapoints(prefix, name string, i interface{}, dps []datapoint, now time.Time) {
+	c := &e.config
+	ts := metricTime(i)
+	for j := range dps {
+		dps[j].tags, dps[j].time = e.tags, ts
+	}
+	if "" != c.InvalidValues || 0 != len(c.InvalidValuesByType) {
+		dps = e.replaceInvalid(dps)
+	}
+	if e.tracking && e.observe(prefix, name, dps, now) {
+		return
+	}
+	if c.CountersAsRate {
+		dps = appendCounterRates(dps, c.ResetAfterFlush)
+	}
+	if c.ResetAfterFlush && !e.endpoint {
+		e.snapshot.queueReset(i, dps)
+	}
+	if 0 < c.RollupInterval {
+		e.rollup(dps)
+		if c.RollupOnly {
+			e.snapshot.encode(dps) // Their values are sent with the rollup
+			return
+		}
+	}
+	for _, dp := range dps {
+		if c.SkipInvalidValues && !dp.valid() {
+			continue
+		}
+		if c.SkipUnchanged && dp.unchanged {
+			continue
+		}
+		if nil == c.Priority {
+			if e.capped && len(e.snapshot.dps) >= c.MaxSeries {
+				e.overflow++
+				continue
+			}
+			if nil != e.limiter && !e.limiter.allow() {
+				continue
+			}
+		}
+		e.snapshot.dps = append(e.snapshot.dps, dp)
+	}
+	e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+}
+
+// A namedMetric is a metric along with its name in its registry.
+type namedMetric struct {
+	name   string
+	metric interface{}
+}
+
+// sortedEach returns a function like r.Each which calls fn for the metrics
+// of r in the order of their names.
+func (e *Exporter) sortedEach(r metrics.Registry) func(func(string, interface{})) {
+	return func(fn func(string, interface{})) {
+		e.sorted = e.sorted[:0]
+		r.Each(func(name string, i interface{}) {
+			e.sorted = append(e.sorted, namedMetric{name, i})
+		})
+		sort.Slice(e.sorted, func(i, j int) bool { return e.sorted[i].name < e.sorted[j].name })
+		for _, m := range e.sorted {
+			fn(m.name, m.metric)
+		}
+	}
+}
+
+// A snapshot holds the datapoints of a flush, grouped by metric.
+type snapshot struct {
+	dps    []datapoint
+	ends   []int         // End offsets of the datapoints of each metric
+	resets []metricReset // Metrics reset once the flush was sent, with ResetAfterFlush
+	errs   []error       // Errors of the metrics which could not be exported
+}
+
+// A metricReset resets a metric once the flush which snapshotted it was
+// sent: a counter is decremented by the count it was snapshotted with, so
+// that the increments since the snapshot count towards the next flush, and
+// the sample of a histogram is cleared.
+type metricReset struct {
+	metric  interface{}
+	counter interface{ Dec(int64) }
+	count   int64
+	sample  interface{ Clear() }
+	encoded bool // Whether datapoints of the metric were encoded, rather than all dropped
+}
+
+func (r metricReset) apply() {
+	if nil != r.counter {
+		r.counter.Dec(r.count)
+	} else {
+		r.sample.Clear()
+	}
+}
+
+// queueReset queues the reset of metric i, snapshotted as dps, if it is a
+// counter or a histogram, and marks dps with it. A metric snapshotted again
+// right away, as both series of TagModeBoth, is reset once. It is only reset
+// if any of its datapoints are encoded, see encode.
+func (s *snapshot) queueReset(i interface{}, dps []datapoint) {
+	n := len(s.resets)
+	if 0 == n || !sameMetric(s.resets[n-1].metric, i) {
+		r, ok := newReset(i, dps)
+		if !ok {
+			return
+		}
+		s.resets = append(s.resets, r)
+		n++
+	}
+	for j := range dps {
+		dps[j].reset = n
+	}
+}
+
+// newReset returns the reset of metric i, snapshotted as dps, or false if
+// it is neither a counter nor a histogram.
+func newReset(i interface{}, dps []datapoint) (metricReset, bool) {
+	switch m := i.(type) {
+	case metrics.Counter, foreignCounter:
+		for j := range dps {
+			if fieldCounter == dps[j].field {
+				return metricReset{metric: i, counter: m.(interface{ Dec(int64) }), count: dps[j].ivalue}, true
+			}
+		}
+	case metrics.Histogram, foreignHistogram:
+		return metricReset{metric: i, sample: m.(interface{ Clear() })}, true
+	}
+	return metricReset{}, false
+}
+
+// encode records that dps were encoded, so that their metrics are reset
+// once the flush was sent.
+func (s *snapshot) encode(dps []datapoint) {
+	for j := range dps {
+		if 0 != dps[j].reset {
+			s.resets[dps[j].reset-1].encoded = true
+		}
+	}
+}
+
+// sameMetric reports whether a and b are the same metric. Metrics of types
+// which cannot be compared never are.
+func sameMetric(a, b interface{}) bool {
+	t := reflect.TypeOf(a)
+	return nil != t && t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// reset empties s, keeping its memory for the next flush.
+func (s *snapshot) reset() {
+	if nil == s.dps {
+		s.dps = pooledDatapoints()
+	}
+	s.dps, s.ends, s.resets, s.errs = s.dps[:0], s.ends[:0], s.resets[:0], s.errs[:0]
+}
+
+// each calls fn with the datapoints of every metric with any.
+func (s *snapshot) each(fn func([]datapoint)) {
+	start := 0
+	for _, end := range s.ends {
+		if start < end {
+			fn(s.dps[start:end])
+		}
+		start = end
+	}
+}
@@ -0,0 +1,1282 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestResolveTTL(t *testing.T) {
+	_, l, _, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.Address = c.Addr.String()
+	c.Addr = nil
+
+	e := NewExporter(c)
+	wg.Add(2)
+	e.Once()
+	first := e.addr
+	e.Once()
+	wg.Wait()
+	if first == e.addr {
+		t.Fatal("address not re-resolved with zero ResolveTTL")
+	}
+
+	c.ResolveTTL = time.Hour
+	e = NewExporter(c)
+	wg.Add(2)
+	e.Once()
+	first = e.addr
+	e.Once()
+	wg.Wait()
+	if first != e.addr {
+		t.Fatal("address re-resolved within ResolveTTL")
+	}
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.SkipUnchanged = true
+	e := NewExporter(c)
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterGauge("bar", r).Update(1)
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	for k := range res {
+		delete(res, k)
+	}
+	metrics.GetOrRegisterGauge("bar", r).Update(3)
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if _, found := res["foobar.foo.count"]; found {
+		t.Fatal("unchanged value exported")
+	}
+
+	if expected, found := 3.0, res["foobar.bar.value"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestMetricTTL(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.MetricTTL = 100 * time.Millisecond
+	e := NewExporter(c)
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	for k := range res {
+		delete(res, k)
+	}
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if _, found := res["foobar.foo.count"]; found {
+		t.Fatal("expired metric exported")
+	}
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if expected, found := 3.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestMetricTTLRates(t *testing.T) {
+	e := NewExporter(GraphiteConfig{Sink: WriterSink(io.Discard), MetricTTL: 7 * time.Second})
+	start := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		// The rates of an idle timer change with time, its count does not.
+		timer := []datapoint{
+			{prefix: "app", name: "latency", field: fieldTimerCount, kind: kindTimer, ivalue: 1},
+			{prefix: "app", name: "latency", field: fieldRateMean, kind: kindTimer, fvalue: 1 / float64(1+i)},
+		}
+		ewma := []datapoint{{prefix: "app", name: "load", field: fieldEWMA, kind: kindEWMA, fvalue: float64(i)}}
+		now := start.Add(time.Duration(i) * 5 * time.Second)
+		if expired := e.observe("app", "latency", timer, now); (2 == i) != expired {
+			t.Fatalf("idle timer expired %v after %d flushes", expired, 1+i)
+		}
+		if e.observe("app", "load", ewma, now) {
+			t.Fatal("changing EWMA expired")
+		}
+	}
+}
+
+func TestTracksValues(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	c := GraphiteConfig{Registry: r, Sink: WriterSink(io.Discard), DurationUnit: time.Nanosecond}
+	e := NewExporter(c)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if 0 != len(e.values) || 0 != len(e.updates) {
+		t.Fatal("values tracked without any setting reading them:", e.values, e.updates)
+	}
+
+	c.SkipUnchanged = true
+	e = NewExporter(c)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(e.values) {
+		t.Fatal("values not tracked with SkipUnchanged:", e.values)
+	}
+}
+
+func TestPayloadBatches(t *testing.T) {
+	var p payload
+	p.reset(10)
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cc\n", "dddddddddddd\n", "e\n"} {
+		p.Write([]byte(line))
+	}
+	var batches []string
+	p.each(func(b []byte) { batches = append(batches, string(b)) })
+	expected := []string{"aaaa\nbbbb\n", "cc\n", "dddddddddddd\n", "e\n"}
+	if len(batches) != len(expected) {
+		t.Fatalf("bad batches: %q", batches)
+	}
+	for i := range expected {
+		if batches[i] != expected[i] {
+			t.Fatalf("bad batches: %q", batches)
+		}
+	}
+
+	p.reset(0)
+	p.Write([]byte("aaaa\n"))
+	p.Write([]byte("bbbb\n"))
+	batches = batches[:0]
+	p.each(func(b []byte) { batches = append(batches, string(b)) })
+	if 1 != len(batches) || "aaaa\nbbbb\n" != batches[0] {
+		t.Fatalf("bad batches: %q", batches)
+	}
+}
+
+func TestWriteBufferSize(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.WriteBufferSize = 64
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterTimer("bar", r).Update(time.Second)
+
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if expected, found := 1.0, res["foobar.bar.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestVectoredWrites(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.VectoredWrites = true
+	c.WriteBufferSize = 64
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterTimer("bar", r).Update(time.Second)
+
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if expected, found := 1.0, res["foobar.bar.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+// A partsSink records the payload of each part of a flush.
+type partsSink struct {
+	parts *[]*strings.Builder
+}
+
+func (s partsSink) Open() (io.WriteCloser, error) {
+	b := &strings.Builder{}
+	*s.parts = append(*s.parts, b)
+	return nopCloser{b}, nil
+}
+
+func TestFlushDatapoints(t *testing.T) {
+	r := metrics.NewRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+	var parts []*strings.Builder
+	var stats FlushStats
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:        r,
+		Prefix:          "app",
+		Sink:            partsSink{&parts},
+		Timestamp:       func() int64 { return 1 },
+		SortedOutput:    true,
+		FlushDatapoints: 2,
+		AfterFlush:      func(s FlushStats) { stats = s },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(parts) {
+		t.Fatal("expected 2 parts, found", len(parts))
+	}
+	for i, expected := range []string{"app.a.count 1 1\napp.b.count 1 1\n", "app.c.count 1 1\n"} {
+		if found := parts[i].String(); expected != found {
+			t.Fatalf("expected %q, found %q", expected, found)
+		}
+	}
+	if 3 != stats.Datapoints || 48 != stats.Bytes {
+		t.Fatal("bad stats:", stats)
+	}
+}
+
+func TestByteQuota(t *testing.T) {
+	r := metrics.NewRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		SortedOutput: true,
+		ByteQuota:    40,
+		Priority: func(name string) int {
+			if "c" == name {
+				return 1
+			}
+			return 0
+		},
+	})
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.c.count 1 1\napp.a.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+	if 1 != e.QuotaDropped() {
+		t.Fatal("expected one dropped datapoint:", e.QuotaDropped())
+	}
+	if s := e.Status(); 32 != s.BytesSent || 32 != s.BytesLastHour || 32 != s.LastFlushBytes {
+		t.Fatal("bad status:", s)
+	}
+}
+
+func TestPriorityRules(t *testing.T) {
+	r := metrics.NewRegistry()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+	priority := PriorityRules(
+		PriorityRule{Match: func(name string) bool { return "a" == name }, Priority: PriorityCritical},
+		PriorityRule{Match: func(name string) bool { return "b" == name }, Priority: PriorityBestEffort},
+	)
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		SortedOutput: true,
+		MaxSeries:    2,
+		Priority:     priority,
+	})
+	if err := e.Once(); !errors.Is(err, ErrMaxSeries) {
+		t.Fatal("expected an ErrMaxSeries:", err)
+	}
+	if expected, found := "app.a.count 1 1\napp.c.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+	if 2 != e.SeriesDropped() {
+		t.Fatal("expected two dropped series:", e.SeriesDropped())
+	}
+
+	b.Reset()
+	e = NewExporter(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		SortedOutput: true,
+		ByteQuota:    10,
+		Priority:     priority,
+	})
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.a.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected the critical metric over the quota, found %q", found)
+	}
+}
+
+func TestAsyncSend(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.AsyncSend = true
+	e := NewExporter(c)
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	wg.Add(1)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	delete(res, "foobar.foo.count")
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if expected, found := 3.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	e := NewExporter(GraphiteConfig{Registry: r, Addr: ln.Addr().(*net.TCPAddr), AsyncSend: true})
+	if err := e.Once(); nil != err {
+		t.Fatal("expected the asynchronous send to be logged:", err)
+	}
+	if err := e.Flush(); nil == err {
+		t.Fatal("expected Flush to wait for the send to fail")
+	}
+}
+
+func TestMaxDatapointsPerSecond(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.MaxDatapointsPerSecond = 2
+	c.FlushInterval = time.Second
+	e := NewExporter(c)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if 2 != len(res) {
+		t.Fatal("bad number of series:", len(res))
+	}
+	if expected, found := uint64(2), e.Dropped(); expected != found {
+		t.Fatal("bad dropped count:", expected, found)
+	}
+}
+
+func TestFlushRules(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.FlushRules = []FlushRule{{Match: func(name string) bool { return "disk" == name }, Every: 3}}
+	e := NewExporter(c)
+	metrics.GetOrRegisterGauge("disk", r).Update(1)
+	metrics.GetOrRegisterGauge("latency", r).Update(1)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		e.Once()
+		wg.Wait()
+	}
+
+	if expected, found := 2.0, res["foobar.disk.value"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if expected, found := 4.0, res["foobar.latency.value"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestRollups(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.RollupInterval = time.Nanosecond
+	c.RollupOnly = true
+	e := NewExporter(c)
+	g := metrics.GetOrRegisterGauge("foo", r)
+
+	g.Update(1)
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+	if 0 != len(res) {
+		t.Fatal("series exported before the end of the interval:", res)
+	}
+
+	g.Update(3)
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	for name, expected := range map[string]float64{
+		"foobar.foo.value.sum": 4,
+		"foobar.foo.value.min": 1,
+		"foobar.foo.value.max": 3,
+	} {
+		if found := res[name]; !floatEquals(found, expected) {
+			t.Fatal("bad value:", name, expected, found)
+		}
+	}
+	if _, found := res["foobar.foo.value"]; found {
+		t.Fatal("raw series exported with RollupOnly")
+	}
+}
+
+func TestResetAfterFlush(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.ResetAfterFlush = true
+	e := NewExporter(c)
+	counter := metrics.GetOrRegisterCounter("foo", r)
+	histogram := metrics.GetOrRegisterHistogram("bar", r, metrics.NewUniformSample(10))
+	counter.Inc(2)
+	histogram.Update(5)
+
+	wg.Add(1)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if 0 != counter.Count() || 0 != histogram.Count() {
+		t.Fatal("metrics not reset:", counter.Count(), histogram.Count())
+	}
+
+	l.Close()
+	counter.Inc(3)
+	if err := e.Once(); nil == err {
+		t.Fatal("expected an error from a closed server")
+	}
+	if expected, found := int64(3), counter.Count(); expected != found {
+		t.Fatal("counter reset after a failed flush:", expected, found)
+	}
+
+	// Increments while the flush is being sent count towards the next one.
+	e = NewExporter(GraphiteConfig{
+		Registry:        r,
+		Sink:            WriterSink(incWriter{counter}),
+		DurationUnit:    time.Nanosecond,
+		ResetAfterFlush: true,
+	})
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := int64(1), counter.Count(); expected != found {
+		t.Fatal("increments during the send lost:", expected, found)
+	}
+}
+
+// An incWriter increments a counter with every write.
+type incWriter struct {
+	counter metrics.Counter
+}
+
+func (w incWriter) Write(b []byte) (int, error) {
+	w.counter.Inc(1)
+	return len(b), nil
+}
+
+func TestCountersAsRate(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	clock := &testClock{now: time.Unix(1000, 0)}
+	c.CountersAsRate = true
+	c.Clock = clock
+	e := NewExporter(c)
+	counter := metrics.GetOrRegisterCounter("foo", r)
+	counter.Inc(5)
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if _, found := res["foobar.foo.rate"]; found {
+		t.Fatal("rate exported without a previous flush")
+	}
+
+	clock.now = clock.now.Add(10 * time.Second)
+	counter.Inc(20)
+
+	wg.Add(1)
+	e.Once()
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.rate"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestSortedOutput(t *testing.T) {
+	r := metrics.NewRegistry()
+	for _, name := range []string{"c", "a", "d", "b"} {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+
+	e := NewExporter(GraphiteConfig{Registry: r, Prefix: "app", SortedOutput: true})
+	e.snapshotRegistry(RegistryBinding{Registry: r, Prefix: "app"}, time.Now())
+	var names []string
+	e.snapshot.each(func(dps []datapoint) { names = append(names, dps[0].name) })
+	if expected, found := "a b c d", strings.Join(names, " "); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Sink:      WriterSink(&b),
+		Timestamp: func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.foo.count 2 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestTransportStdout(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	pr, pw, err := os.Pipe()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = pw
+
+	err = GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Protocol:  ProtocolJSON,
+		Transport: TransportStdout,
+		Timestamp: func() int64 { return 1 },
+	})
+	pw.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(pr)
+	if expected, found := `{"name":"app.foo.count","value":2,"timestamp":1}`+"\n", string(out); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.Address = c.Addr.String()
+	c.Addr = nil
+	c.Connections = 4
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, name := range names {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+
+	e := NewExporter(c)
+	shards := make(map[*payload]bool)
+	for _, name := range names {
+		e.shards.route(&datapoint{prefix: "foobar", name: name})
+		shards[e.shards.cur] = true
+	}
+	if len(shards) < 2 {
+		t.Fatal("expected metrics to be spread across shards")
+	}
+
+	wg.Add(c.Connections)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		if expected, found := 1.0, res["foobar."+name+".count"]; !floatEquals(found, expected) {
+			t.Fatal("bad value of", name, expected, found)
+		}
+	}
+}
+
+func TestEncodeWorkers(t *testing.T) {
+	r := metrics.NewRegistry()
+	for i := 0; i < 50; i++ {
+		name := strconv.Itoa(i)
+		metrics.GetOrRegisterCounter("counter"+name, r).Inc(int64(i))
+		metrics.GetOrRegisterTimer("timer"+name, r).Update(time.Duration(i) * time.Millisecond)
+		h := metrics.GetOrRegisterHistogram("histogram"+name, r, metrics.NewUniformSample(100))
+		h.Update(int64(i))
+	}
+
+	flush := func(workers int) string {
+		var b strings.Builder
+		err := GraphiteOnce(GraphiteConfig{
+			Registry:      r,
+			Prefix:        "app",
+			DurationUnit:  time.Millisecond,
+			Percentiles:   []float64{0.5, 0.99},
+			Sink:          WriterSink(&b),
+			SortedOutput:  true,
+			EncodeWorkers: workers,
+			Timestamp:     func() int64 { return 1 },
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	if expected, found := flush(0), flush(4); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestPointInTime(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := metrics.GetOrRegisterCounter("b.requests", r)
+	r.Register("a.check", metrics.NewHealthcheck(func(metrics.Healthcheck) { requests.Inc(1) }))
+	metrics.GetOrRegisterTimer("c.latency", r).Update(time.Millisecond)
+
+	flush := func(pointInTime bool, workers int) string {
+		var b strings.Builder
+		err := GraphiteOnce(GraphiteConfig{
+			Registry:      r,
+			Prefix:        "app",
+			DurationUnit:  time.Millisecond,
+			Percentiles:   []float64{0.5},
+			Sink:          WriterSink(&b),
+			SortedOutput:  true,
+			PointInTime:   pointInTime,
+			EncodeWorkers: workers,
+			ExcludeFields: []string{"mean_rate"}, // Changes between flushes
+			Timestamp:     func() int64 { return 1 },
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	if found := flush(false, 0); !strings.Contains(found, "app.b.requests.count 1 1\n") {
+		t.Fatalf("expected the counter incremented by the healthcheck, found %q", found)
+	}
+	// The counter is frozen before the healthcheck increments it again.
+	frozen := flush(true, 0)
+	if !strings.Contains(frozen, "app.b.requests.count 1 1\n") || !strings.Contains(frozen, "app.c.latency.50-percentile 1.00 1\n") {
+		t.Fatalf("expected the counter as of the start of the flush, found %q", frozen)
+	}
+	if expected, found := strings.Replace(frozen, "count 1 1", "count 2 1", 1), flush(true, 4); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+type testLogger []string
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func TestLogInterval(t *testing.T) {
+	var logged testLogger
+	clock := &testClock{now: time.Unix(1000, 0)}
+	e := NewExporter(GraphiteConfig{Logger: &logged, Clock: clock, LogInterval: time.Minute})
+	down := errors.New("graphite: cannot connect: connection refused")
+	for i := 0; i < 10; i++ {
+		e.logError(down)
+		clock.now = clock.now.Add(10 * time.Second)
+	}
+	e.logError(errors.New("graphite: cannot send"))
+	expected := testLogger{
+		down.Error(),
+		down.Error() + " (repeated 6 times in 1m0s)",
+		down.Error() + " (repeated 3 more times)",
+		"graphite: cannot send",
+	}
+	if !reflect.DeepEqual(expected, logged) {
+		t.Fatalf("expected %q, found %q", expected, logged)
+	}
+
+	logged = nil
+	e = NewExporter(GraphiteConfig{Logger: &logged, LogInterval: -1})
+	e.logError(down)
+	e.logError(down)
+	if 2 != len(logged) {
+		t.Fatalf("expected every error logged, found %q", logged)
+	}
+}
+
+func TestMaxConsecutiveFailures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	var errs []error
+	clock := &testClock{now: time.Unix(1000, 0), c: make(chan time.Time)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewExporter(GraphiteConfig{
+			Registry:               metrics.NewRegistry(),
+			Address:                address,
+			FlushInterval:          time.Hour,
+			Clock:                  clock,
+			MaxConsecutiveFailures: 2,
+			OnFailure:              func(err error) { errs = append(errs, err) },
+		}).Run()
+	}()
+
+	clock.c <- clock.now
+	clock.c <- clock.now
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after MaxConsecutiveFailures")
+	}
+	if 1 != len(errs) || nil == errs[0] {
+		t.Fatal("expected OnFailure to be called once:", errs)
+	}
+}
+
+// A blockingSink signals each flush it opens, then waits for it to be
+// released.
+type blockingSink struct {
+	opened, release chan struct{}
+}
+
+func (s blockingSink) Open() (io.WriteCloser, error) {
+	s.opened <- struct{}{}
+	<-s.release
+	return nopCloser{io.Discard}, nil
+}
+
+func TestSkipOverlappingFlushes(t *testing.T) {
+	s := blockingSink{opened: make(chan struct{}), release: make(chan struct{})}
+	clock := &testClock{now: time.Unix(1000, 0), c: make(chan time.Time, 1)}
+	done := make(chan struct{})
+	var buf strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:      metrics.NewRegistry(),
+		Prefix:        "app",
+		FlushInterval: time.Second,
+		Clock:         clock,
+		Sink:          s,
+		SelfMetrics:   true,
+	})
+	go func() {
+		defer close(done)
+		e.Run()
+	}()
+
+	clock.c <- clock.now
+	<-s.opened
+	clock.c <- clock.now
+	clock.now = clock.now.Add(time.Second)
+	s.release <- struct{}{}
+
+	clock.c <- clock.now
+	<-s.opened
+	if expected, found := uint64(1), e.Skipped(); expected != found {
+		t.Fatal("bad skipped count:", expected, found)
+	}
+	s.release <- struct{}{}
+	close(clock.c)
+	<-done
+
+	e.config.Sink = WriterSink(&buf)
+	e.config.Timestamp = func() int64 { return 1 }
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected := "app.graphite.skipped-flushes.value 1 1\n"; !strings.Contains(buf.String(), expected) {
+		t.Fatalf("expected %q in %q", expected, buf.String())
+	}
+}
+
+// A failingSink fails to open every flush.
+type failingSink struct{}
+
+func (failingSink) Open() (io.WriteCloser, error) {
+	return nil, io.ErrClosedPipe
+}
+
+func TestStatus(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	metrics.GetOrRegisterGauge("bar", r).Update(2)
+	clock := &testClock{now: time.Unix(1000, 0)}
+	e := NewExporter(GraphiteConfig{Registry: r, Clock: clock, Sink: WriterSink(io.Discard)})
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	s := e.Status()
+	if !s.LastFlushTime.Equal(clock.now) || nil != s.LastError || 2 != s.DatapointsSent {
+		t.Fatalf("bad status: %+v", s)
+	}
+
+	e.config.Sink = failingSink{}
+	if err := e.Once(); nil == err {
+		t.Fatal("expected an error")
+	}
+	if s := e.Status(); !errors.Is(s.LastError, io.ErrClosedPipe) || 2 != s.DatapointsSent {
+		t.Fatalf("bad status: %+v", s)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:      metrics.NewRegistry(),
+		Prefix:        "app",
+		Sink:          WriterSink(&b),
+		Timestamp:     func() int64 { return 1 },
+		Heartbeat:     true,
+		SkipUnchanged: true,
+	})
+	for i := 0; i < 2; i++ {
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if expected, found := strings.Repeat("app.exporter.heartbeat 1.000000 1\n", 2), b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestStartEpoch(t *testing.T) {
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:   metrics.NewRegistry(),
+		Prefix:     "app",
+		Sink:       WriterSink(&b),
+		Timestamp:  func() int64 { return 1 },
+		StartEpoch: true,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := fmt.Sprintf("app.exporter.start_epoch %d.000000 1\n", processStart.Unix()), b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestNoMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Sink:      WriterSink(&b),
+		Timestamp: func() int64 { return 1 },
+		NoMetrics: true,
+		Filter:    func(name string) bool { return "foo" != name },
+	})
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	if err := e.Once(); !errors.Is(err, ErrNoMetrics) {
+		t.Fatal("expected ErrNoMetrics:", err)
+	}
+	if expected, found := "app.exporter.no_metrics 1.000000 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	b.Reset()
+	metrics.GetOrRegisterCounter("bar", r).Inc(1)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.bar.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	b.Reset()
+	e = NewExporter(GraphiteConfig{
+		Registry:       metrics.NewRegistry(),
+		Prefix:         "app",
+		Sink:           WriterSink(&b),
+		Timestamp:      func() int64 { return 1 },
+		NoMetrics:      true,
+		SelfMetrics:    true,
+		RuntimeMetrics: true,
+	})
+	if err := e.Once(); !errors.Is(err, ErrNoMetrics) {
+		t.Fatal("expected ErrNoMetrics along with self metrics:", err)
+	}
+	if !strings.Contains(b.String(), "app.exporter.no_metrics 1.000000 1\n") {
+		t.Fatal("no_metrics not exported along with self metrics:", b.String())
+	}
+}
+
+// A testTracer records the flushes it traces.
+type testTracer struct {
+	endpoints []string
+	stats     []FlushStats
+}
+
+func TestMaxSeries(t *testing.T) {
+	r := metrics.NewRegistry()
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		SortedOutput: true,
+		Heartbeat:    true,
+		MaxSeries:    2,
+	})
+	for _, name := range []string{"a", "b", "c", "d"} {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+	err := e.Once()
+	var ferr *FlushError
+	if !errors.As(err, &ferr) || 1 != len(ferr.Errors) || !errors.Is(ferr.Errors[0], ErrMaxSeries) {
+		t.Fatal("bad errors reported:", err)
+	}
+	if expected, found := "app.a.count 1 1\napp.b.count 1 1\napp.exporter.heartbeat 1.000000 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+	if 2 != e.SeriesDropped() {
+		t.Fatal("bad count of dropped series:", e.SeriesDropped())
+	}
+}
+
+func TestResetAfterFlushDropped(t *testing.T) {
+	for _, c := range []GraphiteConfig{{MaxSeries: 1}, {ByteQuota: 20}} {
+		r := metrics.NewRegistry()
+		a, b := metrics.GetOrRegisterCounter("a", r), metrics.GetOrRegisterCounter("b", r)
+		a.Inc(3)
+		b.Inc(4)
+		var w strings.Builder
+		c.Registry, c.Prefix, c.Sink, c.Timestamp = r, "app", WriterSink(&w), func() int64 { return 1 }
+		c.SortedOutput, c.ResetAfterFlush = true, true
+		if err := GraphiteOnce(c); nil != err && !errors.Is(err, ErrMaxSeries) {
+			t.Fatal(err)
+		}
+		if expected, found := "app.a.count 3 1\n", w.String(); expected != found {
+			t.Fatalf("expected %q, found %q", expected, found)
+		}
+		if 0 != a.Count() || 4 != b.Count() {
+			t.Fatal("expected the counter which was not sent to be kept:", a.Count(), b.Count())
+		}
+	}
+}
+
+func (t *testTracer) StartFlush(ctx context.Context, endpoint string) (context.Context, func(FlushStats)) {
+	t.endpoints = append(t.endpoints, endpoint)
+	return ctx, func(s FlushStats) { t.stats = append(t.stats, s) }
+}
+
+func TestTracer(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	tracer := &testTracer{}
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Sink:      WriterSink(io.Discard),
+		Timestamp: func() int64 { return 1 },
+		Tracer:    tracer,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(tracer.endpoints) || 1 != len(tracer.stats) {
+		t.Fatal("expected one traced flush:", tracer.endpoints, tracer.stats)
+	}
+	if expected, found := "graphite.writerSink", tracer.endpoints[0]; expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+	if s := tracer.stats[0]; 1 != s.Datapoints || 18 != s.Bytes || nil != s.Err {
+		t.Fatal("bad stats:", s)
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter("foo", r).Mark(1)
+
+	var b strings.Builder
+	c := GraphiteConfig{
+		Registry:    r,
+		Prefix:      "app",
+		Sink:        WriterSink(&b),
+		Timestamp:   func() int64 { return 1 },
+		MeterFields: []string{"count"},
+	}
+	e := NewExporter(c)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	c.Prefix, c.MeterFields = "web", []string{"count", "mean_rate"}
+	e.UpdateConfig(c)
+	if expected, found := "app", e.config.Prefix; expected != found {
+		t.Fatalf("expected %s before the next flush, found %s", expected, found)
+	}
+	b.Reset()
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if 2 != len(lines) || !strings.HasPrefix(lines[0], "web.foo.count 1 ") || !strings.HasPrefix(lines[1], "web.foo.mean ") {
+		t.Fatalf("expected the updated configuration, found %q", b.String())
+	}
+}
+
+func TestStatusDuringUpdateConfig(t *testing.T) {
+	c := GraphiteConfig{Registry: metrics.NewRegistry(), Sink: WriterSink(io.Discard), Timestamp: func() int64 { return 1 }}
+	e := NewExporter(c)
+	stop, done := make(chan bool), make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				e.Status()
+			}
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		e.UpdateConfig(c)
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestSetPrefix(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app.shard1",
+		Sink:      WriterSink(&b),
+		Timestamp: func() int64 { return 1 },
+	})
+	done := make(chan bool)
+	go func() {
+		e.SetPrefix("app.shard2")
+		close(done)
+	}()
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	<-done
+	b.Reset()
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.shard2.foo.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:      metrics.NewRegistry(),
+		Prefix:        "app",
+		Sink:          WriterSink(&b),
+		Timestamp:     func() int64 { return 1 },
+		Metadata:      map[string]int64{"build": 1234, "config.generation": 7},
+		SkipUnchanged: true,
+	})
+	for i := 0; i < 2; i++ {
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if expected, found := strings.Repeat("app.build.value 1234 1\napp.config.generation.value 7 1\n", 2), b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestRegisterGaugeFunc(t *testing.T) {
+	var b strings.Builder
+	e := NewExporter(GraphiteConfig{
+		Registry:  metrics.NewRegistry(),
+		Prefix:    "app",
+		Sink:      WriterSink(&b),
+		Timestamp: func() int64 { return 1 },
+	})
+	depth := 0.0
+	e.RegisterGaugeFunc("queue.depth", func() float64 { depth++; return depth })
+	e.RegisterGaugeFunc("cache.size", func() float64 { return 2.5 })
+	for i := 0; i < 2; i++ {
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	e.UnregisterGaugeFunc("queue.depth")
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	expected := "app.cache.size.value 2.500000 1\napp.queue.depth.value 1.000000 1\n" +
+		"app.cache.size.value 2.500000 1\napp.queue.depth.value 2.000000 1\n" +
+		"app.cache.size.value 2.500000 1\n"
+	if found := b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestFlushHooks(t *testing.T) {
+	r := metrics.NewRegistry()
+	g := metrics.GetOrRegisterGauge("bar", r)
+	clock := &testClock{now: time.Unix(1000, 0)}
+	var b strings.Builder
+	var stats []FlushStats
+	e := NewExporter(GraphiteConfig{
+		Registry:    r,
+		Prefix:      "app",
+		Clock:       clock,
+		Sink:        WriterSink(&b),
+		BeforeFlush: func() { g.Update(g.Value() + 1) },
+		AfterFlush:  func(s FlushStats) { stats = append(stats, s) },
+	})
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.bar.value 1 1000\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+	e.config.Sink = failingSink{}
+	if err := e.Once(); nil == err {
+		t.Fatal("expected an error")
+	}
+	if 2 != len(stats) {
+		t.Fatal("expected two flushes, found", len(stats))
+	}
+	if s := stats[0]; !s.Time.Equal(clock.now) || 1 != s.Datapoints || len(b.String()) != s.Bytes || nil != s.Err {
+		t.Fatalf("bad stats: %+v", s)
+	}
+	if s := stats[1]; !errors.Is(s.Err, io.ErrClosedPipe) || 1 != s.Datapoints {
+		t.Fatalf("bad stats: %+v", s)
+	}
+}
+
+func TestOnLine(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterGaugeFloat64("foo", r).Update(1.5)
+
+	var lines []string
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Sink:      WriterSink(io.Discard),
+		Timestamp: func() int64 { return 1 },
+		OnLine: func(name, value string, ts int64) {
+			lines = append(lines, name+"="+value+"@"+strconv.FormatInt(ts, 10))
+		},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "app.foo.value=1.5@1", strings.Join(lines, " "); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func BenchmarkFlushDiscard(b *testing.B) {
+	r := metrics.NewRegistry()
+	for i := 0; i < 100; i++ {
+		metrics.GetOrRegisterTimer("requests.latency"+strconv.Itoa(i), r).Update(time.Duration(i) * time.Millisecond)
+	}
+	e := NewExporter(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Transport:    TransportDiscard,
+		DurationUnit: time.Millisecond,
+		Percentiles:  []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+	})
+	defer e.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := e.Once(); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
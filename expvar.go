@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"expvar"
+	"time"
+)
+
+// expvarGauge and expvarGaugeFloat64 present expvar vars as gauges.
+type (
+	expvarGauge struct {
+		v *expvar.Int
+	}
+	expvarGaugeFloat64 struct {
+		v *expvar.Float
+	}
+)
+
+func (g expvarGauge) Value() int64 {
+	return g.v.Value()
+}
+
+func (g expvarGauge) Update(v int64) {
+	g.v.Set(v)
+}
+
+func (g expvarGaugeFloat64) Value() float64 {
+	return g.v.Value()
+}
+
+func (g expvarGaugeFloat64) Update(v float64) {
+	g.v.Set(v)
+}
+
+// snapshotExpvar appends the published expvar Int and Float vars, and those
+// within Maps, to the snapshot as gauges. Vars within maps are named after
+// the map and their key, joined by a dot. Other vars are skipped.
+func (e *Exporter) snapshotExpvar(prefix string, now time.Time) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		e.snapshotVar(prefix, kv.Key, kv.Value, now)
+	})
+}
+
+func (e *Exporter) snapshotVar(prefix, name string, v expvar.Var, now time.Time) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		e.snapshotMetric(prefix, name, expvarGauge{v}, now)
+	case *expvar.Float:
+		e.snapshotMetric(prefix, name, expvarGaugeFloat64{v}, now)
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			e.snapshotVar(prefix, name+"."+kv.Key, kv.Value, now)
+		})
+	}
+}
+
+// expvarPrefix returns the prefix expvar vars are exported under.
+func (c *GraphiteConfig) expvarPrefix() string {
+	if "" != c.ExpvarPrefix {
+		return c.ExpvarPrefix
+	}
+	return c.Prefix
+}
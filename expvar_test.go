@@ -0,0 +1,47 @@
+package graphite
+
+import (
+	"expvar"
+	"testing"
+)
+
+// The vars of TestExpvar, published once as expvar panics on publishing a
+// name again, such as when tests are run with -count.
+var (
+	testInt    = expvar.NewInt("graphite_test_int")
+	testFloat  = expvar.NewFloat("graphite_test_float")
+	testMap    = expvar.NewMap("graphite_test_map")
+	testString = expvar.NewString("graphite_test_string")
+)
+
+func TestExpvar(t *testing.T) {
+	res, l, _, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	testInt.Set(3)
+	testFloat.Set(1.5)
+	testMap.Init()
+	testMap.Add("hits", 7)
+	testMap.AddFloat("load", 0.5)
+	testString.Set("skipped")
+
+	c.Expvar = true
+	c.ExpvarPrefix = "vars"
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for name, expected := range map[string]float64{
+		"vars.graphite_test_int.value":      3,
+		"vars.graphite_test_float.value":    1.5,
+		"vars.graphite_test_map.hits.value": 7,
+		"vars.graphite_test_map.load.value": 0.5,
+	} {
+		if found := res[name]; !floatEquals(found, expected) {
+			t.Error("bad value:", name, expected, found)
+		}
+	}
+	if _, found := res["vars.graphite_test_string.value"]; found {
+		t.Error("string var exported")
+	}
+}
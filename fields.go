@@ -0,0 +1,100 @@
+package graphite
+
+// fieldNames maps the names accepted by HistogramFields, MeterFields and
+// TimerFields to the fields they select. Percentiles are selected by their
+// PercentileP key, such as "p99" or "p999".
+var fieldNames = map[string]field{
+	"count":     fieldHistogramCount,
+	"min":       fieldMin,
+	"max":       fieldMax,
+	"mean":      fieldMean,
+	"stddev":    fieldStddev,
+	"sum":       fieldSum,
+	"total":     fieldTotal,
+	"m1_rate":   fieldRate1,
+	"m5_rate":   fieldRate5,
+	"m15_rate":  fieldRate15,
+	"mean_rate": fieldRateMean,
+
+	"variance":    fieldVariance,
+	"sample_size": fieldSampleSize,
+}
+
+// fieldFamilies maps names which select several fields at once to their
+// fields.
+var fieldFamilies = map[string][]field{
+	"rates":       {fieldRate1, fieldRate5, fieldRate15},
+	"percentiles": {fieldPercentile},
+	"buckets":     {fieldBucket},
+}
+
+// A fieldSet is the selection of fields exported for a type of metric.
+type fieldSet struct {
+	fields    map[field]bool
+	quantiles map[float64]bool
+}
+
+// newFieldSet returns the selection of the named fields out of those
+// exported with the given percentiles, or nil if names is empty and every
+// field is exported. Unknown names are ignored.
+func newFieldSet(names []string, percentiles []float64) *fieldSet {
+	if 0 == len(names) {
+		return nil
+	}
+	s := &fieldSet{fields: make(map[field]bool), quantiles: make(map[float64]bool)}
+	for _, name := range names {
+		for _, f := range fieldFamilies[name] {
+			s.fields[f] = true
+		}
+		if f, ok := fieldNames[name]; ok {
+			s.fields[f] = true
+			if fieldHistogramCount == f {
+				s.fields[fieldMeterCount], s.fields[fieldTimerCount] = true, true
+			}
+			if fieldRateMean == f {
+				s.fields[fieldMeterRateMean] = true
+			}
+			continue
+		}
+		for _, p := range percentiles {
+			if percentileKey(p, PercentileP) == name {
+				s.quantiles[p] = true
+			}
+		}
+	}
+	return s
+}
+
+// has reports whether dp belongs to a selected field.
+func (s *fieldSet) has(dp *datapoint) bool {
+	if fieldPercentile == dp.field {
+		return s.fields[fieldPercentile] || s.quantiles[dp.quantile]
+	}
+	return s.fields[dp.field]
+}
+
+// optional reports whether f, one of the fields of histograms and timers
+// only exported on demand, is exported for metrics of kind k, selected by
+// OptionalFields or the fields of k.
+func (e *Exporter) optional(k kind, f field) bool {
+	if s := e.fields[k]; nil != s && s.fields[f] {
+		return true
+	}
+	return nil != e.extras && e.extras.fields[f]
+}
+
+// selectFields drops the datapoints of the fields not selected for their
+// kind of metric, and of those excluded for every kind, from dps.
+func (e *Exporter) selectFields(dps []datapoint, k kind) []datapoint {
+	s, x := e.fields[k], e.excluded
+	if nil == s && nil == x {
+		return dps
+	}
+	out := dps[:0]
+	for i := range dps {
+		if (nil == s || s.has(&dps[i])) && (nil == x || !x.has(&dps[i])) {
+			out = append(out, dps[i])
+		}
+	}
+	return out
+}
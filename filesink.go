@@ -0,0 +1,113 @@
+package graphite
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// A FileSink is a Sink which appends every flush to a local file, such as
+// on hosts without a route to Graphite, to upload the file later, or as the
+// Mirror of what is sent. Once the file would grow beyond MaxSize it is
+// rotated: renamed to Path.1, after renaming the previous Path.1 to Path.2
+// and so on, keeping MaxBackups of them.
+//
+// A FileSink is safe for concurrent use, and keeps the file open between
+// flushes until it is closed.
+type FileSink struct {
+	Path       string // File appended to, created if it does not exist
+	MaxSize    int64  // Size in bytes beyond which the file is rotated, zero never rotates it
+	MaxBackups int    // Rotated files kept, older ones are removed; zero removes the file when rotating
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (s *FileSink) Open() (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); nil != err {
+		return nil, err
+	}
+	return nopCloser{fileSinkWriter{s}}, nil
+}
+
+// open opens the file unless it is open already.
+func (s *FileSink) open() error {
+	if nil != s.f {
+		return nil
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if nil != err {
+		return err
+	}
+	fi, err := f.Stat()
+	if nil != err {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+// rotate closes the file and renames it to the first backup, shifting the
+// previous backups.
+func (s *FileSink) rotate() error {
+	err := s.f.Close()
+	s.f = nil
+	if nil != err {
+		return err
+	}
+	if 0 >= s.MaxBackups {
+		return os.Remove(s.Path)
+	}
+	os.Remove(s.backup(s.MaxBackups))
+	for i := s.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(s.backup(i), s.backup(i+1))
+	}
+	return os.Rename(s.Path, s.backup(1))
+}
+
+func (s *FileSink) backup(i int) string {
+	return s.Path + "." + strconv.Itoa(i)
+}
+
+// Close closes the file. A later flush opens it again.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.f {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// A fileSinkWriter appends each batch to the file of a FileSink, rotating
+// it first if the batch would make it grow beyond MaxSize.
+type fileSinkWriter struct {
+	s *FileSink
+}
+
+func (w fileSinkWriter) Write(b []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); nil != err {
+		return 0, err
+	}
+	if 0 < s.MaxSize && 0 < s.size && s.size+int64(len(b)) > s.MaxSize {
+		if err := s.rotate(); nil != err {
+			return 0, err
+		}
+		if err := s.open(); nil != err {
+			return 0, err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return n, err
+}
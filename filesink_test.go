@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestFileSink(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+
+	path := filepath.Join(t.TempDir(), "metrics.txt")
+	s := &FileSink{Path: path, MaxSize: 40, MaxBackups: 1}
+	defer s.Close()
+	e := NewExporter(GraphiteConfig{Registry: r, Prefix: "app", Sink: s, Timestamp: func() int64 { return 1 }})
+	for i := 0; i < 5; i++ {
+		if err := e.Once(); nil != err {
+			t.Fatal(err)
+		}
+	}
+	line := "app.foo.count 1 1\n"
+	for name, expected := range map[string]string{
+		path:        line,
+		path + ".1": strings.Repeat(line, 2),
+	} {
+		b, err := os.ReadFile(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if expected != string(b) {
+			t.Fatalf("expected %q in %s, found %q", expected, name, b)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Fatal("expected a single backup:", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+
+	var b strings.Builder
+	c.Mirror = WriterSink(&b)
+	c.Timestamp = func() int64 { return 1 }
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if expected, found := 1.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if expected, found := "foobar.foo.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
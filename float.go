@@ -0,0 +1,83 @@
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// appendDecimal appends v to b in decimal notation with the given number of
+// significant digits, or the shortest representation which reads back as v
+// if digits is negative. Digits of the integer part are never dropped, and
+// trailing zeros of the fraction are.
+func appendDecimal(b []byte, v float64, digits int) []byte {
+	if 0 > digits || 0 == v || math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.AppendFloat(b, v, 'f', -1, 64)
+	}
+	prec := digits - 1 - int(math.Floor(math.Log10(math.Abs(v))))
+	if 0 > prec {
+		prec = 0
+	}
+	b = strconv.AppendFloat(b, v, 'f', prec, 64)
+	if 0 == prec {
+		return b
+	}
+	for '0' == b[len(b)-1] {
+		b = b[:len(b)-1]
+	}
+	if '.' == b[len(b)-1] {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// exponentDigits returns the significant digits of a value rendered by the
+// exponent form verb of fmt with precision prec, negative if unset.
+func exponentDigits(verb rune, prec int) int {
+	switch {
+	case 'e' == verb || 'E' == verb:
+		if 0 > prec {
+			prec = 6
+		}
+		return prec + 1
+	case 0 == prec:
+		return 1
+	}
+	return prec
+}
+
+// A fixedFloat formats a value for the format strings fmt renders, in
+// decimal notation with the given significant digits whatever the verb, or
+// with the digits of exponent form verbs if zero, since Graphite rejects
+// values in exponent form.
+type fixedFloat struct {
+	v      float64
+	digits int
+}
+
+func (f fixedFloat) Format(s fmt.State, verb rune) {
+	digits := f.digits
+	if 0 == digits {
+		switch verb {
+		case 'e', 'E', 'g', 'G', 'v':
+			prec, ok := s.Precision()
+			if !ok {
+				prec = -1
+			}
+			digits = exponentDigits(verb, prec)
+		default:
+			fmt.Fprintf(s, fmt.FormatString(s, verb), f.v)
+			return
+		}
+	}
+	s.Write(appendDecimal(nil, f.v, digits))
+}
+
+// appendFloat appends v to b as GraphiteConfig.FloatPrecision asks, in its
+// shortest representation unless set.
+func (n *namer) appendFloat(b []byte, v float64) []byte {
+	if 0 == n.digits {
+		return appendDecimal(b, v, -1)
+	}
+	return appendDecimal(b, v, n.digits)
+}
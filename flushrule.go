@@ -0,0 +1,33 @@
+package graphite
+
+// A FlushRule exports the metrics it matches only every Every flushes, so
+// that slow moving series can be sent less often than the rest of a
+// registry. Matching metrics are exported by the first flush and every
+// Every-th flush after it.
+type FlushRule struct {
+	Match func(name string) bool // Whether the rule applies to a metric, by its name within its registry
+	Every int                    // Export matching metrics every this many flushes
+}
+
+// skipped reports whether the named metric is not exported by the given
+// flush, counting from one, according to the first matching FlushRule.
+func (c *GraphiteConfig) skipped(name string, flush uint64) bool {
+	for _, r := range c.FlushRules {
+		if r.Match(name) {
+			return 1 < r.Every && 0 != (flush-1)%uint64(r.Every)
+		}
+	}
+	return false
+}
+
+// retainFlushes returns for how many flushes the state of a metric has to be
+// kept after it was last exported.
+func (c *GraphiteConfig) retainFlushes() int {
+	retain := 1
+	for _, r := range c.FlushRules {
+		if r.Every > retain {
+			retain = r.Every
+		}
+	}
+	return retain
+}
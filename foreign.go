@@ -0,0 +1,111 @@
+package graphite
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/dt/go-metrics"
+)
+
+// The values read from histograms, meters and timers, both from the
+// snapshots of github.com/dt/go-metrics and from the metrics of other forks
+// of go-metrics.
+type (
+	histogramValues interface {
+		Count() int64
+		Min() int64
+		Max() int64
+		Mean() float64
+		StdDev() float64
+		Sum() int64
+		Percentiles([]float64) []float64
+	}
+	rateValues interface {
+		Rate1() float64
+		Rate5() float64
+		Rate15() float64
+		RateMean() float64
+	}
+	meterValues interface {
+		Count() int64
+		rateValues
+	}
+	timerValues interface {
+		histogramValues
+		rateValues
+	}
+)
+
+// Metrics of forks of go-metrics, such as github.com/rcrowley/go-metrics,
+// recognised by their methods, which unlike their Snapshot methods don't
+// refer to types of the fork. Registries of such forks satisfy
+// metrics.Registry, so they can be exported as they are, though their
+// metrics are read without taking a snapshot first.
+type (
+	foreignCounter interface {
+		Clear()
+		Count() int64
+		Dec(int64)
+		Inc(int64)
+	}
+	foreignGauge interface {
+		Update(int64)
+		Value() int64
+	}
+	foreignGaugeFloat64 interface {
+		Update(float64)
+		Value() float64
+	}
+	foreignHistogram interface {
+		histogramValues
+		Clear()
+		Update(int64)
+	}
+	foreignMeter interface {
+		meterValues
+		Mark(int64)
+	}
+	foreignTimer interface {
+		timerValues
+		Time(func())
+		Update(time.Duration)
+		UpdateSince(time.Time)
+	}
+	foreignEWMA interface {
+		Rate() float64
+		Tick()
+		Update(int64)
+	}
+)
+
+// A sampleValues is the sample of a histogram, of go-metrics or of a fork.
+type sampleValues interface {
+	Size() int
+	Values() []int64
+}
+
+// sampleOf returns the sample of histogram h, if it has one. The Sample
+// methods of the histograms of forks return a type of the fork, so they are
+// looked up by name.
+func sampleOf(h interface{}) (sampleValues, bool) {
+	if s, ok := h.(interface{ Sample() metrics.Sample }); ok {
+		return s.Sample(), true
+	}
+	m := reflect.ValueOf(h).MethodByName("Sample")
+	if !m.IsValid() || 0 != m.Type().NumIn() || 1 != m.Type().NumOut() {
+		return nil, false
+	}
+	s, ok := m.Call(nil)[0].Interface().(sampleValues)
+	return s, ok
+}
+
+// snapshotOf returns the snapshot of metric i of a fork, or i itself if it
+// has none. Like those of Sample, the Snapshot methods of forks return types
+// of the fork, so they are looked up by name.
+func snapshotOf(i interface{}) interface{} {
+	m := reflect.ValueOf(i).MethodByName("Snapshot")
+	if !m.IsValid() || 0 != m.Type().NumIn() || 1 != m.Type().NumOut() {
+		return i
+	}
+	return m.Call(nil)[0].Interface()
+}
@@ -0,0 +1,87 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// upstreamCounter and upstreamHistogram have the methods of the metrics of
+// another fork of go-metrics, whose Snapshot methods return its own types.
+type upstreamCounter struct {
+	c metrics.Counter
+}
+
+func (u upstreamCounter) Clear()        { u.c.Clear() }
+func (u upstreamCounter) Count() int64  { return u.c.Count() }
+func (u upstreamCounter) Dec(i int64)   { u.c.Dec(i) }
+func (u upstreamCounter) Inc(i int64)   { u.c.Inc(i) }
+func (u upstreamCounter) Snapshot() int { return 0 }
+
+type upstreamHistogram struct {
+	h metrics.Histogram
+}
+
+func (u upstreamHistogram) Clear()                             { u.h.Clear() }
+func (u upstreamHistogram) Count() int64                       { return u.h.Count() }
+func (u upstreamHistogram) Min() int64                         { return u.h.Min() }
+func (u upstreamHistogram) Max() int64                         { return u.h.Max() }
+func (u upstreamHistogram) Mean() float64                      { return u.h.Mean() }
+func (u upstreamHistogram) StdDev() float64                    { return u.h.StdDev() }
+func (u upstreamHistogram) Sum() int64                         { return u.h.Sum() }
+func (u upstreamHistogram) Percentiles(ps []float64) []float64 { return u.h.Percentiles(ps) }
+func (u upstreamHistogram) Update(v int64)                     { u.h.Update(v) }
+func (u upstreamHistogram) Sample() upstreamSample             { return upstreamSample{u.h.Sample()} }
+
+// An upstreamSample is the sample of an upstreamHistogram, of a type of its
+// fork.
+type upstreamSample struct {
+	s metrics.Sample
+}
+
+func (u upstreamSample) Size() int       { return u.s.Size() }
+func (u upstreamSample) Values() []int64 { return u.s.Values() }
+
+func TestForeignMetrics(t *testing.T) {
+	c := upstreamCounter{metrics.NewCounter()}
+	c.Inc(3)
+	h := upstreamHistogram{metrics.NewHistogram(metrics.NewUniformSample(10))}
+	h.Update(4)
+
+	e := NewExporter(GraphiteConfig{Percentiles: []float64{0.5}})
+	values := map[string]float64{}
+	for name, m := range map[string]interface{}{"c": c, "h": h} {
+		for _, dp := range e.appendDatapoints(nil, "app", name, m) {
+			v := dp.fvalue
+			if dp.field.integer() {
+				v = float64(dp.ivalue)
+			}
+			values[e.namer.path(&dp)] = v
+		}
+	}
+
+	for name, expected := range map[string]float64{
+		"app.c.count":         3,
+		"app.h.count":         1,
+		"app.h.max":           4,
+		"app.h.sum":           4,
+		"app.h.50-percentile": 4,
+	} {
+		if found, ok := values[name]; !ok || !floatEquals(found, expected) {
+			t.Error("bad value:", name, expected, found)
+		}
+	}
+}
+
+func TestForeignSample(t *testing.T) {
+	h := upstreamHistogram{metrics.NewHistogram(metrics.NewUniformSample(10))}
+	h.Update(4)
+	h.Update(2)
+	s, ok := sampleOf(h)
+	if !ok || 2 != s.Size() {
+		t.Fatal("sample of a fork not found:", s, ok)
+	}
+	if _, ok := sampleOf(upstreamCounter{metrics.NewCounter()}); ok {
+		t.Fatal("sample found for a counter")
+	}
+}
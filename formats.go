@@ -13,6 +13,20 @@ type ExportFormatStrings struct {
 	Rate1          string
 	Rate5          string
 	Rate15         string
+	RateMean       string
+	MeterRateMean  string
+	MeterCount     string
+	TimerCount     string
+	Healthcheck    string
+	HealthErrors   string
+	EWMA           string
+	Sum            string
+	Total          string
+	CounterRate    string
+	Bucket         string
+	Custom         string
+	Variance       string
+	SampleSize     string
 }
 
 var ExportFormats = ExportFormatStrings{
@@ -28,8 +42,26 @@ var ExportFormats = ExportFormatStrings{
 	Rate1:          "%s.%s.one-minute %.2f %d\n",
 	Rate5:          "%s.%s.five-minute %.2f %d\n",
 	Rate15:         "%s.%s.fifteen-minute %.2f %d\n",
+	RateMean:       "%s.%s.mean-rate %.2f %d\n",
+	MeterRateMean:  "%s.%s.mean %.2f %d\n",
+	MeterCount:     "%s.%s.count %d %d\n",
+	TimerCount:     "%s.%s.count %d %d\n",
+	Healthcheck:    "%s.%s.healthy %d %d\n",
+	HealthErrors:   "%s.%s.errors %d %d\n",
+	EWMA:           "%s.%s.rate %.2f %d\n",
+	Sum:            "%s.%s.sum %d %d\n",
+	Total:          "%s.%s.total %.2f %d\n",
+	CounterRate:    "%s.%s.rate %.2f %d\n",
+	Bucket:         "%s.%s.%s %d %d\n",
+	Custom:         "%s.%s.%s %f %d\n",
+	Variance:       "%s.%s.variance %.2f %d\n",
+	SampleSize:     "%s.%s.sample_size %d %d\n",
 }
 
+// defaultFormats holds the original ExportFormats, used for any format
+// left empty in a replacement.
+var defaultFormats = ExportFormats
+
 // An alternate export format that formats percentile paths more like twitter's ostrich.
 var OstrichFormats = ExportFormatStrings{
 	Counter:        "%s.%s.count %d %d\n",
@@ -44,4 +76,166 @@ var OstrichFormats = ExportFormatStrings{
 	Rate1:          "%s.%s.one-minute %.2f %d\n",
 	Rate5:          "%s.%s.five-minute %.2f %d\n",
 	Rate15:         "%s.%s.fifteen-minute %.2f %d\n",
+	RateMean:       "%s.%s.mean-rate %.2f %d\n",
+	MeterRateMean:  "%s.%s.mean %.2f %d\n",
+	MeterCount:     "%s.%s.count %d %d\n",
+	TimerCount:     "%s.%s.count %d %d\n",
+	Healthcheck:    "%s.%s.healthy %d %d\n",
+	HealthErrors:   "%s.%s.errors %d %d\n",
+	EWMA:           "%s.%s.rate %.2f %d\n",
+	Sum:            "%s.%s.sum %d %d\n",
+	Total:          "%s.%s.total %.2f %d\n",
+	CounterRate:    "%s.%s.rate %.2f %d\n",
+	Bucket:         "%s.%s.%s %d %d\n",
+	Custom:         "%s.%s.%s %f %d\n",
+	Variance:       "%s.%s.variance %.2f %d\n",
+	SampleSize:     "%s.%s.sample_size %d %d\n",
+}
+
+// CodahaleFormats are the default ExportFormats, which name series like the
+// metrics library of Coda Hale this package derives from, such as
+// "requests.count", "latency.99-percentile" or "queue.value".
+var CodahaleFormats = defaultFormats
+
+// StatsDFormats name series like the Graphite backend of statsd, under a
+// root for each type of metric, such as "counters.requests.count",
+// "gauges.queue" or "timers.latency.upper_99". Histograms and meters,
+// which statsd lacks, are exported under timers. Percentiles keep this root
+// only with PercentileDefault.
+var StatsDFormats = ExportFormatStrings{
+	Counter:        "%s.counters.%s.count %d %d\n",
+	HistogramCount: "%s.timers.%s.count %d %d\n",
+	Gauge:          "%s.gauges.%s %d %d\n",
+	GaugeFloat64:   "%s.gauges.%s %f %d\n",
+	Min:            "%s.timers.%s.lower %d %d\n",
+	Max:            "%s.timers.%s.upper %d %d\n",
+	Mean:           "%s.timers.%s.mean %.2f %d\n",
+	Stddev:         "%s.timers.%s.std %.2f %d\n",
+	Percentile:     "%s.timers.%s.upper_%s %.2f %d\n",
+	Rate1:          "%s.timers.%s.one-minute %.2f %d\n",
+	Rate5:          "%s.timers.%s.five-minute %.2f %d\n",
+	Rate15:         "%s.timers.%s.fifteen-minute %.2f %d\n",
+	RateMean:       "%s.timers.%s.count_ps %.2f %d\n",
+	MeterRateMean:  "%s.timers.%s.count_ps %.2f %d\n",
+	MeterCount:     "%s.timers.%s.count %d %d\n",
+	TimerCount:     "%s.timers.%s.count %d %d\n",
+	Healthcheck:    "%s.gauges.%s.healthy %d %d\n",
+	HealthErrors:   "%s.counters.%s.errors %d %d\n",
+	EWMA:           "%s.counters.%s.rate %.2f %d\n",
+	Sum:            "%s.timers.%s.sum %d %d\n",
+	Total:          "%s.timers.%s.sum %.2f %d\n",
+	CounterRate:    "%s.counters.%s.rate %.2f %d\n",
+	Bucket:         "%s.timers.%s.%s %d %d\n",
+	Custom:         "%s.%s.%s %f %d\n",
+	Variance:       "%s.timers.%s.variance %.2f %d\n",
+	SampleSize:     "%s.timers.%s.sample_size %d %d\n",
+}
+
+// DropwizardFormats name series like the GraphiteReporter of Dropwizard
+// Metrics, such as "requests.count", "latency.p99", "requests.m1_rate" or
+// "queue" for gauges, so that services ported from Java keep their
+// dashboards.
+var DropwizardFormats = ExportFormatStrings{
+	Counter:        "%s.%s.count %d %d\n",
+	HistogramCount: "%s.%s.count %d %d\n",
+	Gauge:          "%s.%s %d %d\n",
+	GaugeFloat64:   "%s.%s %f %d\n",
+	Min:            "%s.%s.min %d %d\n",
+	Max:            "%s.%s.max %d %d\n",
+	Mean:           "%s.%s.mean %.2f %d\n",
+	Stddev:         "%s.%s.stddev %.2f %d\n",
+	Percentile:     "%s.%s.p%s %.2f %d\n",
+	Rate1:          "%s.%s.m1_rate %.2f %d\n",
+	Rate5:          "%s.%s.m5_rate %.2f %d\n",
+	Rate15:         "%s.%s.m15_rate %.2f %d\n",
+	RateMean:       "%s.%s.mean_rate %.2f %d\n",
+	MeterRateMean:  "%s.%s.mean_rate %.2f %d\n",
+	MeterCount:     "%s.%s.count %d %d\n",
+	TimerCount:     "%s.%s.count %d %d\n",
+	Healthcheck:    "%s.%s.healthy %d %d\n",
+	HealthErrors:   "%s.%s.errors %d %d\n",
+	EWMA:           "%s.%s.rate %.2f %d\n",
+	Sum:            "%s.%s.sum %d %d\n",
+	Total:          "%s.%s.total %.2f %d\n",
+	CounterRate:    "%s.%s.rate %.2f %d\n",
+	Bucket:         "%s.%s.%s %d %d\n",
+	Custom:         "%s.%s.%s %f %d\n",
+	Variance:       "%s.%s.variance %.2f %d\n",
+	SampleSize:     "%s.%s.sample_size %d %d\n",
+}
+
+// Naming conventions for GraphiteConfig.Naming, each applying the bundled
+// format strings, so that services migrating from other libraries keep the
+// names their dashboards expect.
+const (
+	NamingDefault    = ""           // ExportFormats, which may be replaced
+	NamingCodahale   = "codahale"   // CodahaleFormats
+	NamingStatsD     = "statsd"     // StatsDFormats
+	NamingDropwizard = "dropwizard" // DropwizardFormats
+)
+
+// namingFormats maps the Naming constants to their format strings.
+var namingFormats = map[string]*ExportFormatStrings{
+	NamingCodahale:   &CodahaleFormats,
+	NamingStatsD:     &StatsDFormats,
+	NamingDropwizard: &DropwizardFormats,
+}
+
+// format returns the format string for fl.
+func (f *ExportFormatStrings) format(fl field) string {
+	switch fl {
+	case fieldCounter:
+		return f.Counter
+	case fieldHistogramCount:
+		return f.HistogramCount
+	case fieldGauge:
+		return f.Gauge
+	case fieldGaugeFloat64:
+		return f.GaugeFloat64
+	case fieldMin:
+		return f.Min
+	case fieldMax:
+		return f.Max
+	case fieldMean:
+		return f.Mean
+	case fieldRateMean:
+		return f.RateMean
+	case fieldMeterRateMean:
+		return f.MeterRateMean
+	case fieldMeterCount:
+		return f.MeterCount
+	case fieldTimerCount:
+		return f.TimerCount
+	case fieldStddev:
+		return f.Stddev
+	case fieldPercentile:
+		return f.Percentile
+	case fieldRate1:
+		return f.Rate1
+	case fieldRate5:
+		return f.Rate5
+	case fieldRate15:
+		return f.Rate15
+	case fieldHealthy:
+		return f.Healthcheck
+	case fieldHealthErrors:
+		return f.HealthErrors
+	case fieldEWMA:
+		return f.EWMA
+	case fieldSum:
+		return f.Sum
+	case fieldTotal:
+		return f.Total
+	case fieldCounterRate:
+		return f.CounterRate
+	case fieldBucket:
+		return f.Bucket
+	case fieldCustom:
+		return f.Custom
+	case fieldVariance:
+		return f.Variance
+	case fieldSampleSize:
+		return f.SampleSize
+	}
+	panic("graphite: unknown field")
 }
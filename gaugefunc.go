@@ -0,0 +1,45 @@
+package graphite
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dt/go-metrics"
+)
+
+// RegisterGaugeFunc exports the value fn returns under name and Prefix,
+// calling fn while every flush takes its snapshot, so that values which are
+// cheap to compute, such as the depth of a queue or the size of a cache,
+// need no goroutine updating a gauge. It replaces the function registered
+// under name before, if any. Like the gauges of registries, the values are
+// subject to filters and SkipUnchanged. fn must not call the methods of e.
+func (e *Exporter) RegisterGaugeFunc(name string, fn func() float64) {
+	e.funcsMu.Lock()
+	defer e.funcsMu.Unlock()
+	if nil == e.funcs {
+		e.funcs = make(map[string]metrics.GaugeFloat64)
+	}
+	e.funcs[name] = metrics.NewFunctionalGaugeFloat64(fn)
+}
+
+// UnregisterGaugeFunc stops exporting the function registered under name.
+func (e *Exporter) UnregisterGaugeFunc(name string) {
+	e.funcsMu.Lock()
+	defer e.funcsMu.Unlock()
+	delete(e.funcs, name)
+}
+
+// snapshotGaugeFuncs appends the values of the functions registered with
+// RegisterGaugeFunc to the snapshot, in the order of their names.
+func (e *Exporter) snapshotGaugeFuncs(prefix string, now time.Time) {
+	e.funcsMu.Lock()
+	defer e.funcsMu.Unlock()
+	names := make([]string, 0, len(e.funcs))
+	for name := range e.funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.snapshotMetric(prefix, name, e.funcs[name], now)
+	}
+}
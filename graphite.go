@@ -1,12 +1,10 @@
 package graphite
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"log"
 	"net"
-	"strconv"
-	"strings"
+	"net/http"
 	"time"
 
 	"github.com/dt/go-metrics"
@@ -14,13 +12,234 @@ import (
 
 // GraphiteConfig provides a container with configuration parameters for
 // the Graphite exporter
+//
+// Prefixes may contain the placeholders %h (short hostname), %f (fully
+// qualified hostname with dots replaced by underscores), %p (process id)
+// and %%, which are expanded once when the exporter starts.
+//
+// SuffixMap renames the last part of exported series. Its keys are the
+// suffixes produced by ExportFormats, such as "count", "std-dev" or
+// "99-percentile", and its values the suffixes to export them as instead.
+//
+// HistogramFields, MeterFields and TimerFields select the series exported
+// for each type of metric by name: "count", "min", "max", "mean", "stddev",
+// "sum", "total", "m1_rate", "m5_rate", "m15_rate", "mean_rate", and
+// percentiles in the style of PercentileP, such as "p95" or "p999". They
+// also accept "rates" for the one, five and fifteen minute rates,
+// "percentiles" for every percentile, and "buckets" for those of Buckets.
+// ExcludeFields names the fields which are not exported for any type of
+// metric, such as "rates" or "stddev".
+//
+// OptionalFields adds series which histograms and timers do not export
+// otherwise: "variance", in DurationUnit squared for timers, and
+// "sample_size", the number of values kept by the sample of a histogram,
+// which timers lack. HistogramFields and TimerFields may also select them,
+// and leave them out when they are set.
+//
+// Buckets are the upper bounds of buckets counting the values of
+// histograms and timers, such as for Grafana heatmaps, exported along with
+// percentiles as series such as "latency.bucket_100", with "bucket_inf"
+// counting the values above every bound. Each bucket counts the values
+// above the previous bound only. As only a sample of the values is kept,
+// the counts are those of the sample of histograms scaled to Count, and for
+// timers, whose snapshots do not expose their sample, or with Quantiles,
+// are estimated from the percentiles, to a hundredth of Count.
+//
+// Destinations replaces carbon-relay in front of several carbon-cache
+// servers: every series is sent over the connection of the server the
+// consistent hashing of carbon-relay routes it to, that of its carbon_ch
+// hash type, which graphite-web also uses to query CARBONLINK_HOSTS. They
+// are listed like the DESTINATIONS of carbon-relay, and replace Address and
+// Connections.
+//
+// ByteQuota limits the size of each flush, such as over metered links. Its
+// metrics are encoded in the order of their Priority, metrics of the same
+// priority in the order they were snapshotted, until one of them exceeds the
+// quota, which is dropped along with the rest of them and counted by
+// QuotaDropped. The bytes sent are reported by Status and SelfMetrics.
+//
+// MaxSeries guards the servers against metrics named after requests or
+// users. Once a flush has snapshotted that many series of its registries,
+// gauge funcs, rollups and runtime, process and expvar metrics, the rest of
+// them are dropped, counted by SeriesDropped and reported by an ErrMaxSeries
+// in the FlushError of the flush. Series dropped by Filter,
+// SkipInvalidValues or SkipUnchanged do not count, and the series of the
+// exporter itself, such as SelfMetrics, are always sent.
+//
+// Priority ranks metrics under these limits, such as with PriorityRules.
+// With Priority, MaxSeries and MaxDatapointsPerSecond drop the series of the
+// lowest priorities of each flush first rather than the last snapshotted,
+// and ByteQuota encodes metrics from the highest priority down. Metrics of
+// PriorityCritical and above are never dropped by any of them.
+//
+// Descriptions label the metrics of the primary Registry for dashboards and
+// alerting tools. The first flush and then one every DescribeInterval send
+// a companion series of each of them, named after the metric with the
+// suffix "meta" and the value 1, tagged with its unit and description, or
+// with TagModeFolded with them folded into its name, such as
+// "app.requests.latency.meta;description=Latency_of_API_requests;unit=ms".
+//
+// Codec separates the wire format of the plaintext protocol from how it is
+// sent, such as PickleCodec for the pickle receivers of carbon, over TLS
+// with a *tls.Dialer as Dialer, or TaggedPlaintextCodec over UDP with the
+// Network "udp". PlaintextCodec folds tags into names for receivers which
+// do not support them. It only applies to ProtocolPlaintext.
+//
+// APIKey authenticates against hosted Graphite services. Over HTTP it is
+// sent as the basic auth user name unless HTTPUsername is set, otherwise it
+// is prepended to every prefix as the first path component.
+//
+// BeforeFlush and AfterFlush are called while the exporter is flushing, so
+// they must not call its methods such as Once or Flush. With AsyncSend,
+// AfterFlush is called by the goroutine sending the flush.
 type GraphiteConfig struct {
-	Addr          *net.TCPAddr     // Network address to connect to
-	Registry      metrics.Registry // Registry to be exported
-	FlushInterval time.Duration    // Flush interval
-	DurationUnit  time.Duration    // Time conversion unit for durations
-	Prefix        string           // Prefix to be prepended to metric names
-	Percentiles   []float64        // Percentiles to export from timers and histograms
+	Addr                   *net.TCPAddr      // Network address to connect to
+	Address                string            // host:port to connect to when Addr is nil, resolved at dial time, or a unix socket path
+	Network                string            // Network to connect over, such as "tcp", "udp" or "unix"; defaults to TCP, or UDP for statsd
+	ResolveTTL             time.Duration     // How long a resolved Address is reused, zero re-resolves every flush
+	Registry               metrics.Registry  // Registry to be exported
+	FlushInterval          time.Duration     // Flush interval
+	DurationUnit           time.Duration     // Time conversion unit for durations
+	RateUnit               time.Duration     // Time unit rates are exported per, zero means per second
+	Prefix                 string            // Prefix to be prepended to metric names, may contain placeholders
+	Percentiles            []float64         // Percentiles to export from timers and histograms, sorted; duplicates and ones outside 0 to 1 exclusive are dropped
+	Registries             []RegistryBinding // Additional registries to be exported
+	SkipInvalidValues      bool              // Omit NaN and infinite values instead of sending them
+	SkipUnchanged          bool              // Omit series whose value has not changed since the previous flush
+	MetricTTL              time.Duration     // Stop exporting metrics whose counts and values have not changed for this long, zero disables
+	SuffixMap              map[string]string // Replacement suffixes for exported series
+	PercentileFormat       string            // Style of percentile keys, one of the Percentile constants
+	HealthcheckErrors      bool              // Export how often each healthcheck has failed
+	Protocol               string            // Wire protocol, one of the Protocol constants
+	Tags                   map[string]string // Tags added to every metric by protocols which support them
+	URL                    string            // Endpoint of HTTP based protocols
+	HTTPClient             *http.Client      // Client for HTTP based protocols, http.DefaultClient if nil
+	APIKey                 string            // Key of hosted services, see below
+	Transport              string            // How metrics are sent, one of the Transport constants
+	HTTPHeaders            http.Header       // Additional headers of HTTP requests
+	HTTPUsername           string            // User name for basic auth of HTTP requests
+	HTTPPassword           string            // Password for basic auth of HTTP requests
+	HTTPBatchSize          int               // Maximum size of HTTP request bodies, zero sends each flush whole
+	Dialer                 Dialer            // Makes connections instead of net.Dial, such as a proxy.Dialer
+	ProxyURL               string            // socks5:// or http:// proxy to connect through when Dialer is nil
+	WriteBufferSize        int               // Maximum size of writes to stream connections, zero writes each flush at once
+	AsyncSend              bool              // Encode and send on a separate goroutine, Once returns after taking a snapshot
+	MaxDatapointsPerSecond int               // Datapoints sent per second on average, excess ones are dropped and counted; zero is unlimited
+	FlushRules             []FlushRule       // Metrics exported less often than every flush, the first matching rule applies
+	RollupInterval         time.Duration     // Interval the sum, min and max of every series are exported over, zero disables
+	RollupOnly             bool              // Export only the rolled up series, not the values of each flush
+	HistogramFields        []string          // Fields exported for histograms, such as "count" or "p99"; empty exports all
+	MeterFields            []string          // Fields exported for meters, such as "count" or "m1_rate"; empty exports all
+	TimerFields            []string          // Fields exported for timers; empty exports all
+	HistogramPercentiles   []float64         // Percentiles to export from histograms instead of Percentiles, if not nil
+	TimerPercentiles       []float64         // Percentiles to export from timers instead of Percentiles, if not nil
+	ResetAfterFlush        bool              // Subtract the counts sent from counters and clear histogram samples after each successful flush
+	Clock                  Clock             // Source of timestamps and flush ticks, the system clock if nil
+	Scheduler              Scheduler         // When Run flushes, such as AlignedSchedule(FlushInterval); every FlushInterval since Run started if nil
+	Descriptions           Descriptions      // Units and descriptions of metrics by name, sent as companion series, see below
+	DescribeInterval       time.Duration     // How often Descriptions are sent, hourly if zero
+	PingDatapoint          bool              // Ping writes the series exporter.ping besides connecting, see Exporter.Ping
+	PingOnStart            bool              // Run pings the destination before flushing, logging the error and returning if it fails
+	OptionalFields         []string          // Fields of histograms and timers exported besides the others, "variance" or "sample_size", see below
+	Timestamp              func() int64      // Timestamp of each flush in seconds since the epoch, the time of its snapshot if nil; not of TimestampedMetrics
+	SortedOutput           bool              // Export the metrics of each registry in the order of their names
+	Sink                   Sink              // Destination of flushes instead of Address or URL, see Sink
+	DialTimeout            time.Duration     // Limit of connecting to the server, zero waits forever
+	WriteTimeout           time.Duration     // Limit of each write to the server, zero waits forever
+	PersistentConnection   bool              // Keep the connection open between flushes, reconnecting after errors
+	KeepAlive              time.Duration     // Period of TCP keepalive probes, zero uses the default of net.Dialer, negative disables them
+	Nagle                  bool              // Coalesce small writes to TCP connections, which are sent immediately by default
+	Expvar                 bool              // Export published expvar Int, Float and Map vars as gauges
+	ExpvarPrefix           string            // Prefix of expvar gauges, may contain placeholders; Prefix if empty
+	RuntimeMetrics         bool              // Export gauges of memory statistics, garbage collections and goroutines of the Go runtime
+	RuntimePrefix          string            // Name runtime gauges start with under Prefix, "runtime" if empty
+	ProcessMetrics         bool              // Export gauges of the uptime, file descriptors, memory and CPU time of the process under Prefix.process
+	PrefixFunc             PrefixFunc        // Prefix of each metric of Registry instead of Prefix, may contain placeholders
+	TagExtractor           TagExtractor      // Splits the metrics of every registry into a name and tags, see TagExtractor
+	TagMode                string            // How extracted tags are exported, one of the TagMode constants
+	ValidateNames          string            // What to do with names Graphite doesn't allow, one of the Validate constants
+	MaxNameLength          int               // Longest name of a metric including its prefix, longer ones are skipped and reported; zero is unlimited
+	TruncateNames          bool              // Truncate names longer than MaxNameLength, ending them with a hash of the full name, instead of skipping them
+	Compression            string            // Compression of the batches sent over streams and HTTP, one of the Compression constants
+	Connections            int               // Parallel connections or requests each flush is sent over, sharded by metric; one if zero
+	EncodeWorkers          int               // Goroutines taking the snapshots and percentiles of histograms and timers in parallel, one if zero
+	CountersAsRate         bool              // Also export the change of each counter per second since the previous flush, as a rate series
+	FloatPrecision         int               // Significant digits of float values instead of the precision of ExportFormats, never in exponent form; negative is the shortest exact form
+	MaxConsecutiveFailures int               // Failed sends in a row after which OnFailure is called and Run returns; zero retries forever
+	OnFailure              func(error)       // Called with the error of the send reaching MaxConsecutiveFailures, if set
+	BreakerFailures        int               // Failed dials in a row after which no connection is attempted for BreakerCooldown; zero disables
+	BreakerCooldown        time.Duration     // How long dialing pauses once BreakerFailures is reached, before probing the server again
+	SelfMetrics            bool              // Export gauges of the exporter itself under Prefix.graphite, such as the flushes Run skipped
+	Heartbeat              bool              // Export Prefix.exporter.heartbeat with the value 1 every flush, so that dead exporters can be alerted on
+	ExcludeFields          []string          // Fields no type of metric exports, named like HistogramFields, see below
+	SRV                    string            // DNS SRV name of the servers to connect to instead of Address, such as "_carbon._tcp.metrics.internal"
+	Destinations           []string          // carbon-cache servers as host:port or host:port:instance, each sent its series like carbon-relay would, see below
+	Metadata               map[string]int64  // Gauges sent under Prefix with the same value every flush, such as a build hash or the start time, outside any registry
+	BeforeFlush            func()            // Called at the start of every flush before its snapshot is taken, such as to update computed gauges
+	AfterFlush             func(FlushStats)  // Called with the outcome of every flush once it was sent or failed
+	OnLine                 LineFunc          // Called with every plaintext series as it is encoded, to see what is sent
+	Mirror                 Sink              // Also receives the batches of every flush before compression, such as a FileSink; its errors are logged
+	Naming                 string            // Naming convention of plaintext series instead of ExportFormats, one of the Naming constants
+	Annotations            *RenderClient     // graphite-web Run posts a "started" event to, tagged "deploy", for deploy markers on graphs
+	AnnotationVersion      string            // Version of the service, added to the tags of the events posted to Annotations
+	AnnotateClose          bool              // Also post a "stopped" event to Annotations when the exporter is closed
+	Namer                  Namer             // Joins the prefix, name and suffix of plaintext series instead of ExportFormats, see Namer
+	PathOrder              string            // Order of the components of plaintext paths, one of the PathOrder constants
+	TypeRoots              bool              // Nest plaintext series under a root for their type of metric like statsd, such as "app.counters.requests.count"; overrides Naming
+	PointInTime            bool              // Freeze every metric of the registries before computing any series, so that a flush sees their values at one instant; holds all samples in memory at once
+	Logger                 Logger            // Logs the errors of Run and of sends which are not returned, the standard logger if nil
+	LogInterval            time.Duration     // How often an error repeating every flush is logged again, with its count; five minutes if zero, negative logs every error
+	Buckets                []float64         // Upper bounds of the buckets of histograms and timers, in DurationUnit for timers, see below
+	Quantiles              QuantileEstimator // Computes the percentiles of histograms and timers instead of their samples, if set
+	StartEpoch             bool              // Export Prefix.exporter.start_epoch with the Unix time the process started every flush, to tell counter resets after restarts apart
+	VectoredWrites         bool              // Write the batches of each flush at once with net.Buffers, a single writev call over TCP and unix sockets; not with Compression
+	Filter                 FilterFunc        // Selects the metrics of every registry exported by name, all of them if nil
+	Endpoints              []Endpoint        // Further destinations sent the same registries every flush, with their own prefix, protocol or filters, see Endpoint
+	NoMetrics              bool              // Export Prefix.exporter.no_metrics with the value 1 when no registry holds a metric matching Filter, reporting ErrNoMetrics in the FlushError
+	StrictTypes            bool              // Fail flushes holding a metric of a type which cannot be exported, sending nothing, even with AsyncSend; see ErrUnknownType
+	FlushBytes             int               // Send the part of a flush encoded so far once it reaches this size, before encoding the rest; zero sends each flush once encoded
+	FlushDatapoints        int               // Send the part of a flush encoded so far once it holds this many datapoints; zero sends each flush once encoded
+	TaggedPrefix           string            // Prefix of the tagged series of Registry with TagModeBoth, may contain placeholders; Prefix if empty
+	FallbackDelay          time.Duration     // How long the addresses of the family a host resolves to first are dialed before racing those of the other, 300ms if zero; negative dials them in turn
+	Tracer                 Tracer            // Traces every flush, such as with OpenTelemetry spans, see Tracer
+	ByteQuota              int               // Size each flush may reach before any compression, the metrics beyond it are dropped and counted, see below; zero is unlimited
+	Priority               PriorityFunc      // Priority of each metric by name, those of lower priorities are dropped first by the limits of flushes, see PriorityRules
+	InvalidValues          string            // What to do with NaN and infinite values, one of the Invalid constants
+	InvalidValuesByType    map[string]string // InvalidValues of each type of metric, such as "gauge", "histogram", "meter", "timer", "ewma" or "custom"
+	MaxSeries              int               // Series each flush exports at most, see below; zero is unlimited
+	Codec                  Codec             // Wire format of the plaintext protocol, such as PickleCodec, see below; nil writes plaintext lines
+}
+
+// A PrefixFunc returns the prefix of the named metric, such as to export
+// business and infrastructure metrics of one registry under different trees.
+type PrefixFunc func(name string) string
+
+// A PriorityFunc returns the priority of the named metric, such as high for
+// SLO metrics and low for debug ones, so that those of lower priorities are
+// dropped first when flushes exceed their limits.
+type PriorityFunc func(name string) int
+
+// A LineFunc observes the name, with its tags, value and timestamp of each
+// plaintext series as it is encoded, such as to log or sample what is sent
+// when names do not match what dashboards expect. Values are given in
+// decimal at full precision, or at FloatPrecision, rather than as formatted
+// by ExportFormats.
+type LineFunc func(name, value string, ts int64)
+
+// RegistryBinding pairs a registry with the prefix its metrics should be
+// exported under, allowing several registries to share one exporter.
+//
+// Children form a tree of registries, such as of the components of a
+// service, each exported under the prefix of its parent followed by its own
+// Prefix, so that the boundaries between them remain visible in the dotted
+// paths rather than flattening into one namespace.
+type RegistryBinding struct {
+	Registry metrics.Registry  // Registry to be exported
+	Prefix   string            // Prefix to be prepended to metric names
+	Children []RegistryBinding // Registries exported under Prefix, with theirs appended to it
+
+	prefixFunc   PrefixFunc // PrefixFunc of the primary Registry
+	taggedPrefix string     // TaggedPrefix of the primary Registry
 }
 
 // Graphite is a blocking exporter function which reports metrics in r
@@ -40,76 +259,167 @@ func Graphite(r metrics.Registry, d time.Duration, prefix string, addr *net.TCPA
 // GraphiteWithConfig is a blocking exporter function just like Graphite,
 // but it takes a GraphiteConfig instead.
 func GraphiteWithConfig(c GraphiteConfig) {
-	for _ = range time.Tick(c.FlushInterval) {
-		if err := graphite(&c); nil != err {
-			log.Println(err)
-		}
-	}
+	NewExporter(c).Run()
 }
 
 // GraphiteOnce performs a single submission to Graphite, returning a
-// non-nil error on failed connections. This can be used in a loop
-// similar to GraphiteWithConfig for custom error handling.
+// non-nil error on failed connections, or a *FlushError if some metrics
+// could not be exported. This can be used in a loop similar to
+// GraphiteWithConfig for custom error handling, telling the kinds of errors
+// apart with errors.Is and ErrDial, ErrWrite, ErrEncode or ErrTimeout.
 func GraphiteOnce(c GraphiteConfig) error {
-	return graphite(&c)
+	return newTransientExporter(c).Once()
+}
+
+// GraphiteOnceContext is like GraphiteOnce, but gives up connecting to and
+// writing to the server when ctx is done.
+func GraphiteOnceContext(ctx context.Context, c GraphiteConfig) error {
+	return newTransientExporter(c).OnceContext(ctx)
 }
 
-func graphite(c *GraphiteConfig) error {
-	now := time.Now().Unix()
-	du := float64(c.DurationUnit)
-	conn, err := net.DialTCP("tcp", nil, c.Addr)
-	if nil != err {
-		return err
+// newTransientExporter returns an Exporter for a single flush, which returns
+// its buffers to the pools once sent.
+func newTransientExporter(c GraphiteConfig) *Exporter {
+	e := NewExporter(c)
+	e.transient = true
+	return e
+}
+
+// Validate returns a descriptive error for the first setting of c which
+// cannot work, such as a missing Registry, a FlushInterval or DurationUnit
+// which is not positive, a negative timeout or interval, no address to send
+// to, a percentile outside of 0 to 1 exclusive or listed twice, or a value
+// which is none of the constants of its field, also with the settings of
+// each of its Endpoints. Exporters do not call it, so that configurations
+// which worked keep exporting what they can; services may call it at
+// startup to fail early instead.
+func (c GraphiteConfig) Validate() error {
+	if nil == c.Registry && 0 == len(c.Registries) {
+		return configError("Registry", "is nil and there are no Registries")
 	}
-	defer conn.Close()
-	w := bufio.NewWriter(conn)
-	c.Registry.Each(func(name string, i interface{}) {
-		switch metric := i.(type) {
-		case metrics.Counter:
-			fmt.Fprintf(w, ExportFormats.Counter, c.Prefix, name, metric.Count(), now)
-		case metrics.Gauge:
-			fmt.Fprintf(w, ExportFormats.Gauge, c.Prefix, name, metric.Value(), now)
-		case metrics.GaugeFloat64:
-			fmt.Fprintf(w, ExportFormats.GaugeFloat64, c.Prefix, name, metric.Value(), now)
-		case metrics.Histogram:
-			h := metric.Snapshot()
-			ps := h.Percentiles(c.Percentiles)
-			fmt.Fprintf(w, ExportFormats.HistogramCount, c.Prefix, name, h.Count(), now)
-			fmt.Fprintf(w, ExportFormats.Min, c.Prefix, name, h.Min(), now)
-			fmt.Fprintf(w, ExportFormats.Max, c.Prefix, name, h.Max(), now)
-			fmt.Fprintf(w, ExportFormats.Mean, c.Prefix, name, h.Mean(), now)
-			fmt.Fprintf(w, ExportFormats.Stddev, c.Prefix, name, h.StdDev(), now)
-			for psIdx, psKey := range c.Percentiles {
-				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
-				fmt.Fprintf(w, ExportFormats.Percentile, c.Prefix, name, key, ps[psIdx], now)
+	for _, d := range []struct {
+		name     string
+		d        time.Duration
+		positive bool
+	}{
+		{"FlushInterval", c.FlushInterval, true},
+		{"DurationUnit", c.DurationUnit, true},
+		{"RateUnit", c.RateUnit, false},
+		{"ResolveTTL", c.ResolveTTL, false},
+		{"MetricTTL", c.MetricTTL, false},
+		{"RollupInterval", c.RollupInterval, false},
+		{"DialTimeout", c.DialTimeout, false},
+		{"WriteTimeout", c.WriteTimeout, false},
+		{"BreakerCooldown", c.BreakerCooldown, false},
+		{"DescribeInterval", c.DescribeInterval, false},
+	} {
+		if 0 > d.d || d.positive && 0 == d.d {
+			if d.positive {
+				return configError(d.name, fmt.Sprintf("is %v, it must be positive", d.d))
 			}
-		case metrics.Meter:
-			m := metric.Snapshot()
-			fmt.Fprintf(w, ExportFormats.HistogramCount, c.Prefix, name, m.Count(), now)
-			fmt.Fprintf(w, ExportFormats.Rate1, c.Prefix, name, m.Rate1(), now)
-			fmt.Fprintf(w, ExportFormats.Rate5, c.Prefix, name, m.Rate5(), now)
-			fmt.Fprintf(w, ExportFormats.Rate15, c.Prefix, name, m.Rate15(), now)
-			fmt.Fprintf(w, ExportFormats.Mean, c.Prefix, name, m.RateMean(), now)
-		case metrics.Timer:
-			t := metric.Snapshot()
-			ps := t.Percentiles(c.Percentiles)
-			fmt.Fprintf(w, ExportFormats.HistogramCount, c.Prefix, name, t.Count(), now)
-			fmt.Fprintf(w, ExportFormats.Min, c.Prefix, name, t.Min()/int64(du), now)
-			fmt.Fprintf(w, ExportFormats.Max, c.Prefix, name, t.Max()/int64(du), now)
-			fmt.Fprintf(w, ExportFormats.Mean, c.Prefix, name, t.Mean()/du, now)
-			fmt.Fprintf(w, ExportFormats.Stddev, c.Prefix, name, t.StdDev()/du, now)
-			for psIdx, psKey := range c.Percentiles {
-				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
-				fmt.Fprintf(w, ExportFormats.Percentile, c.Prefix, name, key, ps[psIdx]/du, now)
+			return configError(d.name, fmt.Sprintf("is %v, it must not be negative", d.d))
+		}
+	}
+	switch {
+	case nil != c.Sink || TransportStdout == c.Transport || TransportDiscard == c.Transport:
+	case c.overHTTP():
+		if "" == c.URL {
+			return configError("URL", "is empty, metrics cannot be POSTed anywhere")
+		}
+	case nil == c.Addr && "" == c.Address && "" == c.SRV && 0 == len(c.Destinations):
+		return configError("Address", "is empty, and neither Addr, SRV, Destinations nor Sink is set")
+	}
+	for _, ps := range []struct {
+		name string
+		ps   []float64
+	}{
+		{"Percentiles", c.Percentiles},
+		{"HistogramPercentiles", c.HistogramPercentiles},
+		{"TimerPercentiles", c.TimerPercentiles},
+	} {
+		seen := make(map[float64]bool, len(ps.ps))
+		for _, p := range ps.ps {
+			if !validPercentile(p) {
+				return configError(ps.name, fmt.Sprintf("holds %v, percentiles must be between 0 and 1 exclusive", p))
+			}
+			if seen[p] {
+				return configError(ps.name, fmt.Sprintf("holds %v more than once", p))
 			}
-			fmt.Fprintf(w, ExportFormats.Rate1, c.Prefix, name, t.Rate1(), now)
-			fmt.Fprintf(w, ExportFormats.Rate5, c.Prefix, name, t.Rate5(), now)
-			fmt.Fprintf(w, ExportFormats.Rate15, c.Prefix, name, t.Rate15(), now)
-			fmt.Fprintf(w, ExportFormats.Mean, c.Prefix, name, t.RateMean(), now)
-		default:
-			log.Printf("Cannot export unknown metric type %T for '%s'\n", i, name)
+			seen[p] = true
 		}
-		w.Flush()
-	})
+	}
+	for _, v := range []struct {
+		name, value string
+		values      []string
+	}{
+		{"Protocol", c.Protocol, []string{ProtocolPlaintext, ProtocolInflux, ProtocolOpenTSDB, ProtocolStatsD, ProtocolRemoteWrite, ProtocolJSON}},
+		{"Transport", c.Transport, []string{TransportDefault, TransportHTTP, TransportStdout, TransportDiscard}},
+		{"PercentileFormat", c.PercentileFormat, []string{PercentileDefault, PercentileP, PercentilePDecimal, PercentileUpper}},
+		{"TagMode", c.TagMode, []string{TagModeTagged, TagModeFolded, TagModeBoth}},
+		{"Compression", c.Compression, []string{CompressionNone, CompressionGzip}},
+		{"ValidateNames", c.ValidateNames, []string{ValidateNone, ValidateReject, ValidateSkip, ValidateSanitize}},
+		{"Naming", c.Naming, []string{NamingDefault, NamingCodahale, NamingStatsD, NamingDropwizard}},
+		{"PathOrder", c.PathOrder, []string{PathPrefixFirst, PathReversedDomain}},
+		{"InvalidValues", c.InvalidValues, invalidPolicies},
+	} {
+		known := false
+		for _, value := range v.values {
+			known = known || value == v.value
+		}
+		if !known {
+			return configError(v.name, fmt.Sprintf("%q is not one of %q", v.value, v.values))
+		}
+	}
+	if nil != c.Codec && ProtocolPlaintext != c.Protocol {
+		return configError("Codec", fmt.Sprintf("is set, but only applies to the plaintext protocol, not %q", c.Protocol))
+	}
+	for name, p := range c.InvalidValuesByType {
+		known := false
+		for _, value := range invalidPolicies {
+			known = known || value == p
+		}
+		if !known {
+			return configError("InvalidValuesByType", fmt.Sprintf("%q of %q is not one of %q", p, name, invalidPolicies))
+		}
+	}
+	for i := range c.Endpoints {
+		if err := c.Endpoints[i].config(c).Validate(); nil != err {
+			return fmt.Errorf("%w of Endpoints[%d]", err, i)
+		}
+	}
 	return nil
 }
+
+func configError(field, msg string) error {
+	return fmt.Errorf("graphite: %s %s", field, msg)
+}
+
+// flattenBindings appends the registries of the trees bs to flat, in depth
+// first order, with their prefixes expanded and appended to parent. Nodes
+// without a Registry only contribute their prefix.
+func (c *GraphiteConfig) flattenBindings(flat []RegistryBinding, parent string, bs []RegistryBinding) []RegistryBinding {
+	for _, b := range bs {
+		prefix := c.expandPrefix(b.Prefix)
+		switch {
+		case "" == prefix:
+			prefix = parent
+		case "" != parent:
+			prefix = parent + "." + prefix
+		}
+		if nil != b.Registry {
+			flat = append(flat, RegistryBinding{Registry: b.Registry, Prefix: prefix})
+		}
+		flat = c.flattenBindings(flat, prefix, b.Children)
+	}
+	return flat
+}
+
+// bindings returns the registries to be exported along with their prefixes,
+// starting with the primary Registry if one is set.
+func (c *GraphiteConfig) bindings() []RegistryBinding {
+	bs := make([]RegistryBinding, 0, len(c.Registries)+1)
+	if nil != c.Registry {
+		bs = append(bs, RegistryBinding{Registry: c.Registry, Prefix: c.Prefix, prefixFunc: c.PrefixFunc, taggedPrefix: c.TaggedPrefix})
+	}
+	return append(bs, c.Registries...)
+}
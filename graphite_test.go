@@ -2,13 +2,19 @@ package graphite
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
 	"net"
-	"strconv"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/dt/go-metrics-graphite/plaintext"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -45,18 +51,22 @@ func NewTestServer(t *testing.T, prefix string) (map[string]float64, net.Listene
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
-				t.Fatal("dummy server error:", err)
+				return
 			}
-			r := bufio.NewReader(conn)
-			line, err := r.ReadString('\n')
-			for err == nil {
-				parts := strings.Split(line, " ")
-				i, _ := strconv.ParseFloat(parts[1], 0)
+			r := plaintext.NewReader(conn)
+			for {
+				p, err := r.Read()
+				var se *plaintext.SyntaxError
+				if errors.As(err, &se) {
+					continue
+				}
+				if err != nil {
+					break
+				}
 				if testing.Verbose() {
-					t.Log("recv", parts[0], i)
+					t.Log("recv", p.Series(), p.Value)
 				}
-				res[parts[0]] = res[parts[0]] + i
-				line, err = r.ReadString('\n')
+				res[p.Series()] = res[p.Series()] + p.Value
 			}
 			wg.Done()
 			conn.Close()
@@ -123,6 +133,7 @@ func TestWrites(t *testing.T) {
 		t.Fatal("bad value:", expected, found)
 	}
 
+	defer func(f ExportFormatStrings) { ExportFormats = f }(ExportFormats)
 	ExportFormats = OstrichFormats
 
 	for k, _ := range res {
@@ -148,3 +159,996 @@ func TestWrites(t *testing.T) {
 		t.Fatal("bad value:", expected, found)
 	}
 }
+
+func TestRegistries(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "app")
+	defer l.Close()
+
+	lib := metrics.NewRegistry()
+	c.Registries = []RegistryBinding{{Registry: lib, Prefix: "lib"}}
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterCounter("foo", lib).Inc(3)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 2.0, res["app.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	if expected, found := 3.0, res["lib.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestNestedRegistries(t *testing.T) {
+	res, l, _, c, wg := NewTestServer(t, "app")
+	defer l.Close()
+
+	db, pool, cache := metrics.NewRegistry(), metrics.NewRegistry(), metrics.NewRegistry()
+	c.Registries = []RegistryBinding{{
+		Prefix: "svc",
+		Children: []RegistryBinding{
+			{Registry: db, Prefix: "db", Children: []RegistryBinding{{Registry: pool, Prefix: "pool"}}},
+			{Registry: cache, Prefix: "cache"},
+		},
+	}}
+
+	metrics.GetOrRegisterCounter("queries", db).Inc(2)
+	metrics.GetOrRegisterGauge("size", pool).Update(3)
+	metrics.GetOrRegisterCounter("hits", cache).Inc(4)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for name, expected := range map[string]float64{
+		"svc.db.queries.count":   2,
+		"svc.db.pool.size.value": 3,
+		"svc.cache.hits.count":   4,
+	} {
+		if found := res[name]; !floatEquals(found, expected) {
+			t.Fatal("bad value:", name, expected, found)
+		}
+	}
+}
+
+func TestPrefixFunc(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "app")
+	defer l.Close()
+
+	c.APIKey = "key"
+	c.PrefixFunc = func(name string) string {
+		if strings.HasPrefix(name, "orders.") {
+			return "business"
+		}
+		return "infra.%%"
+	}
+
+	metrics.GetOrRegisterCounter("orders.placed", r).Inc(2)
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 2.0, res["key.business.orders.placed.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if expected, found := 3.0, res["key.infra.%.requests.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.Address = c.Addr.String()
+	c.Addr = nil
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	c.Address = "invalid host:port"
+	if err := GraphiteOnce(c); nil == err {
+		t.Fatal("expected resolution error")
+	}
+}
+
+func TestSkipInvalidValues(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	metrics.GetOrRegisterGaugeFloat64("nan", r).Update(math.NaN())
+	metrics.GetOrRegisterGaugeFloat64("inf", r).Update(math.Inf(1))
+	metrics.GetOrRegisterGaugeFloat64("ok", r).Update(1.5)
+
+	c.SkipInvalidValues = true
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if _, found := res["foobar.nan.value"]; found {
+		t.Fatal("NaN value exported")
+	}
+
+	if _, found := res["foobar.inf.value"]; found {
+		t.Fatal("infinite value exported")
+	}
+
+	if expected, found := 1.5, res["foobar.ok.value"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+// A nanEWMA is an EWMA whose rate is always NaN.
+type nanEWMA struct{}
+
+func (nanEWMA) Rate() float64 { return math.NaN() }
+func (nanEWMA) Tick()         {}
+func (nanEWMA) Update(int64)  {}
+
+func TestInvalidValues(t *testing.T) {
+	r := extraRegistry{metrics.NewRegistry(), "ewma", nanEWMA{}}
+	gauge := metrics.GetOrRegisterGaugeFloat64("gauge", r)
+	var b bytes.Buffer
+	e := NewExporter(GraphiteConfig{
+		Registry:            r,
+		Sink:                WriterSink(&b),
+		Prefix:              "app",
+		Timestamp:           func() int64 { return 1 },
+		InvalidValues:       InvalidLast,
+		InvalidValuesByType: map[string]string{"ewma": InvalidZero},
+	})
+	defer e.Close()
+
+	gauge.Update(math.NaN())
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected := "app.ewma.rate 0.00 1\n"; b.String() != expected {
+		t.Fatalf("without a last value, expected %q, found %q", expected, b.String())
+	}
+
+	b.Reset()
+	gauge.Update(2.5)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	gauge.Update(math.Inf(1))
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	if expected := "app.gauge.value 2.500000 1\napp.ewma.rate 0.00 1\n"; strings.Repeat(expected, 2) != b.String() {
+		t.Fatalf("expected the last value twice, found %q", b.String())
+	}
+
+	if err := (&GraphiteConfig{Registry: r, Sink: WriterSink(&b), InvalidValues: "drop"}).Validate(); nil == err {
+		t.Fatal("unknown policy accepted")
+	}
+}
+
+func TestSuffixMap(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.SuffixMap = map[string]string{"count": "total", "99-percentile": "p99"}
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterHistogram("bar", r, metrics.NewUniformSample(10)).Update(3)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.total"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	if expected, found := 3.0, res["foobar.bar.p99"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	if expected, found := 3.0, res["foobar.bar.max"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	if _, found := res["foobar.foo.count"]; found {
+		t.Fatal("renamed series exported")
+	}
+}
+
+func TestPercentileFormat(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	metrics.GetOrRegisterHistogram("bar", r, metrics.NewUniformSample(10)).Update(3)
+
+	for style, keys := range map[string][]string{
+		PercentileDefault:  {"50-percentile", "999-percentile"},
+		PercentileP:        {"p50", "p999"},
+		PercentilePDecimal: {"p50", "p99_9"},
+		PercentileUpper:    {"upper_50", "upper_99_9"},
+	} {
+		for k := range res {
+			delete(res, k)
+		}
+		c.PercentileFormat = style
+		wg.Add(1)
+		GraphiteOnce(c)
+		wg.Wait()
+
+		for _, key := range keys {
+			if expected, found := 3.0, res["foobar.bar."+key]; !floatEquals(found, expected) {
+				t.Fatal("bad value:", style, key, expected, found)
+			}
+		}
+	}
+}
+
+func TestRateUnit(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	metrics.GetOrRegisterMeter("bar", r).Mark(100)
+	time.Sleep(200 * time.Millisecond)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+	perSecond := res["foobar.bar.mean"]
+
+	for k := range res {
+		delete(res, k)
+	}
+	c.RateUnit = time.Minute
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+	perMinute := res["foobar.bar.mean"]
+
+	if ratio := perMinute / perSecond; ratio < 57 || ratio > 61 {
+		t.Fatal("bad rate ratio:", perSecond, perMinute)
+	}
+}
+
+func TestHealthchecks(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.HealthcheckErrors = true
+	e := NewExporter(c)
+
+	r.Register("up", metrics.NewHealthcheck(func(h metrics.Healthcheck) { h.Healthy() }))
+	r.Register("down", metrics.NewHealthcheck(func(h metrics.Healthcheck) { h.Unhealthy(errors.New("down")) }))
+
+	wg.Add(2)
+	e.Once()
+	e.Once()
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.up.healthy"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	if expected, found := 0.0, res["foobar.down.healthy"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	// Summed over both flushes, which reported 1 and 2 failures.
+	if expected, found := 3.0, res["foobar.down.errors"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestEWMA(t *testing.T) {
+	a := metrics.NewEWMA1()
+	a.Update(60)
+	a.Tick()
+
+	dps := NewExporter(GraphiteConfig{}).appendDatapoints(nil, "foobar", "ewma", a)
+	if 1 != len(dps) || fieldEWMA != dps[0].field {
+		t.Fatal("bad datapoints:", dps)
+	}
+
+	if expected, found := 12.0, dps[0].fvalue; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.APIKey = "secret"
+	c.Registries = []RegistryBinding{{Registry: r}}
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 2.0, res["secret.foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	if expected, found := 2.0, res["secret.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "carbon.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	err = GraphiteOnce(GraphiteConfig{Network: "unix", Address: path, Registry: r, Prefix: "foobar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line := <-lines; !strings.HasPrefix(line, "foobar.foo.count 2 ") {
+		t.Fatal("bad line:", line)
+	}
+}
+
+func TestFields(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.TimerFields = []string{"count", "p99", "m1_rate"}
+	metrics.GetOrRegisterTimer("foo", r).Update(time.Second)
+	metrics.GetOrRegisterHistogram("bar", r, metrics.NewUniformSample(10)).Update(1)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for _, name := range []string{"foobar.foo.count", "foobar.foo.99-percentile", "foobar.foo.one-minute", "foobar.bar.min"} {
+		if _, found := res[name]; !found {
+			t.Fatal("selected series not exported:", name)
+		}
+	}
+	for _, name := range []string{"foobar.foo.min", "foobar.foo.50-percentile", "foobar.foo.five-minute"} {
+		if _, found := res[name]; found {
+			t.Fatal("unselected series exported:", name)
+		}
+	}
+}
+
+func TestExcludeFields(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.ExcludeFields = []string{"rates", "stddev"}
+	metrics.GetOrRegisterTimer("foo", r).Update(time.Second)
+	metrics.GetOrRegisterMeter("bar", r).Mark(1)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for _, name := range []string{"foobar.foo.count", "foobar.foo.99-percentile", "foobar.foo.mean-rate", "foobar.bar.count"} {
+		if _, found := res[name]; !found {
+			t.Fatal("series not exported:", name)
+		}
+	}
+	for _, name := range []string{"foobar.foo.std-dev", "foobar.foo.one-minute", "foobar.bar.five-minute", "foobar.bar.fifteen-minute"} {
+		if _, found := res[name]; found {
+			t.Fatal("excluded series exported:", name)
+		}
+	}
+}
+
+func TestPerTypePercentiles(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.TimerPercentiles = []float64{0.999}
+	c.HistogramPercentiles = []float64{0.5, 0.95}
+	metrics.GetOrRegisterTimer("foo", r).Update(time.Second)
+	metrics.GetOrRegisterHistogram("bar", r, metrics.NewUniformSample(10)).Update(1)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for _, name := range []string{"foobar.foo.999-percentile", "foobar.bar.50-percentile", "foobar.bar.95-percentile"} {
+		if _, found := res[name]; !found {
+			t.Fatal("percentile not exported:", name)
+		}
+	}
+	for _, name := range []string{"foobar.foo.50-percentile", "foobar.bar.999-percentile", "foobar.bar.99-percentile"} {
+		if _, found := res[name]; found {
+			t.Fatal("percentile of other type exported:", name)
+		}
+	}
+}
+
+func TestCleanPercentiles(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Millisecond)
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:     r,
+		Sink:         WriterSink(&b),
+		DurationUnit: time.Millisecond,
+		Percentiles:  []float64{0.99, 0.5, 99, 0.99, 0, 1, math.NaN()},
+		TimerFields:  []string{"percentiles"},
+		Timestamp:    func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected := ".latency.50-percentile 2.00 1\n.latency.99-percentile 2.00 1\n"; expected != b.String() {
+		t.Fatalf("expected %q, found %q", expected, b.String())
+	}
+	if expected := []float64{0.5, 0.99}; !reflect.DeepEqual(expected, cleanPercentiles([]float64{0.99, 0.5, 0.99})) {
+		t.Fatal("expected", expected)
+	}
+	if nil != cleanPercentiles(nil) || nil == cleanPercentiles([]float64{}) {
+		t.Fatal("expected nil to be kept apart from empty percentiles")
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := metrics.GetOrRegisterHistogram("size", r, metrics.NewUniformSample(100))
+	for i := int64(1); i <= 100; i++ {
+		h.Update(i)
+	}
+	timer := metrics.GetOrRegisterTimer("latency", r)
+	timer.Update(100 * time.Millisecond)
+	timer.Update(300 * time.Millisecond)
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:        r,
+		Sink:            WriterSink(&b),
+		DurationUnit:    time.Millisecond,
+		Buckets:         []float64{50, 10, 200, 10},
+		HistogramFields: []string{"buckets"},
+		TimerFields:     []string{"buckets"},
+		SortedOutput:    true,
+		Timestamp:       func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	expected := ".latency.bucket_10 0 1\n.latency.bucket_50 0 1\n.latency.bucket_200 1 1\n.latency.bucket_inf 1 1\n" +
+		".size.bucket_10 10 1\n.size.bucket_50 40 1\n.size.bucket_200 50 1\n.size.bucket_inf 0 1\n"
+	if expected != b.String() {
+		t.Fatalf("expected %q, found %q", expected, b.String())
+	}
+}
+
+func TestBucketCountsOfSample(t *testing.T) {
+	if expected, found := []int64{10, 10, 10}, bucketCounts([]float64{1, 3, 5}, 30, []float64{2, 4}, 1); !reflect.DeepEqual(expected, found) {
+		t.Fatalf("expected %v, found %v", expected, found)
+	}
+	if expected, found := []int64{0, 7}, bucketCounts(nil, 7, []float64{2}, 1); !reflect.DeepEqual(expected, found) {
+		t.Fatalf("expected %v, found %v", expected, found)
+	}
+}
+
+func TestQuantileEstimator(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterTimer("latency", r).Update(time.Millisecond)
+	metrics.GetOrRegisterHistogram("size", r, metrics.NewUniformSample(10)).Update(5)
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:        r,
+		Prefix:          "app",
+		Sink:            WriterSink(&b),
+		DurationUnit:    time.Millisecond,
+		Percentiles:     []float64{0.5, 0.99},
+		HistogramFields: []string{"percentiles"},
+		TimerFields:     []string{"percentiles"},
+		SortedOutput:    true,
+		Quantiles: QuantileFunc(func(prefix, name string, metric interface{}, qs []float64) ([]float64, bool) {
+			if _, ok := metric.(metrics.Timer); !ok || "app" != prefix || "latency" != name {
+				return nil, false
+			}
+			ps := make([]float64, len(qs))
+			for i, q := range qs {
+				ps[i] = q * float64(10*time.Millisecond)
+			}
+			return ps, true
+		}),
+		Timestamp: func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	expected := "app.latency.50-percentile 5.00 1\napp.latency.99-percentile 9.90 1\n" +
+		"app.size.50-percentile 5.00 1\napp.size.99-percentile 5.00 1\n"
+	if expected != b.String() {
+		t.Fatalf("expected %q, found %q", expected, b.String())
+	}
+}
+
+func TestHistogramSum(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	h := metrics.GetOrRegisterHistogram("bar", r, metrics.NewUniformSample(10))
+	h.Update(3)
+	h.Update(4)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 7.0, res["foobar.bar.sum"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestTimerTotal(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	timer := metrics.GetOrRegisterTimer("baz", r)
+	timer.Update(2 * time.Second)
+	timer.Update(time.Second)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 3000.0, res["foobar.baz.total"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestNaming(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	metrics.GetOrRegisterGauge("queue", r).Update(7)
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Millisecond)
+
+	for naming, expected := range map[string][]string{
+		NamingStatsD:     {"app.counters.requests.count 3 1", "app.gauges.queue 7 1", "app.timers.latency.upper 2 1", "app.timers.latency.upper_99 2.00 1"},
+		NamingDropwizard: {"app.requests.count 3 1", "app.queue 7 1", "app.latency.max 2 1", "app.latency.p99 2.00 1", "app.latency.m1_rate "},
+		NamingCodahale:   {"app.requests.count 3 1", "app.queue.value 7 1", "app.latency.max 2 1", "app.latency.99-percentile 2.00 1"},
+	} {
+		var b strings.Builder
+		err := GraphiteOnce(GraphiteConfig{
+			Registry:     r,
+			Prefix:       "app",
+			Sink:         WriterSink(&b),
+			DurationUnit: time.Millisecond,
+			Percentiles:  []float64{0.99},
+			Naming:       naming,
+			Timestamp:    func() int64 { return 1 },
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, line := range expected {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("expected %q with %s naming, found %q", line, naming, b.String())
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() GraphiteConfig {
+		return GraphiteConfig{
+			Registry:      metrics.NewRegistry(),
+			Address:       "graphite:2003",
+			FlushInterval: time.Second,
+			DurationUnit:  time.Millisecond,
+			Percentiles:   []float64{0.5, 0.99},
+		}
+	}
+	if err := valid().Validate(); nil != err {
+		t.Fatal(err)
+	}
+	for field, invalidate := range map[string]func(*GraphiteConfig){
+		"Registry":             func(c *GraphiteConfig) { c.Registry = nil },
+		"FlushInterval":        func(c *GraphiteConfig) { c.FlushInterval = 0 },
+		"DurationUnit":         func(c *GraphiteConfig) { c.DurationUnit = -time.Second },
+		"WriteTimeout":         func(c *GraphiteConfig) { c.WriteTimeout = -time.Second },
+		"Address":              func(c *GraphiteConfig) { c.Address = "" },
+		"URL":                  func(c *GraphiteConfig) { c.Transport = TransportHTTP },
+		"Percentiles":          func(c *GraphiteConfig) { c.Percentiles = []float64{99} },
+		"HistogramPercentiles": func(c *GraphiteConfig) { c.HistogramPercentiles = []float64{0.5, 0.5} },
+		"TimerPercentiles":     func(c *GraphiteConfig) { c.TimerPercentiles = []float64{math.NaN()} },
+		"Protocol":             func(c *GraphiteConfig) { c.Protocol = "carbon" },
+		"Naming":               func(c *GraphiteConfig) { c.Naming = "java" },
+	} {
+		c := valid()
+		invalidate(&c)
+		if err := c.Validate(); nil == err || !strings.Contains(err.Error(), field+" ") {
+			t.Errorf("expected an error naming %s, found %v", field, err)
+		}
+	}
+	c := valid()
+	c.Address, c.Sink = "", WriterSink(io.Discard)
+	if err := c.Validate(); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestNamer(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Millisecond)
+
+	for _, namer := range []Namer{
+		DefaultNamer,
+		SeparatorNamer("_"),
+		NamerFunc(func(prefix, name, suffix string) string { return "prod." + name + "." + suffix + "." + prefix }),
+	} {
+		var b strings.Builder
+		err := GraphiteOnce(GraphiteConfig{
+			Registry:     r,
+			Prefix:       "app",
+			Sink:         WriterSink(&b),
+			DurationUnit: time.Millisecond,
+			Percentiles:  []float64{0.99},
+			SuffixMap:    map[string]string{"count": "n"},
+			Naming:       NamingStatsD,
+			Namer:        namer,
+			Timestamp:    func() int64 { return 1 },
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, line := range [][3]string{{"requests", "n", " 3 1\n"}, {"latency", "99-percentile", " 2.00 1\n"}} {
+			if line := namer.Join("app", line[0], line[1]) + line[2]; !strings.Contains(b.String(), line) {
+				t.Errorf("expected %q, found %q", line, b.String())
+			}
+		}
+	}
+}
+
+func TestPathOrder(t *testing.T) {
+	n := ReversedDomainNamer("host01.example.com.", nil)
+	if expected, found := "com.example.host01.app.requests.count", n.Join("app", "requests", "count"); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Sink:      WriterSink(&b),
+		PathOrder: PathReversedDomain,
+		Timestamp: func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected := ReversedDomainNamer(fqdn(), nil).Join("app", "requests", "count") + " 3 1\n"; expected != b.String() {
+		t.Fatalf("expected %q, found %q", expected, b.String())
+	}
+}
+
+func TestTypeRoots(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	metrics.GetOrRegisterGauge("queue", r).Update(7)
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Millisecond)
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		DurationUnit: time.Millisecond,
+		Percentiles:  []float64{0.99},
+		Heartbeat:    true,
+		TypeRoots:    true,
+		Timestamp:    func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"app.counters.requests.count 3 1", "app.gauges.queue.value 7 1", "app.timers.latency.99-percentile 2.00 1", "app.exporter.heartbeat 1"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected %q, found %q", line, b.String())
+		}
+	}
+}
+
+func TestDistinctFormats(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	defer func(f ExportFormatStrings) { ExportFormats = f }(ExportFormats)
+	ExportFormats.TimerCount = "%s.%s.calls %d %d\n"
+
+	metrics.GetOrRegisterTimer("baz", r).Update(2 * time.Second)
+	metrics.GetOrRegisterMeter("bar", r).Mark(3)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 2000.0, res["foobar.baz.mean"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if _, found := res["foobar.baz.mean-rate"]; !found {
+		t.Fatal("mean rate not exported")
+	}
+	if expected, found := 1.0, res["foobar.baz.calls"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if expected, found := 3.0, res["foobar.bar.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestTimerMeanRate(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Millisecond)
+	metrics.GetOrRegisterMeter("requests", r).Mark(1)
+	// Meters keep the names of their mean rates, which had no clash.
+	for naming, suffixes := range map[string][3]string{
+		NamingDefault:    {".latency.mean ", ".latency.mean-rate ", ".requests.mean "},
+		NamingStatsD:     {".timers.latency.mean ", ".timers.latency.count_ps ", ".timers.requests.count_ps "},
+		NamingDropwizard: {".latency.mean ", ".latency.mean_rate ", ".requests.mean_rate "},
+	} {
+		var b strings.Builder
+		err := GraphiteOnce(GraphiteConfig{
+			Registry:     r,
+			Prefix:       "app",
+			Sink:         WriterSink(&b),
+			DurationUnit: time.Millisecond,
+			Naming:       naming,
+			Timestamp:    func() int64 { return 1 },
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, suffix := range suffixes {
+			if 1 != strings.Count(b.String(), suffix) {
+				t.Errorf("expected one %q series with %q naming in %q", suffix, naming, b.String())
+			}
+		}
+	}
+}
+
+func TestFlushErrors(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	defer func(f ExportFormatStrings) { ExportFormats = f }(ExportFormats)
+	ExportFormats.GaugeFloat64 = "%s.%s.value %d %d\n"
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterGaugeFloat64("bar", r).Update(1.5)
+
+	wg.Add(1)
+	err := GraphiteOnce(c)
+	wg.Wait()
+
+	var ferr *FlushError
+	var merr *MetricError
+	if !errors.As(err, &ferr) || 1 != len(ferr.Errors) {
+		t.Fatal("expected a flush error:", err)
+	}
+	if !errors.As(err, &merr) || "foobar.bar" != merr.Name {
+		t.Fatal("expected the badly formatted metric:", err)
+	}
+	if !errors.Is(err, ErrEncode) || errors.Is(err, ErrDial) || errors.Is(err, ErrWrite) {
+		t.Fatal("expected an ErrEncode:", err)
+	}
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+
+	e := NewExporter(GraphiteConfig{})
+	e.appendDatapoints(nil, "foobar", "baz", struct{}{})
+	if 1 != len(e.snapshot.errs) || !errors.As(e.snapshot.errs[0], &merr) || "foobar.baz" != merr.Name {
+		t.Fatal("expected the unknown metric:", e.snapshot.errs)
+	}
+}
+
+// An extraRegistry holds a metric of any type besides those of its Registry,
+// which drops types it does not know.
+type extraRegistry struct {
+	metrics.Registry
+	name   string
+	metric interface{}
+}
+
+func (r extraRegistry) Each(f func(string, interface{})) {
+	r.Registry.Each(f)
+	f(r.name, r.metric)
+}
+
+func TestStrictTypes(t *testing.T) {
+	r := extraRegistry{metrics.NewRegistry(), "bar", struct{}{}}
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	var b strings.Builder
+	c := GraphiteConfig{
+		Registry:  r,
+		Prefix:    "app",
+		Sink:      WriterSink(&b),
+		Timestamp: func() int64 { return 1 },
+		AsyncSend: true,
+	}
+	e := NewExporter(c)
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	e.Close()
+
+	c.StrictTypes = true
+	b.Reset()
+	e = NewExporter(c)
+	err := e.Once()
+	e.Close()
+	var merr *MetricError
+	if !errors.Is(err, ErrUnknownType) || !errors.As(err, &merr) || "app.bar" != merr.Name {
+		t.Fatal("expected the unknown metric:", err)
+	}
+	if !strings.Contains(err.Error(), "struct {}") {
+		t.Fatal("expected the type of the metric:", err)
+	}
+	if "" != b.String() {
+		t.Fatalf("expected nothing sent, found %q", b.String())
+	}
+}
+
+// An errWriter fails every write.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestErrorKinds(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	for _, tc := range []struct {
+		c    GraphiteConfig
+		kind error
+	}{
+		{GraphiteConfig{Registry: r, Addr: ln.Addr().(*net.TCPAddr)}, ErrDial},
+		{GraphiteConfig{Registry: r, Sink: failingSink{}}, ErrDial},
+		{GraphiteConfig{Registry: r, Sink: WriterSink(errWriter{})}, ErrWrite},
+	} {
+		err := GraphiteOnce(tc.c)
+		if !errors.Is(err, tc.kind) || errors.Is(err, ErrEncode) {
+			t.Errorf("expected %v, found %v", tc.kind, err)
+		}
+	}
+
+	err = sendErr(ErrDial, timeoutError("dial", "graphite:2003", &net.DNSError{IsTimeout: true}))
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrDial) {
+		t.Fatal("expected a dial timeout:", err)
+	}
+}
+
+func TestGraphiteOncePooled(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		r := metrics.NewRegistry()
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+		var b strings.Builder
+		if err := GraphiteOnce(GraphiteConfig{Registry: r, Prefix: "app", Sink: WriterSink(&b), Timestamp: func() int64 { return 1 }, DurationUnit: time.Millisecond}); nil != err {
+			t.Fatal(err)
+		}
+		if expected := "app." + name + ".count 1 1\n"; b.String() != expected {
+			t.Fatalf("expected %q, found %q", expected, b.String())
+		}
+	}
+}
+
+func TestOptionalFields(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := metrics.GetOrRegisterHistogram("size", r, metrics.NewUniformSample(10))
+	for _, v := range []int64{1, 2, 3} {
+		h.Update(v)
+	}
+	timer := metrics.GetOrRegisterTimer("latency", r)
+	timer.Update(time.Millisecond)
+	timer.Update(3 * time.Millisecond)
+
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:       r,
+		Prefix:         "app",
+		Sink:           WriterSink(&b),
+		Timestamp:      func() int64 { return 1 },
+		DurationUnit:   time.Millisecond,
+		OptionalFields: []string{"variance", "sample_size"},
+		TimerFields:    []string{"count", "variance"},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"app.size.variance 0.67 1\n",
+		"app.size.sample_size 3 1\n",
+		"app.latency.count 2 1\n",
+		"app.latency.variance 1.00 1\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("%q not exported in %q", line, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "latency.sample_size") || strings.Contains(b.String(), "latency.mean") {
+		t.Error("fields of timers not selected exported:", b.String())
+	}
+
+	b.Reset()
+	GraphiteOnce(GraphiteConfig{Registry: r, Prefix: "app", Sink: WriterSink(&b), Timestamp: func() int64 { return 1 }, DurationUnit: time.Millisecond})
+	if strings.Contains(b.String(), "variance") || strings.Contains(b.String(), "sample_size") {
+		t.Error("optional fields exported by default:", b.String())
+	}
+}
+
+// A scrapedGauge is a gauge holding a reading taken at a known time.
+type scrapedGauge struct {
+	metrics.Gauge
+	at time.Time
+}
+
+func (g scrapedGauge) Timestamp() time.Time { return g.at }
+
+func TestTimestampedMetric(t *testing.T) {
+	r := metrics.NewRegistry()
+	g := metrics.NewGauge()
+	g.Update(7)
+	r.Register("device.temperature", scrapedGauge{g, time.Unix(1234, 0)})
+	r.Register("device.unknown", scrapedGauge{g, time.Time{}})
+	metrics.GetOrRegisterCounter("requests", r).Inc(1)
+
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		DurationUnit: time.Nanosecond,
+		SortedOutput: true,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	expected := "app.device.temperature.value 7 1234\napp.device.unknown.value 7 1\napp.requests.count 1 1\n"
+	if found := b.String(); expected != found {
+		t.Errorf("%q, not %q", found, expected)
+	}
+}
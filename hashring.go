@@ -0,0 +1,89 @@
+package graphite
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ringReplicas is the number of positions of every destination on a
+// hashRing, that of carbon.
+const ringReplicas = 100
+
+// A destination is a carbon-cache server of Destinations.
+type destination struct {
+	addr     string // host:port to connect to
+	server   string // Host, which carbon hashes instead of addr
+	instance string // Name of the carbon-cache instance, if any
+}
+
+// parseDestination parses a destination in the host:port:instance form of
+// the DESTINATIONS of carbon-relay, where the instance is optional.
+func parseDestination(s string) destination {
+	if host, _, err := net.SplitHostPort(s); nil == err {
+		return destination{addr: s, server: host}
+	}
+	if i := strings.LastIndexByte(s, ':'); 0 <= i {
+		if host, _, err := net.SplitHostPort(s[:i]); nil == err {
+			return destination{addr: s[:i], server: host, instance: s[i+1:]}
+		}
+	}
+	return destination{addr: s, server: s}
+}
+
+// key returns the name carbon hashes the destination by, the Python
+// representation of its (server, instance) tuple.
+func (d destination) key() string {
+	instance := "None"
+	if "" != d.instance {
+		instance = "'" + d.instance + "'"
+	}
+	return "('" + d.server + "', " + instance + ")"
+}
+
+type ringEntry struct {
+	position int
+	shard    int
+}
+
+// A hashRing routes series to destinations the way the consistent hashing
+// of carbon-relay does, its carbon_ch hash type, so that each series is
+// sent to the carbon-cache it would be relayed to and graphite-web finds
+// it where its CARBONLINK_HOSTS say.
+type hashRing struct {
+	entries []ringEntry // Sorted by position
+}
+
+func newHashRing(dests []destination) *hashRing {
+	r := &hashRing{}
+	taken := make(map[int]bool)
+	for i, d := range dests {
+		for j := 0; j < ringReplicas; j++ {
+			position := ringPosition(d.key() + ":" + strconv.Itoa(j))
+			for taken[position] {
+				position++
+			}
+			taken[position] = true
+			r.entries = append(r.entries, ringEntry{position, i})
+		}
+	}
+	sort.Slice(r.entries, func(i, j int) bool { return r.entries[i].position < r.entries[j].position })
+	return r
+}
+
+// ringPosition returns the position of key on a hashRing, the first two
+// bytes of its MD5 hash.
+func ringPosition(key string) int {
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2]))
+}
+
+// shard returns the index of the destination the named series is sent to.
+func (r *hashRing) shard(name string) int {
+	position := ringPosition(name)
+	i := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].position >= position })
+	return r.entries[i%len(r.entries)].shard
+}
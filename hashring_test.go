@@ -0,0 +1,88 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHashRing(t *testing.T) {
+	// Destinations of carbon's ConsistentHashRing for these nodes.
+	r := newHashRing([]destination{
+		parseDestination("127.0.0.1:2004:a"),
+		parseDestination("127.0.0.1:2104:b"),
+		parseDestination("10.0.0.2:2004"),
+	})
+	for name, expected := range map[string]int{
+		"foo.count":        0,
+		"foo.bar.mean":     2,
+		"app.requests.p99": 2,
+		"carbon.agents.x":  0,
+		"a":                2,
+		"b":                1,
+		"c":                0,
+	} {
+		if found := r.shard(name); expected != found {
+			t.Errorf("expected %s on destination %d, found %d", name, expected, found)
+		}
+	}
+}
+
+func TestParseDestination(t *testing.T) {
+	for s, expected := range map[string]destination{
+		"127.0.0.1:2004":   {addr: "127.0.0.1:2004", server: "127.0.0.1"},
+		"127.0.0.1:2004:a": {addr: "127.0.0.1:2004", server: "127.0.0.1", instance: "a"},
+		"[::1]:2004:a":     {addr: "[::1]:2004", server: "::1", instance: "a"},
+		"graphite":         {addr: "graphite", server: "graphite"},
+	} {
+		if found := parseDestination(s); expected != found {
+			t.Errorf("expected %+v for %s, found %+v", expected, s, found)
+		}
+	}
+	if expected, found := "('127.0.0.1', 'a')", parseDestination("127.0.0.1:2004:a").key(); expected != found {
+		t.Fatalf("expected %s, found %s", expected, found)
+	}
+	if expected, found := "('127.0.0.1', None)", parseDestination("127.0.0.1:2004").key(); expected != found {
+		t.Fatalf("expected %s, found %s", expected, found)
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	resA, la, r, c, wgA := NewTestServer(t, "foobar")
+	defer la.Close()
+	resB, lb, _, _, wgB := NewTestServer(t, "foobar")
+	defer lb.Close()
+
+	c.Addr = nil
+	c.Destinations = []string{la.Addr().String() + ":a", lb.Addr().String() + ":b"}
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, name := range names {
+		metrics.GetOrRegisterCounter(name, r).Inc(1)
+	}
+
+	wgA.Add(1)
+	wgB.Add(1)
+	if err := NewExporter(c).Once(); nil != err {
+		t.Fatal(err)
+	}
+	wgA.Wait()
+	wgB.Wait()
+
+	ring := newHashRing([]destination{parseDestination(c.Destinations[0]), parseDestination(c.Destinations[1])})
+	for _, name := range names {
+		series := "foobar." + name + ".count"
+		res, other := resA, resB
+		if 1 == ring.shard(series) {
+			res, other = resB, resA
+		}
+		if expected, found := 1.0, res[series]; !floatEquals(found, expected) {
+			t.Fatal("bad value of", series, expected, found)
+		}
+		if _, ok := other[series]; ok {
+			t.Fatal("sent to the wrong destination:", series)
+		}
+	}
+	if 0 == len(resA) || 0 == len(resB) {
+		t.Fatal("expected series to be spread across destinations")
+	}
+}
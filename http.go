@@ -0,0 +1,70 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Transports for GraphiteConfig.Transport.
+const (
+	TransportDefault = ""        // The protocol's own transport, usually TCP
+	TransportHTTP    = "http"    // POST to URL
+	TransportStdout  = "stdout"  // Write to standard output, to check names locally before sending them anywhere
+	TransportDiscard = "discard" // Encode flushes without sending them anywhere, see DiscardSink
+)
+
+// overHTTP reports whether metrics are POSTed to URL rather than written
+// to a socket.
+func (c *GraphiteConfig) overHTTP() bool {
+	return TransportHTTP == c.Transport || ProtocolRemoteWrite == c.Protocol
+}
+
+// post sends body, a batch of encoded metrics, to URL within ctx.
+func (e *Exporter) post(ctx context.Context, body []byte) error {
+	c := &e.config
+	contentType := "text/plain"
+	switch c.Protocol {
+	case ProtocolRemoteWrite:
+		body = snappyEncode(body)
+		contentType = "application/x-protobuf"
+	case ProtocolJSON:
+		contentType = "application/x-ndjson"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	for k, vs := range c.HTTPHeaders {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.compressed() {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if ProtocolRemoteWrite == c.Protocol {
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	}
+	if "" != c.HTTPUsername {
+		req.SetBasicAuth(c.HTTPUsername, c.HTTPPassword)
+	} else if "" != c.APIKey {
+		req.SetBasicAuth(c.APIKey, "")
+	}
+	client := c.HTTPClient
+	if nil == client {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("graphite: POST to %s failed: %s", c.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); "user" != user || "pass" != pass {
+			t.Error("bad basic auth:", user, pass)
+		}
+		if "yes" != r.Header.Get("X-Test") {
+			t.Error("missing header")
+		}
+		if "application/x-ndjson" != r.Header.Get("Content-Type") {
+			t.Error("bad content type:", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterGaugeFloat64("bar", r).Update(1.5)
+
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:      r,
+		Prefix:        "app",
+		Protocol:      ProtocolJSON,
+		Transport:     TransportHTTP,
+		URL:           ts.URL,
+		HTTPHeaders:   http.Header{"X-Test": {"yes"}},
+		HTTPUsername:  "user",
+		HTTPPassword:  "pass",
+		HTTPBatchSize: 1,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if 2 != len(bodies) {
+		t.Fatal("expected a request per metric:", bodies)
+	}
+
+	all := strings.Join(bodies, "")
+	for _, line := range []string{
+		`{"name":"app.foo.count","value":2,"timestamp":`,
+		`{"name":"app.bar.value","value":1.5,"timestamp":`,
+	} {
+		if !strings.Contains(all, line) {
+			t.Errorf("missing %s in %q", line, all)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package graphite
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An influxEncoder writes each metric as one line of InfluxDB's line
+// protocol, named after the metric and with a field per series.
+type influxEncoder struct {
+	w    io.Writer
+	tags map[string]string
+	namer
+	line []byte
+}
+
+var (
+	influxEscaper            = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+// influxEscape returns s escaped by r, with the bytes which split lines
+// other than spaces, which r escapes, replaced by underscores, as the line
+// protocol cannot escape them.
+func influxEscape(r *strings.Replacer, s string) string {
+	return r.Replace(replaceBytes(s, func(c byte) bool { return ' ' != c && splitsLine(c) }))
+}
+
+func (enc *influxEncoder) encode(dps []datapoint, now int64) {
+	b := enc.line[:0]
+	n := 0
+	for i := range dps {
+		dp := &dps[i]
+		if !dp.valid() {
+			continue
+		}
+		if 0 == n {
+			b = append(b, influxEscape(influxMeasurementEscaper, enc.fullName(dp))...)
+			b = appendInfluxTags(b, dp.tags.merge(enc.tags))
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		n++
+		b = append(b, influxEscape(influxEscaper, enc.suffix(dp))...)
+		b = append(b, '=')
+		if dp.field.integer() {
+			b = strconv.AppendInt(b, dp.ivalue, 10)
+			b = append(b, 'i')
+		} else {
+			b = enc.appendFloat(b, dp.fvalue)
+		}
+	}
+	if 0 == n {
+		return
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, now*1e9, 10)
+	b = append(b, '\n')
+	enc.w.Write(b)
+	enc.line = b
+}
+
+// appendInfluxTags appends tags to b, sorted by key as InfluxDB
+// recommends.
+func appendInfluxTags(b []byte, tags map[string]string) []byte {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = append(b, ',')
+		b = append(b, influxEscape(influxEscaper, k)...)
+		b = append(b, '=')
+		b = append(b, influxEscape(influxEscaper, tags[k])...)
+	}
+	return b
+}
@@ -0,0 +1,25 @@
+package graphite
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestInfluxEncoder(t *testing.T) {
+	c := GraphiteConfig{Protocol: ProtocolInflux, Tags: map[string]string{"host": "a b", "dc": "east\nx"}}
+	var b bytes.Buffer
+	enc := newEncoder(&b, &c)
+
+	enc.encode([]datapoint{
+		{prefix: "app", name: "req,s", field: fieldHistogramCount, ivalue: 3},
+		{prefix: "app", name: "req,s", field: fieldMean, fvalue: 1.5},
+		{prefix: "app", name: "req,s", field: fieldStddev, fvalue: math.NaN()},
+		{prefix: "app", name: "req,s", field: fieldPercentile, key: "99", fvalue: 2},
+	}, 10)
+	enc.encode(nil, 10)
+
+	if expected, found := "app.req\\,s,dc=east_x,host=a\\ b count=3i,mean=1.5,99-percentile=2 10000000000\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
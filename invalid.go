@@ -0,0 +1,62 @@
+package graphite
+
+import "math"
+
+// Policies of GraphiteConfig.InvalidValues for NaN and infinite values,
+// since some dashboards break on missing points and others on zeros.
+const (
+	InvalidSend = ""     // Send them as they are, unless SkipInvalidValues is set
+	InvalidSkip = "skip" // Omit them
+	InvalidZero = "zero" // Send zero instead
+	InvalidLast = "last" // Send the last finite value of the series instead, omitting them until there is one
+)
+
+var invalidPolicies = []string{InvalidSend, InvalidSkip, InvalidZero, InvalidLast}
+
+// kindNames maps the kinds of metrics to their names in
+// InvalidValuesByType.
+var kindNames = map[kind]string{
+	kindCounter:      "counter",
+	kindGauge:        "gauge",
+	kindGaugeFloat64: "gauge",
+	kindHistogram:    "histogram",
+	kindMeter:        "meter",
+	kindTimer:        "timer",
+	kindEWMA:         "ewma",
+	kindHealthcheck:  "healthcheck",
+	kindCustom:       "custom",
+}
+
+// invalidPolicy returns the policy for the NaN and infinite values of
+// metrics of kind k.
+func (c *GraphiteConfig) invalidPolicy(k kind) string {
+	if p, ok := c.InvalidValuesByType[kindNames[k]]; ok {
+		return p
+	}
+	return c.InvalidValues
+}
+
+// replaceInvalid applies InvalidValues to the NaN and infinite values of
+// dps, returning those left to export.
+func (e *Exporter) replaceInvalid(dps []datapoint) []datapoint {
+	out := dps[:0]
+	for _, dp := range dps {
+		if !dp.valid() {
+			switch e.config.invalidPolicy(dp.kind) {
+			case InvalidSkip:
+				continue
+			case InvalidZero:
+				dp.fvalue = 0
+			case InvalidLast:
+				o, ok := e.values[dp.series()]
+				last := math.Float64frombits(o.fvalue)
+				if !ok || math.IsNaN(last) || math.IsInf(last, 0) {
+					continue
+				}
+				dp.fvalue = last
+			}
+		}
+		out = append(out, dp)
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package graphite
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// A jsonEncoder writes each datapoint as a JSON object on its own line,
+// holding its plaintext series name, value and timestamp, and the tags of
+// its metric, if any.
+type jsonEncoder struct {
+	w io.Writer
+	namer
+	line []byte
+}
+
+func (enc *jsonEncoder) encode(dps []datapoint, now int64) {
+	for i := range dps {
+		dp := &dps[i]
+		if !dp.valid() {
+			continue
+		}
+		name, _ := json.Marshal(enc.path(dp))
+		b := append(enc.line[:0], `{"name":`...)
+		b = append(b, name...)
+		b = append(b, `,"value":`...)
+		b = enc.appendValue(b, dp)
+		b = append(b, `,"timestamp":`...)
+		b = strconv.AppendInt(b, now, 10)
+		if nil != dp.tags {
+			tags, _ := json.Marshal(dp.tags.merge(nil))
+			b = append(b, `,"tags":`...)
+			b = append(b, tags...)
+		}
+		b = append(b, "}\n"...)
+		enc.w.Write(b)
+		enc.line = b
+	}
+}
@@ -0,0 +1,102 @@
+// Package kafkagraphite provides a graphite.Sink which publishes flushes to
+// a Kafka topic, for pipelines which buffer metrics in Kafka before carbon
+// consumes them, such as to replay them after an outage:
+//
+//	sink := kafkagraphite.NewSink([]string{"kafka1:9092", "kafka2:9092"}, "metrics")
+//	defer sink.Close()
+//	graphite.GraphiteWithConfig(graphite.GraphiteConfig{Sink: sink, Prefix: "app", ...})
+//
+// Every line of a flush, such as a plaintext line, is published as a message
+// keyed by its series name, so that each series is always written to the
+// same partition and consumed in order.
+package kafkagraphite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/dt/go-metrics-graphite"
+	"github.com/segmentio/kafka-go"
+)
+
+// A Writer publishes messages to Kafka, such as a *kafka.Writer.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// A Sink publishes the lines of every flush to Kafka.
+type Sink struct {
+	Timeout time.Duration // Limit of publishing each batch of a flush, zero waits forever
+
+	w Writer
+}
+
+var _ graphite.Sink = (*Sink)(nil)
+
+// NewSink returns a Sink publishing to topic through the given brokers,
+// partitioning messages by the hash of their key.
+func NewSink(brokers []string, topic string) *Sink {
+	return NewWriterSink(&kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	})
+}
+
+// NewWriterSink returns a Sink publishing through w, such as a kafka.Writer
+// configured with TLS, SASL or batching options.
+func NewWriterSink(w Writer) *Sink {
+	return &Sink{w: w}
+}
+
+func (s *Sink) Open() (io.WriteCloser, error) {
+	return writer{s}, nil
+}
+
+// Close closes the Writer, if it can be closed.
+func (s *Sink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type writer struct {
+	s *Sink
+}
+
+// Write publishes every line of b as a message keyed by its series name,
+// the part of the line up to its first space. The messages hold a copy of
+// b, as the Writer may publish them asynchronously.
+func (w writer) Write(b []byte) (int, error) {
+	var msgs []kafka.Message
+	for rest := append([]byte(nil), b...); 0 < len(rest); {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); 0 <= i {
+			line, rest = rest[:i+1], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		key := line
+		if i := bytes.IndexByte(line, ' '); 0 <= i {
+			key = line[:i]
+		}
+		msgs = append(msgs, kafka.Message{Key: key, Value: line})
+	}
+	ctx := context.Background()
+	if 0 < w.s.Timeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.s.Timeout)
+		defer cancel()
+	}
+	if err := w.s.w.WriteMessages(ctx, msgs...); nil != err {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w writer) Close() error {
+	return nil
+}
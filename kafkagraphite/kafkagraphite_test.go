@@ -0,0 +1,50 @@
+package kafkagraphite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dt/go-metrics"
+	"github.com/dt/go-metrics-graphite"
+	"github.com/segmentio/kafka-go"
+)
+
+// A fakeWriter records the messages published.
+type fakeWriter struct {
+	msgs []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	metrics.GetOrRegisterGauge("bar", r).Update(2)
+
+	w := &fakeWriter{}
+	err := graphite.GraphiteOnce(graphite.GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         NewWriterSink(w),
+		SortedOutput: true,
+		Timestamp:    func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	expected := []struct{ key, value string }{
+		{"app.bar.value", "app.bar.value 2 1\n"},
+		{"app.foo.count", "app.foo.count 1 1\n"},
+	}
+	if len(expected) != len(w.msgs) {
+		t.Fatalf("expected %d messages, found %d", len(expected), len(w.msgs))
+	}
+	for i, m := range w.msgs {
+		if expected[i].key != string(m.Key) || expected[i].value != string(m.Value) {
+			t.Fatalf("expected %q keyed %q, found %q keyed %q", expected[i].value, expected[i].key, m.Value, m.Key)
+		}
+	}
+}
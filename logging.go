@@ -0,0 +1,67 @@
+package graphite
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// A Logger logs the errors of an Exporter, such as a *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger logs through the standard logger of package log.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// defaultLogInterval is how often an error which keeps repeating is logged
+// again unless LogInterval is set.
+const defaultLogInterval = 5 * time.Minute
+
+// An errorLog throttles the errors an Exporter logs, so that an unreachable
+// server does not log the same error every flush: an error is logged when
+// it first occurs, then repeats of it are counted and summarized once per
+// LogInterval, or when a different error occurs.
+type errorLog struct {
+	mu      sync.Mutex
+	last    string    // Message of the last error logged
+	logged  time.Time // When last or its summary was logged
+	repeats int       // Repeats of last since then
+}
+
+// logError logs err through Logger, throttling repeats of the same error
+// per LogInterval.
+func (e *Exporter) logError(err error) {
+	c := &e.config
+	logger := c.Logger
+	if nil == logger {
+		logger = stdLogger{}
+	}
+	interval := c.LogInterval
+	if 0 == interval {
+		interval = defaultLogInterval
+	}
+	msg := err.Error()
+	l := &e.errorLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := c.clock().Now()
+	if 0 < interval && msg == l.last {
+		l.repeats++
+		if now.Sub(l.logged) < interval {
+			return
+		}
+		logger.Printf("%s (repeated %d times in %v)", msg, l.repeats, now.Sub(l.logged).Round(time.Second))
+		l.logged, l.repeats = now, 0
+		return
+	}
+	if 0 < l.repeats {
+		logger.Printf("%s (repeated %d more times)", l.last, l.repeats)
+	}
+	logger.Printf("%s", msg)
+	l.last, l.logged, l.repeats = msg, now, 0
+}
@@ -0,0 +1,149 @@
+package graphite
+
+import "strings"
+
+// A Namer joins the prefix of a registry, the name of a metric and the
+// suffix of one of its series, such as "count" or "99-percentile", into the
+// path of a plaintext series. It replaces the layout of the format strings
+// of ExportFormats, whose suffixes it is passed after SuffixMap is applied,
+// and takes precedence over Naming, so that names can be joined with other
+// separators, reordered or given more components without touching format
+// strings.
+//
+// Paths are cached between flushes, so Join must always return the same
+// path for the same arguments.
+type Namer interface {
+	Join(prefix, name, suffix string) string
+}
+
+// A NamerFunc is a Namer joining paths by calling itself.
+type NamerFunc func(prefix, name, suffix string) string
+
+func (f NamerFunc) Join(prefix, name, suffix string) string {
+	return f(prefix, name, suffix)
+}
+
+// A SeparatorNamer joins the components of paths with itself, such as "_"
+// to export "app_requests_count".
+type SeparatorNamer string
+
+func (s SeparatorNamer) Join(prefix, name, suffix string) string {
+	return prefix + string(s) + name + string(s) + suffix
+}
+
+// DefaultNamer joins paths like the format strings of ExportFormats,
+// "%s.%s.%s".
+var DefaultNamer Namer = SeparatorNamer(".")
+
+// Orders of the components of plaintext paths for GraphiteConfig.PathOrder.
+const (
+	PathPrefixFirst    = ""                // Prefix, name and suffix, as joined by Namer
+	PathReversedDomain = "reversed-domain" // Labels of the fully qualified hostname in reverse first, such as "com.example.host01.app.requests.count"
+)
+
+// ReversedDomainNamer returns a Namer starting every path with the labels
+// of domain in reverse, such as "com.example.host01" for
+// "host01.example.com", followed by the path n joins, or DefaultNamer if n is
+// nil.
+func ReversedDomainNamer(domain string, n Namer) Namer {
+	if nil == n {
+		n = DefaultNamer
+	}
+	labels := strings.Split(strings.Trim(domain, "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return reversedDomainNamer{root: strings.Join(labels, ".") + ".", next: n}
+}
+
+type reversedDomainNamer struct {
+	root string
+	next Namer
+}
+
+func (n reversedDomainNamer) Join(prefix, name, suffix string) string {
+	return n.root + n.next.Join(prefix, name, suffix)
+}
+
+// typeRoots are the roots GraphiteConfig.TypeRoots nests the names of each
+// type of metric under. Custom metrics, rollups and the series of the
+// exporter itself have none.
+var typeRoots = map[kind]string{
+	kindCounter:      "counters.",
+	kindGauge:        "gauges.",
+	kindGaugeFloat64: "gauges.",
+	kindHistogram:    "histograms.",
+	kindMeter:        "meters.",
+	kindTimer:        "timers.",
+	kindEWMA:         "ewmas.",
+	kindHealthcheck:  "healthchecks.",
+}
+
+// Kinds of names held by a nameCache.
+const (
+	namePath   = iota // Plaintext series name of a datapoint, see namer.path
+	nameSuffix        // Last part of the plaintext series name, see namer.suffix
+	nameFull          // Name of a metric with its prefix, see datapoint.fullName
+)
+
+type nameKey struct {
+	kind int
+	s    series
+}
+
+type cachedName struct {
+	name  string
+	sweep uint64 // Sweep the name was last used in
+}
+
+// A nameCache holds the names rendered for the series encoded since the
+// previous sweep, so that flushes don't render and allocate them again. The
+// names of series which were not encoded are dropped by the next sweep,
+// such as those of metrics which were unregistered. Names rendered with
+// invalid format strings are not cached, so that every flush reports them.
+type nameCache struct {
+	names  map[nameKey]cachedName
+	sweeps uint64
+}
+
+// get returns the cached name, marking it as used.
+func (c *nameCache) get(k nameKey) (string, bool) {
+	n, ok := c.names[k]
+	if ok && n.sweep != c.sweeps {
+		n.sweep = c.sweeps
+		c.names[k] = n
+	}
+	return n.name, ok
+}
+
+func (c *nameCache) put(k nameKey, name string) {
+	if nil == c.names {
+		c.names = make(map[nameKey]cachedName)
+	}
+	c.names[k] = cachedName{name: name, sweep: c.sweeps}
+}
+
+// sweep drops the names which were not used since the previous sweep.
+func (c *nameCache) sweep() {
+	for k, n := range c.names {
+		if n.sweep != c.sweeps {
+			delete(c.names, k)
+		}
+	}
+	c.sweeps++
+}
+
+// fullName returns the name of the metric dp belongs to, prefixed with the
+// prefix of its registry.
+func (n *namer) fullName(dp *datapoint) string {
+	if "" == dp.prefix {
+		return dp.name
+	}
+	k := nameKey{kind: nameFull, s: series{prefix: dp.prefix, name: dp.name}}
+	if name, ok := n.cache.get(k); ok {
+		return name
+	}
+	name := dp.fullName()
+	n.cache.put(k, name)
+	return name
+}
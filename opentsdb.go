@@ -0,0 +1,71 @@
+package graphite
+
+import (
+	"io"
+	"sort"
+	"strconv"
+)
+
+// An openTSDBEncoder writes datapoints as OpenTSDB put commands, naming
+// them after their plaintext series.
+type openTSDBEncoder struct {
+	w      io.Writer
+	global map[string]string // Tags of every datapoint
+	tags   []byte            // Rendered global tags, starting with a space
+	namer
+	line []byte
+}
+
+// openTSDBEscape returns s with the bytes which split put commands or their
+// tags replaced by underscores.
+func openTSDBEscape(s string) string {
+	return replaceBytes(s, func(c byte) bool { return '=' == c || splitsLine(c) })
+}
+
+func newOpenTSDBEncoder(w io.Writer, c *GraphiteConfig) *openTSDBEncoder {
+	tags := c.Tags
+	if 0 == len(tags) {
+		// OpenTSDB requires every datapoint to carry at least one tag.
+		tags = map[string]string{"host": hostname()}
+	}
+	return &openTSDBEncoder{w: w, global: tags, tags: appendOpenTSDBTags(nil, tags), namer: newNamer(c)}
+}
+
+// appendOpenTSDBTags appends tags to b, sorted by key.
+func appendOpenTSDBTags(b []byte, tags map[string]string) []byte {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = append(b, ' ')
+		b = append(b, openTSDBEscape(k)...)
+		b = append(b, '=')
+		b = append(b, openTSDBEscape(tags[k])...)
+	}
+	return b
+}
+
+func (enc *openTSDBEncoder) encode(dps []datapoint, now int64) {
+	for i := range dps {
+		dp := &dps[i]
+		if !dp.valid() {
+			continue
+		}
+		b := append(enc.line[:0], "put "...)
+		b = append(b, openTSDBEscape(enc.path(dp))...)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, now, 10)
+		b = append(b, ' ')
+		b = enc.appendValue(b, dp)
+		if nil != dp.tags {
+			b = appendOpenTSDBTags(b, dp.tags.merge(enc.global))
+		} else {
+			b = append(b, enc.tags...)
+		}
+		b = append(b, '\n')
+		enc.w.Write(b)
+		enc.line = b
+	}
+}
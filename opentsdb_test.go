@@ -0,0 +1,27 @@
+package graphite
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestOpenTSDBEncoder(t *testing.T) {
+	c := GraphiteConfig{
+		Protocol:  ProtocolOpenTSDB,
+		Tags:      map[string]string{"host": "web 1", "dc": "east\nx"},
+		SuffixMap: map[string]string{"count": "total"},
+	}
+	var b bytes.Buffer
+	enc := newEncoder(&b, &c)
+
+	enc.encode([]datapoint{
+		{prefix: "app", name: "reqs", field: fieldHistogramCount, ivalue: 3},
+		{prefix: "app", name: "reqs", field: fieldMean, fvalue: 1.5},
+		{prefix: "app", name: "reqs", field: fieldStddev, fvalue: math.NaN()},
+	}, 10)
+
+	if expected, found := "put app.reqs.total 10 3 dc=east_x host=web_1\nput app.reqs.mean 10 1.5 dc=east_x host=web_1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
@@ -0,0 +1,215 @@
+// Package otelgraphite provides an OpenTelemetry metric exporter which ships
+// the metrics collected by the OpenTelemetry SDK through a graphite.Exporter,
+// so that services migrating to OpenTelemetry can keep sending to an
+// existing Graphite cluster, with the same configuration, protocols and
+// transports as services instrumented with go-metrics:
+//
+//	exp := otelgraphite.New(graphite.GraphiteConfig{Address: "graphite:2003", Prefix: "app"})
+//	provider := metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(exp)))
+//
+// Instruments are exported under their names, followed by the keys and
+// values of their attributes, sorted by key. Counters end in "count", other
+// sums and gauges in "value". Histograms export their "count", "sum", "min"
+// and "max", and their cumulative buckets as bucket.le_0_5, bucket.le_inf
+// and so on. Summaries export their quantiles as p50, p99_9 and so on.
+package otelgraphite
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dt/go-metrics"
+	"github.com/dt/go-metrics-graphite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// A series is one value of a datapoint, named by its suffix.
+type series struct {
+	suffix string
+	value  float64
+}
+
+// A datapoint holds the series of an instrument with one set of attributes,
+// as collected by the SDK.
+type datapoint struct {
+	series []series
+}
+
+func init() {
+	graphite.RegisterEncoder(&datapoint{}, func(i interface{}, emit func(string, float64)) {
+		for _, s := range i.(*datapoint).series {
+			emit(s.suffix, s.value)
+		}
+	})
+}
+
+// An Exporter is a metric.Exporter which sends every collection as a flush
+// of a graphite.Exporter.
+type Exporter struct {
+	mu       sync.Mutex
+	exporter *graphite.Exporter
+	names    []string     // Names of the datapoints of the collection being exported
+	points   []*datapoint // Datapoints of the collection being exported
+}
+
+var _ metric.Exporter = (*Exporter)(nil)
+
+// New returns an Exporter sending to the server described by c. Its
+// Registry is replaced by the collections of the SDK, while the metrics of
+// its Registries are exported along with them. Collections are sent before
+// Export returns, even with AsyncSend.
+func New(c graphite.GraphiteConfig) *Exporter {
+	x := &Exporter{}
+	c.Registry = &registry{Registry: metrics.NewRegistry(), x: x}
+	c.AsyncSend = false
+	x.exporter = graphite.NewExporter(c)
+	return x
+}
+
+// A registry presents the collection being exported as the metrics of a
+// metrics.Registry.
+type registry struct {
+	metrics.Registry
+	x *Exporter
+}
+
+func (r *registry) Each(fn func(string, interface{})) {
+	for i, name := range r.x.names {
+		fn(name, r.x.points[i])
+	}
+}
+
+// Temporality returns the cumulative temporality for every kind of
+// instrument, as Graphite series of counters hold their totals.
+func (x *Exporter) Temporality(metric.InstrumentKind) metricdata.Temporality {
+	return metricdata.CumulativeTemporality
+}
+
+// Aggregation returns the default aggregation of the SDK for k.
+func (x *Exporter) Aggregation(k metric.InstrumentKind) metric.Aggregation {
+	return metric.DefaultAggregationSelector(k)
+}
+
+// Export sends the metrics of rm within ctx.
+func (x *Exporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.names, x.points = x.names[:0], x.points[:0]
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			x.add(m)
+		}
+	}
+	return x.exporter.OnceContext(ctx)
+}
+
+// ForceFlush returns nil, as Export sends every collection right away.
+func (x *Exporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+// Shutdown closes the connections of the exporter.
+func (x *Exporter) Shutdown(context.Context) error {
+	return x.exporter.Close()
+}
+
+// add appends the datapoints of m to the collection being exported.
+func (x *Exporter) add(m metricdata.Metrics) {
+	switch data := m.Data.(type) {
+	case metricdata.Gauge[int64]:
+		addValues(x, m.Name, "value", data.DataPoints)
+	case metricdata.Gauge[float64]:
+		addValues(x, m.Name, "value", data.DataPoints)
+	case metricdata.Sum[int64]:
+		addValues(x, m.Name, sumSuffix(data.IsMonotonic), data.DataPoints)
+	case metricdata.Sum[float64]:
+		addValues(x, m.Name, sumSuffix(data.IsMonotonic), data.DataPoints)
+	case metricdata.Histogram[int64]:
+		addHistograms(x, m.Name, data.DataPoints)
+	case metricdata.Histogram[float64]:
+		addHistograms(x, m.Name, data.DataPoints)
+	case metricdata.Summary:
+		for _, dp := range data.DataPoints {
+			p := x.point(m.Name, dp.Attributes)
+			p.add("count", float64(dp.Count))
+			p.add("sum", dp.Sum)
+			for _, q := range dp.QuantileValues {
+				p.add("p"+key(q.Quantile*100), q.Value)
+			}
+		}
+	}
+}
+
+// point appends a datapoint of the named instrument with the attributes s
+// to the collection, returning it.
+func (x *Exporter) point(name string, s attribute.Set) *datapoint {
+	var b strings.Builder
+	b.WriteString(name)
+	for it := s.Iter(); it.Next(); {
+		kv := it.Attribute()
+		b.WriteByte('.')
+		b.WriteString(escaper.Replace(string(kv.Key)))
+		b.WriteByte('.')
+		b.WriteString(escaper.Replace(kv.Value.Emit()))
+	}
+	p := &datapoint{}
+	x.names, x.points = append(x.names, b.String()), append(x.points, p)
+	return p
+}
+
+func (p *datapoint) add(suffix string, value float64) {
+	p.series = append(p.series, series{suffix, value})
+}
+
+var escaper = strings.NewReplacer(".", "_", " ", "_", "\n", "_")
+
+// sumSuffix returns the suffix of sums, "count" for counters which only
+// increase.
+func sumSuffix(monotonic bool) string {
+	if monotonic {
+		return "count"
+	}
+	return "value"
+}
+
+func addValues[N int64 | float64](x *Exporter, name, suffix string, dps []metricdata.DataPoint[N]) {
+	for _, dp := range dps {
+		x.point(name, dp.Attributes).add(suffix, float64(dp.Value))
+	}
+}
+
+func addHistograms[N int64 | float64](x *Exporter, name string, dps []metricdata.HistogramDataPoint[N]) {
+	for _, dp := range dps {
+		p := x.point(name, dp.Attributes)
+		p.add("count", float64(dp.Count))
+		p.add("sum", float64(dp.Sum))
+		if v, ok := dp.Min.Value(); ok {
+			p.add("min", float64(v))
+		}
+		if v, ok := dp.Max.Value(); ok {
+			p.add("max", float64(v))
+		}
+		var n uint64
+		for i, bound := range dp.Bounds {
+			if i < len(dp.BucketCounts) {
+				n += dp.BucketCounts[i]
+			}
+			p.add("bucket.le_"+key(bound), float64(n))
+		}
+		p.add("bucket.le_inf", float64(dp.Count))
+	}
+}
+
+// key formats v as a path component, with an underscore for the decimal
+// point.
+func key(v float64) string {
+	if math.IsInf(v, 1) {
+		return "inf"
+	}
+	return strings.Replace(strconv.FormatFloat(v, 'f', -1, 64), ".", "_", 1)
+}
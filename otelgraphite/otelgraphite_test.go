@@ -0,0 +1,90 @@
+package otelgraphite
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dt/go-metrics-graphite"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExporter(t *testing.T) {
+	ctx := context.Background()
+	reader := metric.NewManualReader()
+	meter := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test")
+	requests, _ := meter.Int64Counter("requests")
+	latency, _ := meter.Float64Histogram("latency", otelmetric.WithExplicitBucketBoundaries(0.5, 1))
+	requests.Add(ctx, 3, otelmetric.WithAttributes(attribute.String("code", "200")))
+	latency.Record(ctx, 0.25)
+	latency.Record(ctx, 2)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); nil != err {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	x := New(graphite.GraphiteConfig{Prefix: "app", Sink: graphite.WriterSink(&b), Timestamp: func() int64 { return 1 }})
+	if err := x.Export(ctx, &rm); nil != err {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"app.requests.code.200.count 3.000000 1\n",
+		"app.latency.count 2.000000 1\n",
+		"app.latency.sum 2.250000 1\n",
+		"app.latency.min 0.250000 1\n",
+		"app.latency.bucket.le_0_5 1.000000 1\n",
+		"app.latency.bucket.le_1 1.000000 1\n",
+		"app.latency.bucket.le_inf 2.000000 1\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected %q in %q", line, b.String())
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	reader := metric.NewManualReader()
+	requests, _ := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test").Int64Counter("requests")
+	requests.Add(ctx, 3)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); nil != err {
+		t.Fatal(err)
+	}
+	x := New(graphite.GraphiteConfig{
+		Prefix:    "app",
+		Sink:      graphite.WriterSink(io.Discard),
+		Timestamp: func() int64 { return 1 },
+		Tracer:    NewTracer(tp),
+	})
+	if err := x.Export(ctx, &rm); nil != err {
+		t.Fatal(err)
+	}
+	spans := recorder.Ended()
+	if 1 != len(spans) || "graphite.flush" != spans[0].Name() {
+		t.Fatal("expected a graphite.flush span:", spans)
+	}
+	attrs := spans[0].Attributes()
+	for _, kv := range []attribute.KeyValue{
+		attribute.String("graphite.endpoint", "graphite.writerSink"),
+		attribute.Int("graphite.datapoints", 1),
+	} {
+		found := false
+		for _, a := range attrs {
+			found = found || a == kv
+		}
+		if !found {
+			t.Errorf("expected %v in %v", kv, attrs)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package otelgraphite
+
+import (
+	"context"
+
+	"github.com/dt/go-metrics-graphite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of NewTracer.
+const tracerName = "github.com/dt/go-metrics-graphite"
+
+// NewTracer returns a graphite.Tracer which wraps every flush in a
+// "graphite.flush" span of a tracer of tp, so that the time spent shipping
+// metrics, such as while a service shuts down, shows up in its traces:
+//
+//	c.Tracer = otelgraphite.NewTracer(otel.GetTracerProvider())
+//
+// Spans are attributed with the endpoint the flush is sent to and, once it
+// was sent, its count of datapoints and bytes, and record its error if it
+// failed. Dials and writes within the flush are children of its span.
+func NewTracer(tp trace.TracerProvider) graphite.Tracer {
+	return tracer{tp.Tracer(tracerName)}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) StartFlush(ctx context.Context, endpoint string) (context.Context, func(graphite.FlushStats)) {
+	ctx, span := t.t.Start(ctx, "graphite.flush",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("graphite.endpoint", endpoint)))
+	return ctx, func(s graphite.FlushStats) {
+		span.SetAttributes(
+			attribute.Int("graphite.datapoints", s.Datapoints),
+			attribute.Int("graphite.bytes", s.Bytes))
+		if nil != s.Err {
+			span.RecordError(s.Err)
+			span.SetStatus(codes.Error, s.Err.Error())
+		}
+		span.End()
+	}
+}
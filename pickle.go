@@ -0,0 +1,65 @@
+package graphite
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Opcodes of the pickle protocol, version 2.
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleLong1      = 0x8a
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// A pickleCodec writes the frames of the pickle receiver of carbon: the
+// length of a pickled list of (path, (timestamp, value)) tuples as four
+// bytes in network order, followed by it. Tagged series are named by their
+// path with its tags, which carbon parses like those of plaintext lines.
+type pickleCodec struct{}
+
+func (pickleCodec) Encode(w io.Writer, points []Point) {
+	b := append(make([]byte, 4, 64*len(points)), pickleProto, 2, pickleEmptyList, pickleMark)
+	n := 0
+	for i := range points {
+		v, err := strconv.ParseFloat(points[i].Value, 64)
+		if nil != err {
+			continue
+		}
+		b = appendPickleString(b, points[i].Path+points[i].Tags)
+		b = appendPickleInt(b, points[i].Time)
+		b = append(b, pickleBinFloat)
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+		b = append(b, pickleTuple2, pickleTuple2)
+		n++
+	}
+	if 0 == n {
+		return
+	}
+	b = append(b, pickleAppends, pickleStop)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	w.Write(b)
+}
+
+func appendPickleString(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(append(b, pickleBinUnicode), uint32(len(s)))
+	return append(b, s...)
+}
+
+// appendPickleInt appends v to b as a four byte integer if it fits into
+// one, and as a long of eight bytes otherwise.
+func appendPickleInt(b []byte, v int64) []byte {
+	if math.MinInt32 <= v && v <= math.MaxInt32 {
+		return binary.LittleEndian.AppendUint32(append(b, pickleBinInt), uint32(int32(v)))
+	}
+	return binary.LittleEndian.AppendUint64(append(b, pickleLong1, 8), uint64(v))
+}
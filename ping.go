@@ -0,0 +1,63 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ping checks that the flushes of c can reach their destination, so that
+// services can fail fast at startup when their metrics egress is
+// misconfigured, see Exporter.Ping.
+func Ping(c GraphiteConfig) error {
+	return PingContext(context.Background(), c)
+}
+
+// PingContext is like Ping, but gives up connecting to and writing to the
+// server when ctx is done.
+func PingContext(ctx context.Context, c GraphiteConfig) error {
+	e := NewExporter(c)
+	defer e.closeConns()
+	return e.Ping(ctx)
+}
+
+// Ping validates the configuration of e, then opens its Sink or connects to
+// its server, or to every one of Destinations, returning the first error
+// along with the destination which failed. Errors connecting and writing are
+// of the kinds ErrDial and ErrWrite, like those of flushes. With
+// PingDatapoint, it also writes the series "exporter.ping" under Prefix with
+// the value 1. Over HTTP, which has no connection to check on its own, the
+// series is always POSTed. Datagram networks, such as that of statsd, cannot
+// tell an unreachable server without an ICMP error.
+func (e *Exporter) Ping(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sending.Wait()
+	c := &e.config
+	if err := c.Validate(); nil != err {
+		return err
+	}
+	shards := e.shards.shards
+	if nil == e.shards.ring {
+		shards = shards[:1] // Every connection goes to the same server
+	}
+	e.shards.reset(c.batchSize())
+	defer e.shards.reset(c.batchSize())
+	if c.PingDatapoint || nil == c.Sink && c.overHTTP() {
+		enc := newEncoder(&e.shards, c)
+		dps := []datapoint{{prefix: c.Prefix, name: "exporter", field: fieldCustom, kind: kindCustom, key: "ping", fvalue: 1}}
+		for i := range shards {
+			e.shards.cur = &shards[i].payload
+			enc.encode(dps, c.clock().Now().Unix())
+		}
+	}
+	for i := range shards {
+		if err := e.send(ctx, &shards[i]); nil != err {
+			dest := shards[i].addr
+			if "" == dest {
+				dest = c.endpoint()
+			}
+			return fmt.Errorf("graphite: ping %s: %w", dest, err)
+		}
+	}
+	return nil
+}
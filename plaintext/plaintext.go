@@ -0,0 +1,174 @@
+// Package plaintext parses Graphite's plaintext protocol, the lines
+// "name value timestamp" which carbon receives and the exporters of
+// go-metrics-graphite send, such as to assert what a test server received or
+// to build relays and filters in front of carbon:
+//
+//	r := plaintext.NewReader(conn)
+//	for {
+//		p, err := r.Read()
+//		var se *plaintext.SyntaxError
+//		if errors.As(err, &se) {
+//			log.Print(err) // A malformed line, the next one is read by the next call
+//			continue
+//		}
+//		if nil != err {
+//			break // io.EOF, or the connection failed
+//		}
+//		if strings.HasPrefix(p.Name, "app.") {
+//			fmt.Fprintln(carbon, p)
+//		}
+//	}
+//
+// Names may carry Graphite tags, such as "app.requests;method=GET", which
+// are parsed into the Tags of their points.
+package plaintext
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Point is the value of a series at a time, one line of the plaintext
+// protocol.
+type Point struct {
+	Name  string            // Dotted name of the series, without its tags
+	Tags  map[string]string // Graphite tags of the series, nil if it has none
+	Value float64           // Value of the series, which may be NaN or infinite
+	Time  int64             // Unix timestamp in seconds
+}
+
+// Series returns the name of the series of p with its tags, sorted by key,
+// the way Graphite identifies it, such as "app.requests;method=GET".
+func (p Point) Series() string {
+	if 0 == len(p.Tags) {
+		return p.Name
+	}
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(p.Name)
+	for _, k := range keys {
+		b.WriteByte(';')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(p.Tags[k])
+	}
+	return b.String()
+}
+
+// String returns p as a plaintext line without its newline.
+func (p Point) String() string {
+	return p.Series() + " " + strconv.FormatFloat(p.Value, 'f', -1, 64) + " " + strconv.FormatInt(p.Time, 10)
+}
+
+// A SyntaxError reports a line which is not in the plaintext protocol.
+type SyntaxError struct {
+	Line int    // Number of the line read by a Reader, from 1; zero for ParseLine
+	Text string // Line without its newline
+	Msg  string // What is wrong with it
+}
+
+func (e *SyntaxError) Error() string {
+	if 0 == e.Line {
+		return fmt.Sprintf("plaintext: %s in %q", e.Msg, e.Text)
+	}
+	return fmt.Sprintf("plaintext: line %d: %s in %q", e.Line, e.Msg, e.Text)
+}
+
+// ParseLine returns the point of a plaintext line, with or without its
+// newline. Like carbon, it accepts fields separated by any run of white
+// space and timestamps with fractions of seconds, which are truncated. It
+// returns a *SyntaxError for anything else.
+func ParseLine(line string) (Point, error) {
+	line = strings.TrimRight(line, "\r\n")
+	fields := strings.Fields(line)
+	if 3 != len(fields) {
+		return Point{}, &SyntaxError{Text: line, Msg: fmt.Sprintf("%d fields instead of 3", len(fields))}
+	}
+	var p Point
+	var err error
+	if p.Value, err = strconv.ParseFloat(fields[1], 64); nil != err {
+		return Point{}, &SyntaxError{Text: line, Msg: fmt.Sprintf("bad value %q", fields[1])}
+	}
+	if p.Time, err = strconv.ParseInt(fields[2], 10, 64); nil != err {
+		ts, ferr := strconv.ParseFloat(fields[2], 64)
+		if nil != ferr || math.IsNaN(ts) || math.IsInf(ts, 0) {
+			return Point{}, &SyntaxError{Text: line, Msg: fmt.Sprintf("bad timestamp %q", fields[2])}
+		}
+		p.Time = int64(ts)
+	}
+	name := fields[0]
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		p.Tags = make(map[string]string)
+		for _, tag := range strings.Split(name[i+1:], ";") {
+			k, v, ok := strings.Cut(tag, "=")
+			if !ok || "" == k || "" == v {
+				return Point{}, &SyntaxError{Text: line, Msg: fmt.Sprintf("bad tag %q", tag)}
+			}
+			p.Tags[k] = v
+		}
+		name = name[:i]
+	}
+	if "" == name {
+		return Point{}, &SyntaxError{Text: line, Msg: "empty name"}
+	}
+	p.Name = name
+	return p, nil
+}
+
+// A Reader reads the points of a stream of plaintext lines, such as a
+// connection from an exporter, skipping blank lines.
+type Reader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the point of the next line, a *SyntaxError if it is
+// malformed, after which Read goes on with the line following it, or io.EOF
+// once the stream ended. A last line without a newline is read as well.
+func (r *Reader) Read() (Point, error) {
+	for {
+		line, err := r.r.ReadString('\n')
+		if "" == line && nil != err {
+			return Point{}, err
+		}
+		r.line++
+		if "" == strings.TrimSpace(line) {
+			continue
+		}
+		p, perr := ParseLine(line)
+		if se, ok := perr.(*SyntaxError); ok {
+			se.Line = r.line
+		}
+		return p, perr
+	}
+}
+
+// ReadAll returns the points of the remaining lines, stopping at the first
+// error other than io.EOF.
+func (r *Reader) ReadAll() ([]Point, error) {
+	var ps []Point
+	for {
+		p, err := r.Read()
+		if io.EOF == err {
+			return ps, nil
+		}
+		if nil != err {
+			return ps, err
+		}
+		ps = append(ps, p)
+	}
+}
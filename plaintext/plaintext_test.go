@@ -0,0 +1,109 @@
+package plaintext
+
+import (
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dt/go-metrics"
+	"github.com/dt/go-metrics-graphite"
+)
+
+func TestParseLine(t *testing.T) {
+	for line, expected := range map[string]Point{
+		"app.requests.count 3 1700000000\n":           {Name: "app.requests.count", Value: 3, Time: 1700000000},
+		"app.load  0.25\t1700000000.9\r\n":            {Name: "app.load", Value: 0.25, Time: 1700000000},
+		"app.requests;method=GET;code=200 -1.5e3 100": {Name: "app.requests", Tags: map[string]string{"code": "200", "method": "GET"}, Value: -1500, Time: 100},
+	} {
+		p, err := ParseLine(line)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, p) {
+			t.Errorf("%q parsed as %+v, not %+v", line, p, expected)
+		}
+	}
+	if p, err := ParseLine("app.gauge NaN 1"); nil != err || !math.IsNaN(p.Value) {
+		t.Error("NaN not parsed:", p, err)
+	}
+	for _, line := range []string{
+		"app.requests.count 3",
+		"app.requests.count three 1",
+		"app.requests.count 3 now",
+		"app.requests;method 3 1",
+		";method=GET 3 1",
+	} {
+		var se *SyntaxError
+		if _, err := ParseLine(line); !errors.As(err, &se) || line != se.Text {
+			t.Errorf("%q not reported: %v", line, err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader("a 1 10\n\nb x 10\nc 3 10"))
+	if p, err := r.Read(); nil != err || "a" != p.Name {
+		t.Fatal("bad first point:", p, err)
+	}
+	var se *SyntaxError
+	if _, err := r.Read(); !errors.As(err, &se) || 3 != se.Line {
+		t.Fatal("bad line not reported:", err)
+	}
+	if p, err := r.Read(); nil != err || "c" != p.Name || 3 != p.Value {
+		t.Fatal("last line without newline not read:", p, err)
+	}
+	if _, err := r.Read(); io.EOF != err {
+		t.Fatal("end of stream not reported:", err)
+	}
+
+	p := Point{Name: "app.requests", Tags: map[string]string{"method": "GET", "code": "200"}, Value: 0.5, Time: 10}
+	if expected, found := "app.requests;code=200;method=GET 0.5 10", p.String(); expected != found {
+		t.Errorf("%q, not %q", found, expected)
+	}
+}
+
+// TestRoundTrip parses back what an exporter sends.
+func TestRoundTrip(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests", r).Inc(3)
+	metrics.GetOrRegisterGaugeFloat64("load", r).Update(0.5)
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Millisecond)
+
+	var b strings.Builder
+	err := graphite.GraphiteOnce(graphite.GraphiteConfig{
+		Registry:     r,
+		Prefix:       "app",
+		Sink:         graphite.WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		DurationUnit: time.Millisecond,
+		Percentiles:  []float64{0.5},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	ps, err := NewReader(strings.NewReader(b.String())).ReadAll()
+	if nil != err {
+		t.Fatal(err)
+	}
+	found := make(map[string]float64)
+	for _, p := range ps {
+		if 1 != p.Time {
+			t.Errorf("bad timestamp of %v", p)
+		}
+		found[p.Series()] = p.Value
+	}
+	for name, expected := range map[string]float64{
+		"app.requests.count": 3,
+		"app.load.value":     0.5,
+		"app.latency.max":    2,
+		"app.latency.count":  1,
+	} {
+		if v, ok := found[name]; !ok || expected != v {
+			t.Errorf("%s is %v, not %v, in %q", name, v, expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package graphite
+
+import "sync"
+
+// Pools of the buffers of flushes, the datapoints of their snapshots and the
+// payloads of their shards, so that the exporters GraphiteOnce makes for
+// every flush reuse the memory of the previous ones instead of growing the
+// heap. Exporters which flush repeatedly keep their own buffers.
+var (
+	datapointPool sync.Pool // *[]datapoint
+	bytePool      sync.Pool // *[]byte
+)
+
+// pooledDatapoints returns an empty slice of datapoints from the pool, nil
+// if it has none.
+func pooledDatapoints() []datapoint {
+	if p, ok := datapointPool.Get().(*[]datapoint); ok {
+		return (*p)[:0]
+	}
+	return nil
+}
+
+// pooledBytes returns an empty buffer from the pool, nil if it has none.
+func pooledBytes() []byte {
+	if p, ok := bytePool.Get().(*[]byte); ok {
+		return (*p)[:0]
+	}
+	return nil
+}
+
+// release returns the buffers of e to the pools once its flush was sent.
+// The datapoints are zeroed first, so that the pool does not keep the tags
+// and names of their metrics alive.
+func (e *Exporter) release() {
+	if nil != e.snapshot.dps {
+		dps := e.snapshot.dps[:0]
+		clear(dps[:cap(dps)])
+		datapointPool.Put(&dps)
+		e.snapshot.dps = nil
+	}
+	for i := range e.shards.shards {
+		s := &e.shards.shards[i]
+		if nil != s.payload.buf {
+			buf := s.payload.buf[:0]
+			bytePool.Put(&buf)
+			s.payload.buf = nil
+		}
+		s.bufs = nil
+	}
+}
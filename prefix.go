@@ -0,0 +1,76 @@
+package graphite
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// expandPrefix expands the placeholders in prefix, escaping it like the
+// names of metrics, and, for protocols which aren't sent over HTTP, prepends
+// APIKey as the first path component.
+func (c *GraphiteConfig) expandPrefix(prefix string) string {
+	prefix = escapeName(expandPrefix(prefix))
+	if "" == c.APIKey || c.overHTTP() {
+		return prefix
+	}
+	if "" == prefix {
+		return c.APIKey
+	}
+	return c.APIKey + "." + prefix
+}
+
+// expandPrefix replaces the placeholders %h (short hostname), %f (fully
+// qualified hostname), %p (process id) and %% (a literal percent sign) in
+// prefix. Dots within hostnames are replaced with underscores so a hostname
+// always forms a single path component.
+func expandPrefix(prefix string) string {
+	if !strings.Contains(prefix, "%") {
+		return prefix
+	}
+	var b strings.Builder
+	for i := 0; i < len(prefix); i++ {
+		if '%' != prefix[i] || i+1 == len(prefix) {
+			b.WriteByte(prefix[i])
+			continue
+		}
+		i++
+		switch prefix[i] {
+		case 'h':
+			host := hostname()
+			if dot := strings.IndexByte(host, '.'); dot >= 0 {
+				host = host[:dot]
+			}
+			b.WriteString(host)
+		case 'f':
+			b.WriteString(strings.Replace(fqdn(), ".", "_", -1))
+		case 'p':
+			b.WriteString(strconv.Itoa(os.Getpid()))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(prefix[i])
+		}
+	}
+	return b.String()
+}
+
+func hostname() string {
+	host, err := os.Hostname()
+	if nil != err || "" == host {
+		return "unknown"
+	}
+	return host
+}
+
+// fqdn returns the canonical name of this host, falling back to the name
+// reported by the kernel when it cannot be resolved.
+func fqdn() string {
+	host := hostname()
+	if cname, err := net.LookupCNAME(host); nil == err && "" != cname {
+		return strings.TrimSuffix(cname, ".")
+	}
+	return host
+}
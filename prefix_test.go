@@ -0,0 +1,31 @@
+package graphite
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExpandPrefix(t *testing.T) {
+	host, _ := os.Hostname()
+	short := strings.SplitN(host, ".", 2)[0]
+	pid := strconv.Itoa(os.Getpid())
+
+	for in, expected := range map[string]string{
+		"servers.app":       "servers.app",
+		"servers.%h.app":    "servers." + short + ".app",
+		"app.%p":            "app." + pid,
+		"app.100%%":         "app.100%",
+		"app.%x.%":          "app.%x.%",
+		"servers.%h.%p.app": "servers." + short + "." + pid + ".app",
+	} {
+		if found := expandPrefix(in); found != expected {
+			t.Errorf("expandPrefix(%q): expected %q, found %q", in, expected, found)
+		}
+	}
+
+	if found := expandPrefix("%f"); "" == found || strings.Contains(found, ".") {
+		t.Errorf("bad fqdn: %q", found)
+	}
+}
@@ -0,0 +1,100 @@
+package graphite
+
+import "sort"
+
+// Tiers of the priorities returned by a PriorityFunc. Any other values rank
+// between and around them.
+const (
+	PriorityBestEffort = -100 // Debug metrics, dropped before any other
+	PriorityDefault    = 0    // Metrics no PriorityRule matches
+	PriorityCritical   = 100  // SLO metrics, at this or a higher priority never dropped by MaxDatapointsPerSecond, MaxSeries or ByteQuota
+)
+
+// A PriorityRule gives the metrics it matches a priority, see PriorityRules.
+type PriorityRule struct {
+	Match    func(name string) bool // Whether the rule applies to a metric, by its name within its registry
+	Priority int                    // Priority of matching metrics, such as PriorityCritical
+}
+
+// PriorityRules returns the PriorityFunc of rules, whose first rule matching
+// a metric gives its priority, PriorityDefault if none does.
+func PriorityRules(rules ...PriorityRule) PriorityFunc {
+	return func(name string) int {
+		for _, r := range rules {
+			if r.Match(name) {
+				return r.Priority
+			}
+		}
+		return PriorityDefault
+	}
+}
+
+// critical reports whether the named metric is of PriorityCritical or above.
+func (c *GraphiteConfig) critical(name string) bool {
+	return nil != c.Priority && PriorityCritical <= c.Priority(name)
+}
+
+// A priorityGroup is the range of the datapoints of a metric in a snapshot,
+// along with its priority.
+type priorityGroup struct{ start, end, priority int }
+
+// groups returns the ranges of the datapoints of every metric of s, in the
+// descending order of the priorities rank returns for their names, and
+// otherwise in the order they were snapshotted.
+func (s *snapshot) groups(rank PriorityFunc) []priorityGroup {
+	groups := make([]priorityGroup, 0, len(s.ends))
+	start := 0
+	for _, end := range s.ends {
+		if start < end {
+			groups = append(groups, priorityGroup{start, end, rank(s.dps[start].name)})
+		}
+		start = end
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].priority > groups[j].priority })
+	return groups
+}
+
+// byPriority returns a function calling fn with the datapoints of every
+// metric of s, in the order of groups.
+func (s *snapshot) byPriority(rank PriorityFunc) func(func([]datapoint)) {
+	return func(fn func([]datapoint)) {
+		for _, g := range s.groups(rank) {
+			fn(s.dps[g.start:g.end])
+		}
+	}
+}
+
+// throttle drops the datapoints of the snapshot exceeding MaxSeries and
+// MaxDatapointsPerSecond, those of the lowest priorities first, sparing the
+// metrics of PriorityCritical and above. The others are kept in the order
+// they were snapshotted.
+func (e *Exporter) throttle() {
+	s := &e.snapshot
+	dropped := make([]bool, len(s.dps))
+	series := 0
+	for _, g := range s.groups(e.config.Priority) {
+		for i := g.start; i < g.end; i++ {
+			switch {
+			case PriorityCritical <= g.priority:
+			case e.capped && series >= e.config.MaxSeries:
+				e.overflow++
+				dropped[i] = true
+				continue
+			case nil != e.limiter && !e.limiter.allow():
+				dropped[i] = true
+				continue
+			}
+			series++
+		}
+	}
+	dps, start := s.dps[:0], 0
+	for j, end := range s.ends {
+		for i := start; i < end; i++ {
+			if !dropped[i] {
+				dps = append(dps, s.dps[i])
+			}
+		}
+		start, s.ends[j] = end, len(dps)
+	}
+	s.dps = dps
+}
@@ -0,0 +1,37 @@
+package graphite
+
+import "time"
+
+// processStart approximates when the process started, for its uptime.
+var processStart = time.Now()
+
+// A processStats holds the statistics of the process read from the
+// operating system. Fields which could not be read are negative.
+type processStats struct {
+	fds        int64
+	rss, vsz   int64 // Bytes
+	cpuSeconds float64
+}
+
+// snapshotProcess appends gauges of the uptime of the process, along with
+// its open file descriptors, memory and CPU usage where the operating
+// system provides them, to the snapshot under "process".
+func (e *Exporter) snapshotProcess(prefix string, now time.Time) {
+	e.snapshotMetric(prefix, "process.uptime", staticGaugeFloat64(now.Sub(processStart).Seconds()), now)
+	s := readProcessStats()
+	for _, g := range []struct {
+		name  string
+		value int64
+	}{
+		{"process.fds", s.fds},
+		{"process.rss", s.rss},
+		{"process.vsz", s.vsz},
+	} {
+		if 0 <= g.value {
+			e.snapshotMetric(prefix, g.name, staticGauge(g.value), now)
+		}
+	}
+	if 0 <= s.cpuSeconds {
+		e.snapshotMetric(prefix, "process.cpu-seconds", staticGaugeFloat64(s.cpuSeconds), now)
+	}
+}
@@ -0,0 +1,45 @@
+package graphite
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the unit of CPU times in /proc, USER_HZ, which is 100 on
+// all supported architectures.
+const clockTicks = 100
+
+// readProcessStats reads the statistics of the process from /proc.
+func readProcessStats() processStats {
+	s := processStats{fds: -1, rss: -1, vsz: -1, cpuSeconds: -1}
+	if fds, err := os.ReadDir("/proc/self/fd"); nil == err {
+		s.fds = int64(len(fds))
+	}
+	stat, err := os.ReadFile("/proc/self/stat")
+	if nil != err {
+		return s
+	}
+	// Fields follow the command name, which is in parentheses and may
+	// contain spaces, starting with the state, the third field.
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return s
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 22 {
+		return s
+	}
+	utime, err1 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err2 := strconv.ParseInt(fields[12], 10, 64)
+	if nil == err1 && nil == err2 {
+		s.cpuSeconds = float64(utime+stime) / clockTicks
+	}
+	if vsz, err := strconv.ParseInt(fields[20], 10, 64); nil == err {
+		s.vsz = vsz
+	}
+	if rss, err := strconv.ParseInt(fields[21], 10, 64); nil == err {
+		s.rss = rss * int64(os.Getpagesize())
+	}
+	return s
+}
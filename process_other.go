@@ -0,0 +1,9 @@
+//go:build !linux
+
+package graphite
+
+// readProcessStats returns no statistics, as they are only read from /proc
+// on Linux.
+func readProcessStats() processStats {
+	return processStats{fds: -1, rss: -1, vsz: -1, cpuSeconds: -1}
+}
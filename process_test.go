@@ -0,0 +1,26 @@
+package graphite
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestProcessMetrics(t *testing.T) {
+	res, l, _, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	c.ProcessMetrics = true
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	names := []string{"foobar.process.uptime.value"}
+	if "linux" == runtime.GOOS {
+		names = append(names, "foobar.process.fds.value", "foobar.process.rss.value", "foobar.process.vsz.value")
+	}
+	for _, name := range names {
+		if found := res[name]; 0 >= found {
+			t.Error("bad value:", name, found)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package promgraphite exports the metrics of Prometheus client_golang
+// registries through a graphite.Exporter, so that codebases instrumented
+// with both go-metrics and Prometheus can ship everything to Graphite with
+// one exporter.
+//
+// The metric families gathered by every flush are exported under their
+// names, followed by the names and values of their labels in the order
+// Prometheus sorts them:
+//
+//	http_requests_total{code="200",method="get"} 3
+//
+// is exported as http_requests_total.code.200.method.get.count. Counters
+// end in "count", gauges and untyped metrics in "value". Summaries and
+// histograms export their "count" and "sum", and respectively their
+// quantiles as p50, p99_9 and so on, and their cumulative buckets as
+// bucket.le_0_5, bucket.le_inf and so on.
+package promgraphite
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dt/go-metrics"
+	"github.com/dt/go-metrics-graphite"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A point is one series of a sample, named by its suffix.
+type point struct {
+	suffix string
+	value  float64
+}
+
+// A sample holds the series of a metric of a family with one set of labels
+// as gathered by a flush.
+type sample struct {
+	points []point
+}
+
+func init() {
+	graphite.RegisterEncoder(&sample{}, func(i interface{}, emit func(string, float64)) {
+		for _, p := range i.(*sample).points {
+			emit(p.suffix, p.value)
+		}
+	})
+}
+
+// A registry presents the metrics of a Gatherer as those of a
+// metrics.Registry, in addition to any metrics registered with it.
+type registry struct {
+	metrics.Registry
+	g prometheus.Gatherer
+}
+
+// NewRegistry returns a registry which gathers the metrics of g whenever it
+// is iterated, such as by every flush of an exporter it is the Registry of
+// or one of the Registries of. Metrics may be registered with it as with
+// metrics.NewRegistry, and are exported along with those of g.
+//
+// Errors gathering metrics are logged, and the families which could be
+// gathered are exported regardless.
+func NewRegistry(g prometheus.Gatherer) metrics.Registry {
+	return &registry{Registry: metrics.NewRegistry(), g: g}
+}
+
+func (r *registry) Each(fn func(string, interface{})) {
+	families, err := r.g.Gather()
+	if nil != err {
+		log.Println("promgraphite:", err)
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if s := newSample(mf.GetType(), m); nil != s {
+				fn(name(mf.GetName(), m.GetLabel()), s)
+			}
+		}
+	}
+	r.Registry.Each(fn)
+}
+
+var escaper = strings.NewReplacer(".", "_", " ", "_", "\n", "_")
+
+// name returns the name a metric of the named family is exported under,
+// with its labels.
+func name(family string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(family)
+	for _, l := range labels {
+		b.WriteByte('.')
+		b.WriteString(escaper.Replace(l.GetName()))
+		b.WriteByte('.')
+		b.WriteString(escaper.Replace(l.GetValue()))
+	}
+	return b.String()
+}
+
+// newSample returns the series of m, a metric of a family of type t, or nil
+// if t is not supported.
+func newSample(t dto.MetricType, m *dto.Metric) *sample {
+	s := &sample{}
+	add := func(suffix string, value float64) {
+		s.points = append(s.points, point{suffix, value})
+	}
+	switch t {
+	case dto.MetricType_COUNTER:
+		add("count", m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		add("value", m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		add("value", m.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		sm := m.GetSummary()
+		add("count", float64(sm.GetSampleCount()))
+		add("sum", sm.GetSampleSum())
+		for _, q := range sm.GetQuantile() {
+			add("p"+key(q.GetQuantile()*100), q.GetValue())
+		}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		add("count", float64(h.GetSampleCount()))
+		add("sum", h.GetSampleSum())
+		inf := false
+		for _, b := range h.GetBucket() {
+			inf = math.IsInf(b.GetUpperBound(), 1)
+			add("bucket.le_"+key(b.GetUpperBound()), float64(b.GetCumulativeCount()))
+		}
+		if !inf {
+			add("bucket.le_inf", float64(h.GetSampleCount()))
+		}
+	default:
+		return nil
+	}
+	return s
+}
+
+// key formats v as a path component, with an underscore for the decimal
+// point.
+func key(v float64) string {
+	if math.IsInf(v, 1) {
+		return "inf"
+	}
+	return strings.Replace(strconv.FormatFloat(v, 'f', -1, 64), ".", "_", 1)
+}
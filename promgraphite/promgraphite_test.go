@@ -0,0 +1,46 @@
+package promgraphite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dt/go-metrics-graphite"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegistry(t *testing.T) {
+	pr := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"method", "code"})
+	queue := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_length"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Buckets: []float64{0.5, 1}})
+	pr.MustRegister(requests, queue, latency)
+	requests.WithLabelValues("get", "200").Add(3)
+	queue.Set(7)
+	latency.Observe(0.25)
+	latency.Observe(2)
+
+	var b strings.Builder
+	err := graphite.GraphiteOnce(graphite.GraphiteConfig{
+		Registry:     NewRegistry(pr),
+		Prefix:       "app",
+		Sink:         graphite.WriterSink(&b),
+		SortedOutput: true,
+		Timestamp:    func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"app.requests_total.code.200.method.get.count 3.000000 1\n",
+		"app.queue_length.value 7.000000 1\n",
+		"app.latency_seconds.count 2.000000 1\n",
+		"app.latency_seconds.sum 2.250000 1\n",
+		"app.latency_seconds.bucket.le_0_5 1.000000 1\n",
+		"app.latency_seconds.bucket.le_1 1.000000 1\n",
+		"app.latency_seconds.bucket.le_inf 2.000000 1\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected %q in %q", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// A Dialer makes the connections to Graphite instead of net.Dial. It is
+// satisfied by golang.org/x/net/proxy.Dialer.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// dialer returns the Dialer connecting to Graphite, or nil to dial
+// directly.
+func (c *GraphiteConfig) dialer() (Dialer, error) {
+	if nil != c.Dialer {
+		return c.Dialer, nil
+	}
+	if "" == c.ProxyURL {
+		return nil, nil
+	}
+	u, err := url.Parse(c.ProxyURL)
+	if nil != err {
+		return nil, err
+	}
+	p := &proxyDialer{url: u, forward: &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}, timeout: c.DialTimeout}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		p.handshake = socks5Connect
+	case "http":
+		p.handshake = httpConnect
+	default:
+		return nil, fmt.Errorf("graphite: unsupported proxy scheme %q", u.Scheme)
+	}
+	return p, nil
+}
+
+// A proxyDialer connects to addresses through the proxy at url.
+type proxyDialer struct {
+	url       *url.URL
+	forward   Dialer
+	handshake func(conn net.Conn, u *url.URL, addr string) error
+	timeout   time.Duration // Limit of the handshake, zero waits forever
+}
+
+func (p *proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the proxy, giving up when ctx is
+// done.
+func (p *proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if "tcp" != network {
+		return nil, fmt.Errorf("graphite: proxy %s cannot dial %s", p.url.Host, network)
+	}
+	conn, err := dialContext(ctx, p.forward, "tcp", p.url.Host)
+	if nil != err {
+		return nil, err
+	}
+	if 0 < p.timeout {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	if err := p.handshake(conn, p.url, addr); nil != err {
+		conn.Close()
+		if nil != ctx.Err() {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// A contextDialer is a Dialer which can also dial with a context, such as
+// net.Dialer or a golang.org/x/net/proxy.ContextDialer.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// dialContext dials addr with d, giving up when ctx is done if d supports
+// it.
+func dialContext(ctx context.Context, d Dialer, network, addr string) (net.Conn, error) {
+	if cd, ok := d.(contextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return d.Dial(network, addr)
+}
+
+// socks5Connect asks the SOCKS5 proxy on conn to connect to addr, as
+// described by RFC 1928 and RFC 1929.
+func socks5Connect(conn net.Conn, u *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if nil != err {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if nil != err {
+		return err
+	}
+	methods := []byte{0}
+	if nil != u.User {
+		methods = append(methods, 2)
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); nil != err {
+		return err
+	}
+	var b [4]byte
+	if _, err := io.ReadFull(conn, b[:2]); nil != err {
+		return err
+	}
+	switch {
+	case 5 != b[0]:
+		return errors.New("graphite: bad SOCKS5 proxy version")
+	case 2 == b[1] && nil != u.User:
+		user := u.User.Username()
+		pass, _ := u.User.Password()
+		req := append([]byte{1, byte(len(user))}, user...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err := conn.Write(req); nil != err {
+			return err
+		}
+		if _, err := io.ReadFull(conn, b[:2]); nil != err {
+			return err
+		}
+		if 0 != b[1] {
+			return errors.New("graphite: SOCKS5 proxy authentication failed")
+		}
+	case 0 != b[1]:
+		return errors.New("graphite: no acceptable SOCKS5 authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); nil == ip {
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); nil != ip4 {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); nil != err {
+		return err
+	}
+	if _, err := io.ReadFull(conn, b[:4]); nil != err {
+		return err
+	}
+	if 0 != b[1] {
+		return fmt.Errorf("graphite: SOCKS5 proxy refused connection to %s: code %d", addr, b[1])
+	}
+	var skip int
+	switch b[3] {
+	case 1:
+		skip = net.IPv4len
+	case 4:
+		skip = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(conn, b[:1]); nil != err {
+			return err
+		}
+		skip = int(b[0])
+	default:
+		return errors.New("graphite: bad SOCKS5 bound address")
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+// httpConnect asks the HTTP proxy on conn to tunnel to addr.
+func httpConnect(conn net.Conn, u *url.URL, addr string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if nil != u.User {
+		pass, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), pass)
+		req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+		delete(req.Header, "Authorization")
+	}
+	if err := req.Write(conn); nil != err {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if nil != err {
+		return err
+	}
+	resp.Body.Close()
+	if http.StatusOK != resp.StatusCode {
+		return fmt.Errorf("graphite: HTTP proxy refused connection to %s: %s", addr, resp.Status)
+	}
+	return nil
+}
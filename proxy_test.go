@@ -0,0 +1,125 @@
+package graphite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// NewTestProxy starts a proxy which handles every connection with serve,
+// tunnelling to the address serve returns.
+func NewTestProxy(t *testing.T, serve func(conn net.Conn, r *bufio.Reader) string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("could not start dummy proxy:", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				upstream, err := net.Dial("tcp", serve(conn, r))
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				io.Copy(upstream, r)
+			}()
+		}
+	}()
+	return ln
+}
+
+func serveSOCKS5(conn net.Conn, r *bufio.Reader) string {
+	b := make([]byte, 262)
+	io.ReadFull(r, b[:2])
+	io.ReadFull(r, b[:b[1]])
+	conn.Write([]byte{5, 0})
+	io.ReadFull(r, b[:4])
+	var host string
+	switch b[3] {
+	case 1:
+		io.ReadFull(r, b[:4])
+		host = net.IP(b[:4]).String()
+	case 3:
+		io.ReadFull(r, b[:1])
+		n := int(b[0])
+		io.ReadFull(r, b[:n])
+		host = string(b[:n])
+	}
+	io.ReadFull(r, b[:2])
+	port := binary.BigEndian.Uint16(b[:2])
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+func serveConnect(conn net.Conn, r *bufio.Reader) string {
+	req, err := http.ReadRequest(r)
+	if err != nil || "CONNECT" != req.Method {
+		return ""
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return req.Host
+}
+
+func TestProxyURL(t *testing.T) {
+	for scheme, serve := range map[string]func(net.Conn, *bufio.Reader) string{
+		"socks5": serveSOCKS5,
+		"http":   serveConnect,
+	} {
+		res, l, r, c, wg := NewTestServer(t, "foobar")
+		p := NewTestProxy(t, serve)
+
+		c.ProxyURL = scheme + "://" + p.Addr().String()
+		metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+		wg.Add(1)
+		if err := GraphiteOnce(c); nil != err {
+			t.Fatal(scheme, err)
+		}
+		wg.Wait()
+
+		if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+			t.Fatal("bad value:", scheme, expected, found)
+		}
+		p.Close()
+		l.Close()
+	}
+}
+
+type countingDialer struct {
+	dials int
+}
+
+func (d *countingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials++
+	return net.Dial(network, addr)
+}
+
+func TestDialer(t *testing.T) {
+	_, l, _, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	d := &countingDialer{}
+	c.Dialer = d
+
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if 1 != d.dials {
+		t.Fatal("dialer not used")
+	}
+}
@@ -0,0 +1,34 @@
+package graphite
+
+// A QuantileEstimator computes the percentiles of histograms and timers
+// instead of the samples they keep, such as from a t-digest or an HDR
+// histogram recording the same values, for accurate tail latencies when the
+// metrics are updated too often for a sample to hold the tails. Quantiles
+// is passed the prefix and name of the metric, the metric itself, or its
+// snapshot with PointInTime, and the percentiles between 0 and 1 to
+// compute. It returns one value per percentile, in the unit the metric is
+// updated in, or false to use the percentiles of the sample instead.
+//
+// With EncodeWorkers, Quantiles is called from several goroutines at once.
+type QuantileEstimator interface {
+	Quantiles(prefix, name string, metric interface{}, qs []float64) ([]float64, bool)
+}
+
+// A QuantileFunc is a QuantileEstimator computing percentiles by calling
+// itself.
+type QuantileFunc func(prefix, name string, metric interface{}, qs []float64) ([]float64, bool)
+
+func (f QuantileFunc) Quantiles(prefix, name string, metric interface{}, qs []float64) ([]float64, bool) {
+	return f(prefix, name, metric, qs)
+}
+
+// percentiles returns the percentiles qs of h, the values of metric i,
+// computed by the QuantileEstimator of c if it estimates them.
+func (c *GraphiteConfig) percentiles(prefix, name string, i interface{}, h histogramValues, qs []float64) []float64 {
+	if nil != c.Quantiles {
+		if ps, ok := c.Quantiles.Quantiles(prefix, name, i, qs); ok && len(ps) == len(qs) {
+			return ps
+		}
+	}
+	return h.Percentiles(qs)
+}
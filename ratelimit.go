@@ -0,0 +1,57 @@
+package graphite
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A rateLimiter is a token bucket holding the datapoints which may still be
+// sent, refilled at MaxDatapointsPerSecond. The bucket holds up to a flush
+// interval worth of datapoints, or a second worth for shorter intervals, so
+// that the limit averages out across flushes.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	dropped uint64 // Accessed atomically
+}
+
+func newRateLimiter(rate int, interval time.Duration) *rateLimiter {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	burst := float64(rate) * interval.Seconds()
+	return &rateLimiter{rate: float64(rate), burst: burst, tokens: burst}
+}
+
+// refill adds the tokens accumulated since the previous flush at now.
+func (l *rateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// allow takes a token for a datapoint, counting it as dropped if there is
+// none left.
+func (l *rateLimiter) allow() bool {
+	if l.tokens < 1 {
+		atomic.AddUint64(&l.dropped, 1)
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Dropped returns how many datapoints have been dropped because they
+// exceeded MaxDatapointsPerSecond.
+func (e *Exporter) Dropped() uint64 {
+	if nil == e.limiter {
+		return 0
+	}
+	return atomic.LoadUint64(&e.limiter.dropped)
+}
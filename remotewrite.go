@@ -0,0 +1,145 @@
+package graphite
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// A remoteWriteEncoder writes datapoints as the TimeSeries of a Prometheus
+// remote write request. Counters are named <name>_total, percentiles become
+// the quantiles of a summary named after the metric and the remaining
+// series are named <name>_<suffix>, with characters which are invalid in
+// Prometheus names replaced by underscores.
+type remoteWriteEncoder struct {
+	w    io.Writer
+	tags []label
+	namer
+	labels      []label
+	series, msg []byte
+}
+
+type label struct {
+	name, value string
+}
+
+func newRemoteWriteEncoder(w io.Writer, c *GraphiteConfig) *remoteWriteEncoder {
+	enc := &remoteWriteEncoder{w: w, namer: newNamer(c)}
+	for k, v := range c.Tags {
+		enc.tags = append(enc.tags, label{promName(k), v})
+	}
+	return enc
+}
+
+func (enc *remoteWriteEncoder) encode(dps []datapoint, now int64) {
+	for i := range dps {
+		dp := &dps[i]
+		enc.labels = append(enc.labels[:0], enc.tags...)
+		if nil != dp.tags {
+			enc.addTags(dp.tags)
+		}
+		name := promName(enc.fullName(dp))
+		switch {
+		case fieldCounter == dp.field:
+			name += "_total"
+		case fieldPercentile == dp.field:
+			enc.labels = append(enc.labels, label{"quantile", strconv.FormatFloat(dp.quantile, 'f', -1, 64)})
+		case kindGauge == dp.kind, kindGaugeFloat64 == dp.kind:
+		default:
+			name += "_" + promName(enc.suffix(dp))
+		}
+		enc.labels = append(enc.labels, label{"__name__", name})
+		sort.Slice(enc.labels, func(i, j int) bool { return enc.labels[i].name < enc.labels[j].name })
+
+		value := dp.fvalue
+		if dp.field.integer() {
+			value = float64(dp.ivalue)
+		}
+		s := enc.series[:0]
+		for _, l := range enc.labels {
+			m := appendProtoString(enc.msg[:0], 1, l.name)
+			m = appendProtoString(m, 2, l.value)
+			s = appendProtoBytes(s, 1, m)
+			enc.msg = m
+		}
+		m := appendProtoTag(enc.msg[:0], 1, 1)
+		m = binary.LittleEndian.AppendUint64(m, math.Float64bits(value))
+		m = appendProtoTag(m, 2, 0)
+		m = binary.AppendUvarint(m, uint64(now*1000))
+		s = appendProtoBytes(s, 2, m)
+		enc.msg = m
+
+		enc.w.Write(appendProtoBytes(enc.msg[:0], 1, s))
+		enc.series = s
+	}
+}
+
+// promName replaces the characters of name which are not allowed in
+// Prometheus metric and label names with underscores.
+func promName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '_' == c || ':' == c || 0 < i && '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func appendProtoTag(b []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = appendProtoTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoString(b []byte, field int, v string) []byte {
+	b = appendProtoTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// snappyEncode compresses src in the snappy block format. Only literals
+// are emitted, which every decoder accepts; the payload is not compressed
+// but remains valid for endpoints which require snappy.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/65536*3+16), uint64(len(src)))
+	for 0 < len(src) {
+		n := len(src)
+		if 65536 < n {
+			n = 65536
+		}
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 256:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+// addTags adds the tags of a metric to the labels of the series being
+// encoded, overriding the labels of Tags with the same names.
+func (enc *remoteWriteEncoder) addTags(s *tagSet) {
+	for _, t := range s.tags {
+		l, found := label{promName(t.key), t.value}, false
+		for i := range enc.labels {
+			if l.name == enc.labels[i].name {
+				enc.labels[i], found = l, true
+			}
+		}
+		if !found {
+			enc.labels = append(enc.labels, l)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// snappyDecode decodes snappy blocks consisting only of literals, as
+// produced by snappyEncode.
+func snappyDecode(t *testing.T, src []byte) []byte {
+	n, l := binary.Uvarint(src)
+	src = src[l:]
+	var dst []byte
+	for 0 < len(src) {
+		tag := src[0]
+		if 0 != tag&3 {
+			t.Fatal("unexpected snappy copy")
+		}
+		length := int(tag>>2) + 1
+		src = src[1:]
+		switch tag >> 2 {
+		case 60:
+			length, src = int(src[0])+1, src[1:]
+		case 61:
+			length, src = int(src[0])|int(src[1])<<8+1, src[2:]
+		}
+		dst, src = append(dst, src[:length]...), src[length:]
+	}
+	if uint64(len(dst)) != n {
+		t.Fatal("bad snappy length:", n, len(dst))
+	}
+	return dst
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 256, 257, 65536, 200000} {
+		src := bytes.Repeat([]byte{'x'}, n)
+		if found := snappyDecode(t, snappyEncode(src)); !bytes.Equal(src, found) {
+			t.Fatal("bad round trip of", n, "bytes")
+		}
+	}
+}
+
+func TestRemoteWriteEncoder(t *testing.T) {
+	var b bytes.Buffer
+	newEncoder(&b, &GraphiteConfig{Protocol: ProtocolRemoteWrite}).encode([]datapoint{
+		{prefix: "app", name: "temp", field: fieldGaugeFloat64, kind: kindGaugeFloat64, fvalue: 1},
+	}, 2)
+
+	expected := []byte{
+		0x0a, 0x24, // timeseries
+		0x0a, 0x14, // labels
+		0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_',
+		0x12, 0x08, 'a', 'p', 'p', '_', 't', 'e', 'm', 'p',
+		0x12, 0x0c, // samples
+		0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // value 1.0
+		0x10, 0xd0, 0x0f, // timestamp 2000ms
+	}
+	if found := b.Bytes(); !bytes.Equal(expected, found) {
+		t.Fatalf("expected %x, found %x", expected, found)
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var body []byte
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "snappy" != r.Header.Get("Content-Encoding") {
+			t.Error("bad content encoding:", r.Header.Get("Content-Encoding"))
+		}
+		if user, _, _ := r.BasicAuth(); "secret" != user {
+			t.Error("bad API key:", user)
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body = snappyDecode(t, compressed)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("hits", r).Inc(3)
+	c := GraphiteConfig{Registry: r, Prefix: "app", Protocol: ProtocolRemoteWrite, URL: ts.URL, APIKey: "secret"}
+
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(body, []byte("app_hits_total")) {
+		t.Fatalf("counter missing from %q", body)
+	}
+
+	status = http.StatusBadRequest
+	if err := GraphiteOnce(c); nil == err {
+		t.Fatal("expected error for rejected request")
+	}
+}
@@ -0,0 +1,179 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A RenderClient reads series back from the render API of graphite-web,
+// such as to confirm in integration tests that the datapoints sent by an
+// Exporter were stored rather than silently dropped by carbon, and posts
+// events to it.
+type RenderClient struct {
+	URL        string       // Base URL of graphite-web, such as "http://graphite:8080"
+	HTTPClient *http.Client // Client making the requests, http.DefaultClient if nil
+	Header     http.Header  // Additional headers of the requests, such as for authentication
+}
+
+// A RenderSeries is a series returned by the render API.
+type RenderSeries struct {
+	Target     string        `json:"target"`
+	Datapoints []RenderPoint `json:"datapoints"`
+}
+
+// A RenderPoint is a datapoint of a RenderSeries.
+type RenderPoint struct {
+	Value *float64 // Value of the datapoint, nil if there is none at Time
+	Time  int64    // Timestamp in seconds since the epoch
+}
+
+// UnmarshalJSON decodes a datapoint in the [value, timestamp] form of the
+// render API.
+func (p *RenderPoint) UnmarshalJSON(b []byte) error {
+	var v [2]*float64
+	if err := json.Unmarshal(b, &v); nil != err {
+		return err
+	}
+	if nil == v[1] {
+		return errors.New("graphite: datapoint without a timestamp")
+	}
+	p.Value, p.Time = v[0], int64(*v[1])
+	return nil
+}
+
+// Render returns the series matched by targets, which may be paths,
+// patterns or functions, between from and until.
+func (c *RenderClient) Render(ctx context.Context, from, until time.Time, targets ...string) ([]RenderSeries, error) {
+	q := url.Values{
+		"format": {"json"},
+		"from":   {strconv.FormatInt(from.Unix(), 10)},
+		"until":  {strconv.FormatInt(until.Unix(), 10)},
+		"target": targets,
+	}
+	resp, err := c.do(ctx, "GET", "/render?"+q.Encode(), nil)
+	if nil != err {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var series []RenderSeries
+	if err := json.NewDecoder(resp.Body).Decode(&series); nil != err {
+		return nil, err
+	}
+	return series, nil
+}
+
+// do sends a request for path, relative to URL, returning an error unless
+// it succeeded.
+func (c *RenderClient) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	u := strings.TrimSuffix(c.URL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if nil != err {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		req.Header[k] = vs
+	}
+	client := c.HTTPClient
+	if nil == client {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("graphite: %s %s failed: %s", method, u, resp.Status)
+	}
+	return resp, nil
+}
+
+// At returns the value of s at ts, that of the last datapoint at or before
+// ts, as Graphite aligns datapoints to the resolution of its storage.
+func (s *RenderSeries) At(ts int64) (float64, bool) {
+	for i := len(s.Datapoints) - 1; i >= 0; i-- {
+		if p := &s.Datapoints[i]; p.Time <= ts {
+			if nil == p.Value {
+				return 0, false
+			}
+			return *p.Value, true
+		}
+	}
+	return 0, false
+}
+
+// verifyBatch is the number of targets requested by each render request of
+// Verify, to keep URLs short.
+const verifyBatch = 50
+
+// Verify reads the series of the last flush back from c, returning an
+// error naming every one which was not stored with the value sent, joined
+// by errors.Join, or the error of the render API. Only series named by
+// dotted paths, those of the plaintext protocol without tags, can be
+// verified; the others are ignored. It is meant to be called between
+// flushes, once carbon had time to write the last one.
+func (e *Exporter) Verify(ctx context.Context, c *RenderClient) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ProtocolPlaintext != e.config.Protocol {
+		return errors.New("graphite: only the plaintext protocol can be verified")
+	}
+	ts := e.lastTS
+	n := newNamer(&e.config)
+	want := make(map[string]float64)
+	var targets []string
+	var line bytes.Buffer
+	for _, dp := range e.snapshot.dps {
+		if nil != dp.tags {
+			continue
+		}
+		line.Reset()
+		n.line(&line, &dp, ts)
+		fields := strings.Fields(line.String())
+		if 3 != len(fields) {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if nil != err || math.IsNaN(v) {
+			continue
+		}
+		if _, ok := want[fields[0]]; !ok {
+			targets = append(targets, fields[0])
+		}
+		want[fields[0]] = v
+	}
+	var errs []error
+	for start := 0; start < len(targets); start += verifyBatch {
+		batch := targets[start:min(start+verifyBatch, len(targets))]
+		series, err := c.Render(ctx, time.Unix(ts-60, 0), time.Unix(ts+60, 0), batch...)
+		if nil != err {
+			return err
+		}
+		found := make(map[string]float64, len(series))
+		for i := range series {
+			if v, ok := series[i].At(ts); ok {
+				found[series[i].Target] = v
+			}
+		}
+		for _, name := range batch {
+			v, ok := found[name]
+			switch {
+			case !ok:
+				errs = append(errs, fmt.Errorf("graphite: '%s' was not stored", name))
+			case !(v == want[name] || math.Abs(v-want[name]) <= 1e-9*math.Abs(want[name])):
+				errs = append(errs, fmt.Errorf("graphite: '%s' was stored as %v instead of %v", name, v, want[name]))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
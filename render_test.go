@@ -0,0 +1,53 @@
+package graphite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestVerify(t *testing.T) {
+	stored := map[string]float64{"app.foo.count": 2, "app.bar.value": 4}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/render" != r.URL.Path || "json" != r.FormValue("format") || "990" != r.FormValue("from") {
+			t.Errorf("bad request: %s", r.URL)
+		}
+		var series []map[string]interface{}
+		for _, target := range r.Form["target"] {
+			if v, ok := stored[target]; ok {
+				series = append(series, map[string]interface{}{
+					"target":     target,
+					"datapoints": [][]interface{}{{nil, 940}, {v, 1000}, {nil, 1060}},
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(series)
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	metrics.GetOrRegisterGauge("bar", r).Update(3)
+	metrics.GetOrRegisterGauge("baz", r).Update(5)
+	e := NewExporter(GraphiteConfig{Registry: r, Prefix: "app", Sink: WriterSink(&strings.Builder{}), Timestamp: func() int64 { return 1050 }})
+	if err := e.Once(); nil != err {
+		t.Fatal(err)
+	}
+	err := e.Verify(context.Background(), &RenderClient{URL: ts.URL + "/"})
+	if nil == err {
+		t.Fatal("expected the missing and wrong series to be reported")
+	}
+	for _, msg := range []string{"'app.bar.value' was stored as 4 instead of 3", "'app.baz.value' was not stored"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in %q", msg, err)
+		}
+	}
+	if strings.Contains(err.Error(), "app.foo.count") {
+		t.Error("stored series reported:", err)
+	}
+}
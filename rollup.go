@@ -0,0 +1,88 @@
+package graphite
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// An aggregate accumulates the values of a series over a rollup interval.
+type aggregate struct {
+	prefix, name string
+	suffix       string // Suffix of the series, such as "count"
+	tags         *tagSet
+	sum          float64
+	min, max     float64
+}
+
+// rollup adds the valid values of dps to the aggregates of their series.
+func (e *Exporter) rollup(dps []datapoint) {
+	for i := range dps {
+		dp := &dps[i]
+		if !dp.valid() {
+			continue
+		}
+		v := dp.fvalue
+		if dp.field.integer() {
+			v = float64(dp.ivalue)
+		}
+		s := dp.series()
+		a, ok := e.aggregates[s]
+		if !ok {
+			a = &aggregate{prefix: dp.prefix, name: dp.name, suffix: e.namer.suffix(dp), tags: dp.tags, min: math.Inf(1), max: math.Inf(-1)}
+			e.aggregates[s] = a
+		}
+		a.sum += v
+		a.min = math.Min(a.min, v)
+		a.max = math.Max(a.max, v)
+	}
+}
+
+// appendRollups appends the sum, min and max of every series to the snapshot
+// once RollupInterval has passed since the previous rollup, and starts the
+// next interval.
+func (e *Exporter) appendRollups(now time.Time) {
+	if e.rolled.IsZero() {
+		e.rolled = now
+	}
+	if now.Sub(e.rolled) < e.config.RollupInterval {
+		return
+	}
+	e.rolled = now
+	as := make([]*aggregate, 0, len(e.aggregates))
+	for _, a := range e.aggregates {
+		as = append(as, a)
+	}
+	sort.Slice(as, func(i, j int) bool {
+		if as[i].prefix != as[j].prefix {
+			return as[i].prefix < as[j].prefix
+		}
+		if as[i].name != as[j].name {
+			return as[i].name < as[j].name
+		}
+		if as[i].tags.key() != as[j].tags.key() {
+			return as[i].tags.key() < as[j].tags.key()
+		}
+		return as[i].suffix < as[j].suffix
+	})
+	for i, a := range as {
+		for _, v := range []struct {
+			key   string
+			value float64
+		}{{"sum", a.sum}, {"min", a.min}, {"max", a.max}} {
+			e.snapshot.dps = append(e.snapshot.dps, datapoint{
+				prefix: a.prefix,
+				name:   a.name,
+				field:  fieldCustom,
+				kind:   kindCustom,
+				key:    a.suffix + "." + v.key,
+				tags:   a.tags,
+				fvalue: v.value,
+			})
+		}
+		if len(as) == i+1 || as[i+1].prefix != a.prefix || as[i+1].name != a.name || as[i+1].tags.key() != a.tags.key() {
+			e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+		}
+	}
+	e.aggregates = make(map[series]*aggregate)
+}
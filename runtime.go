@@ -0,0 +1,59 @@
+package graphite
+
+import (
+	"runtime"
+	"time"
+)
+
+// staticGauge and staticGaugeFloat64 are gauges of values read by the
+// exporter itself, such as runtime statistics.
+type (
+	staticGauge        int64
+	staticGaugeFloat64 float64
+)
+
+func (g staticGauge) Value() int64          { return int64(g) }
+func (g staticGauge) Update(int64)          {}
+func (g staticGaugeFloat64) Value() float64 { return float64(g) }
+func (g staticGaugeFloat64) Update(float64) {}
+
+// snapshotRuntime appends gauges of the Go runtime's memory statistics,
+// garbage collections, goroutines and cgo calls to the snapshot, named
+// after RuntimePrefix.
+func (e *Exporter) snapshotRuntime(prefix string, now time.Time) {
+	sub := e.config.RuntimePrefix
+	if "" == sub {
+		sub = "runtime"
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	lastPause := uint64(0)
+	if 0 < m.NumGC {
+		lastPause = m.PauseNs[(m.NumGC+255)%256]
+	}
+	for _, g := range []struct {
+		name  string
+		value int64
+	}{
+		{"goroutines", int64(runtime.NumGoroutine())},
+		{"cgo-calls", runtime.NumCgoCall()},
+		{"mem.alloc", int64(m.Alloc)},
+		{"mem.total-alloc", int64(m.TotalAlloc)},
+		{"mem.sys", int64(m.Sys)},
+		{"mem.mallocs", int64(m.Mallocs)},
+		{"mem.frees", int64(m.Frees)},
+		{"mem.heap-alloc", int64(m.HeapAlloc)},
+		{"mem.heap-sys", int64(m.HeapSys)},
+		{"mem.heap-idle", int64(m.HeapIdle)},
+		{"mem.heap-inuse", int64(m.HeapInuse)},
+		{"mem.heap-objects", int64(m.HeapObjects)},
+		{"mem.stack-inuse", int64(m.StackInuse)},
+		{"gc.count", int64(m.NumGC)},
+		{"gc.next", int64(m.NextGC)},
+		{"gc.pause-total", int64(m.PauseTotalNs)},
+		{"gc.pause-last", int64(lastPause)},
+	} {
+		e.snapshotMetric(prefix, sub+"."+g.name, staticGauge(g.value), now)
+	}
+	e.snapshotMetric(prefix, sub+".gc.cpu-fraction", staticGaugeFloat64(m.GCCPUFraction), now)
+}
@@ -0,0 +1,27 @@
+package graphite
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRuntimeMetrics(t *testing.T) {
+	res, l, _, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	runtime.GC()
+	c.RuntimeMetrics = true
+	c.RuntimePrefix = "go"
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for _, name := range []string{"foobar.go.goroutines.value", "foobar.go.mem.heap-alloc.value", "foobar.go.gc.count.value"} {
+		if found := res[name]; 0 >= found {
+			t.Error("bad value:", name, found)
+		}
+	}
+	if _, found := res["foobar.go.gc.cpu-fraction.value"]; !found {
+		t.Error("GC CPU fraction not exported")
+	}
+}
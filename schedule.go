@@ -0,0 +1,159 @@
+package graphite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A Scheduler decides when Run flushes instead of every FlushInterval since
+// it started, such as at fixed times of the wall clock, so that the points
+// of a fleet line up with the buckets of whisper retentions.
+type Scheduler interface {
+	// Next returns the time of the first flush after now.
+	Next(now time.Time) time.Time
+}
+
+// AlignedSchedule returns a Scheduler flushing at every multiple of interval
+// since the Unix epoch, such as at :00, :10, :20 and so on of every minute
+// for ten seconds, the boundaries of the buckets whisper stores points of
+// that precision in. It panics if interval is not positive.
+func AlignedSchedule(interval time.Duration) Scheduler {
+	if 0 >= interval {
+		panic("graphite: non-positive interval for AlignedSchedule")
+	}
+	return alignedSchedule(interval)
+}
+
+type alignedSchedule time.Duration
+
+func (s alignedSchedule) Next(now time.Time) time.Time {
+	n, d := now.UnixNano(), int64(s)
+	return time.Unix(0, n-n%d+d)
+}
+
+// ParseSchedule returns the Scheduler of a cron-style spec of up to three
+// fields separated by spaces: the seconds, minutes and hours in UTC to flush
+// at, every minute or hour if left out. Each field is "*", a number, a range
+// such as "10-20", either of them followed by a step such as "*/15", or a
+// list of them separated by commas. "0,30" flushes at :00 and :30 of every
+// minute, "0 */5" at the start of every fifth minute.
+func ParseSchedule(spec string) (Scheduler, error) {
+	fields := strings.Fields(spec)
+	if 0 == len(fields) || 3 < len(fields) {
+		return nil, fmt.Errorf("graphite: schedule %q must have one to three fields", spec)
+	}
+	s := cronSchedule{second: allTimes(60), minute: allTimes(60), hour: allTimes(24)}
+	for i, set := range []*uint64{&s.second, &s.minute, &s.hour} {
+		if i >= len(fields) {
+			break
+		}
+		var err error
+		if *set, err = parseCronField(fields[i], []int{60, 60, 24}[i]); nil != err {
+			return nil, fmt.Errorf("graphite: schedule %q: %w", spec, err)
+		}
+	}
+	return s, nil
+}
+
+// A cronSchedule flushes at the seconds, minutes and hours in UTC whose bits
+// are set.
+type cronSchedule struct {
+	second, minute, hour uint64
+}
+
+func (s cronSchedule) Next(now time.Time) time.Time {
+	t := now.UTC().Truncate(time.Second).Add(time.Second)
+	for {
+		switch {
+		case 0 == s.hour&(1<<uint(t.Hour())):
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case 0 == s.minute&(1<<uint(t.Minute())):
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		case 0 == s.second&(1<<uint(t.Second())):
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+}
+
+// allTimes returns the set of the values from zero to n excluded.
+func allTimes(n int) uint64 {
+	return 1<<uint(n) - 1
+}
+
+// parseCronField returns the set of the values from zero to n excluded
+// which field lists.
+func parseCronField(field string, n int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); nil != err || 0 >= step {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng = part[:i]
+		}
+		lo, hi := 0, n-1
+		if "*" != rng {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); nil != err {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			hi = lo
+			if 2 == len(bounds) {
+				if hi, err = strconv.Atoi(bounds[1]); nil != err {
+					return 0, fmt.Errorf("bad range in %q", part)
+				}
+			} else if 1 != step {
+				hi = n - 1
+			}
+		}
+		if 0 > lo || lo > hi || hi >= n {
+			return 0, fmt.Errorf("%q is not within 0-%d", part, n-1)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// runScheduled is the loop of Run with a Scheduler. The times which passed
+// while a flush was being taken or sent are skipped and counted by Skipped.
+func (e *Exporter) runScheduled(clock Clock, s Scheduler) {
+	due := s.Next(clock.Now())
+	for {
+		if wait := due.Sub(clock.Now()); 0 < wait {
+			t := clock.NewTicker(wait)
+			_, ok := <-t.C()
+			t.Stop()
+			if !ok {
+				return
+			}
+		}
+		if 0 != atomic.LoadUint32(&e.inflight) {
+			atomic.AddUint64(&e.skipped, 1)
+			due = s.Next(due)
+			continue
+		}
+		if err := e.Once(); nil != err {
+			e.logError(err)
+		}
+		e.mu.Lock()
+		max := e.config.MaxConsecutiveFailures
+		e.mu.Unlock()
+		if 0 < max && uint64(max) <= atomic.LoadUint64(&e.failed) {
+			return
+		}
+		now := clock.Now()
+		for due = s.Next(due); !due.After(now); due = s.Next(due) {
+			atomic.AddUint64(&e.skipped, 1)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package graphite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestAlignedSchedule(t *testing.T) {
+	s := AlignedSchedule(10 * time.Second)
+	for now, expected := range map[int64]int64{1000: 1010, 1001: 1010, 1009: 1010, 1010: 1020} {
+		if found := s.Next(time.Unix(now, 0)).Unix(); expected != found {
+			t.Errorf("after %d, expected %d, found %d", now, expected, found)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2020, 1, 1, 10, 59, 50, 0, time.UTC)
+	for spec, expected := range map[string]time.Time{
+		"0,30":      time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC),
+		"*/15":      time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC),
+		"55":        time.Date(2020, 1, 1, 10, 59, 55, 0, time.UTC),
+		"50":        time.Date(2020, 1, 1, 11, 0, 50, 0, time.UTC),
+		"0 */5":     time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC),
+		"10-20 5":   time.Date(2020, 1, 1, 11, 5, 10, 0, time.UTC),
+		"0 0 9-10":  time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC),
+		"30 */2 3":  time.Date(2020, 1, 2, 3, 0, 30, 0, time.UTC),
+		"5-59/20 *": time.Date(2020, 1, 1, 11, 0, 5, 0, time.UTC),
+	} {
+		s, err := ParseSchedule(spec)
+		if nil != err {
+			t.Fatal(spec, err)
+		}
+		if found := s.Next(base); !expected.Equal(found) {
+			t.Errorf("%q: expected %v, found %v", spec, expected, found)
+		}
+	}
+	for _, spec := range []string{"", "60", "*/0", "a", "20-10", "0 0 24", "0 0 0 0"} {
+		if _, err := ParseSchedule(spec); nil == err {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestRunScheduled(t *testing.T) {
+	bodies := make(chan string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer ts.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+
+	clock := &testClock{now: time.Unix(1005, 0), c: make(chan time.Time)}
+	go NewExporter(GraphiteConfig{
+		Registry:      r,
+		Prefix:        "app",
+		FlushInterval: time.Hour,
+		Scheduler:     AlignedSchedule(10 * time.Second),
+		Transport:     TransportHTTP,
+		URL:           ts.URL,
+		Clock:         clock,
+	}).Run()
+
+	clock.c <- time.Unix(1010, 0)
+	if expected, found := "app.foo.count 2 1005\n", <-bodies; expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
@@ -0,0 +1,97 @@
+package graphite
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotSelf appends gauges of the exporter itself to the snapshot under
+// "graphite", so that slow or unreachable servers are visible.
+func (e *Exporter) snapshotSelf(prefix string, now time.Time) {
+	status := e.Status()
+	for _, g := range []struct {
+		name  string
+		value uint64
+	}{
+		{"graphite.skipped-flushes", e.Skipped()},
+		{"graphite.dropped", e.Dropped()},
+		{"graphite.circuit-dropped", e.CircuitDropped()},
+		{"graphite.quota-dropped", e.QuotaDropped()},
+		{"graphite.series-dropped", e.SeriesDropped()},
+		{"graphite.bytes-sent", status.BytesSent},
+		{"graphite.bytes-last-hour", status.BytesLastHour},
+	} {
+		e.snapshotMetric(prefix, g.name, staticGauge(g.value), now)
+	}
+}
+
+// Skipped returns how many flushes Run skipped because the previous flush
+// was still in progress when they were due.
+func (e *Exporter) Skipped() uint64 {
+	return atomic.LoadUint64(&e.skipped)
+}
+
+// QuotaDropped returns how many datapoints were dropped as their flushes
+// exceeded ByteQuota.
+func (e *Exporter) QuotaDropped() uint64 {
+	return atomic.LoadUint64(&e.quotaDropped)
+}
+
+// SeriesDropped returns how many datapoints were dropped as their flushes
+// had more series than MaxSeries.
+func (e *Exporter) SeriesDropped() uint64 {
+	return atomic.LoadUint64(&e.seriesDropped)
+}
+
+// reportOverflow counts the datapoints MaxSeries dropped this flush, and
+// adds an ErrMaxSeries to the errors of the flush, so that metrics named
+// after requests or users are noticed before they flood the servers.
+func (e *Exporter) reportOverflow() {
+	atomic.AddUint64(&e.seriesDropped, uint64(e.overflow))
+	err := fmt.Errorf("%w: dropped %d over %d", ErrMaxSeries, e.overflow, e.config.MaxSeries)
+	e.snapshot.errs = append(e.snapshot.errs, err)
+}
+
+// snapshotHeartbeat appends the heartbeat series to the snapshot, named like
+// the series of custom metrics so that it has no suffix. Unlike metrics it
+// is neither skipped as unchanged, expired by MetricTTL nor dropped by
+// MaxDatapointsPerSecond, so that every flush sends it.
+func (e *Exporter) snapshotHeartbeat(prefix string) {
+	e.snapshot.dps = append(e.snapshot.dps, datapoint{prefix: prefix, name: "exporter", field: fieldCustom, kind: kindCustom, key: "heartbeat", fvalue: 1})
+	e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+}
+
+// snapshotStartEpoch appends the start_epoch series to the snapshot, the
+// Unix time the process started, so that resets of cumulative counters
+// after restarts can be told apart. Like the heartbeat every flush sends it.
+func (e *Exporter) snapshotStartEpoch(prefix string) {
+	e.snapshot.dps = append(e.snapshot.dps, datapoint{prefix: prefix, name: "exporter", field: fieldCustom, kind: kindCustom, key: "start_epoch", fvalue: float64(processStart.Unix())})
+	e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+}
+
+// snapshotNoMetrics appends the no_metrics series to the snapshot, sent
+// when no metric of any registry matched Filter, and adds ErrNoMetrics to
+// the errors of the flush, so that misconfigured registries are noticed
+// instead of silently exporting nothing.
+func (e *Exporter) snapshotNoMetrics(prefix string) {
+	e.snapshot.dps = append(e.snapshot.dps, datapoint{prefix: prefix, name: "exporter", field: fieldCustom, kind: kindCustom, key: "no_metrics", fvalue: 1})
+	e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+	e.snapshot.errs = append(e.snapshot.errs, ErrNoMetrics)
+}
+
+// snapshotMetadata appends the gauges of Metadata to the snapshot, in the
+// order of their names. Like the heartbeat they bypass SkipUnchanged,
+// MetricTTL and MaxDatapointsPerSecond, so that every flush sends them.
+func (e *Exporter) snapshotMetadata(prefix string) {
+	names := make([]string, 0, len(e.config.Metadata))
+	for name := range e.config.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.snapshot.dps = e.appendDatapoints(e.snapshot.dps, prefix, escapeName(name), staticGauge(e.config.Metadata[name]))
+		e.snapshot.ends = append(e.snapshot.ends, len(e.snapshot.dps))
+	}
+}
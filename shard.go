@@ -0,0 +1,128 @@
+package graphite
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sync"
+)
+
+// A shard is the part of each flush sent over a connection of its own, one
+// of Connections.
+type shard struct {
+	payload payload
+	gzip    compressor  // Compresses batches with Compression
+	conn    net.Conn    // Connection kept between flushes with PersistentConnection
+	addr    string      // Address the shard is sent to with Destinations, the configured one if empty
+	bufs    net.Buffers // Batches of payload written at once with VectoredWrites
+}
+
+// A shardRouter writes the datapoints of each metric to the payload of the
+// shard it is routed to, so that a metric is always sent over the same
+// connection. With Destinations, each series is routed to the shard of its
+// destination instead.
+type shardRouter struct {
+	shards []shard
+	cur    *payload
+	ring   *hashRing // Routes series to Destinations, nil without
+	namer  namer     // Names the series routed by ring
+}
+
+func newShardRouter(c *GraphiteConfig) shardRouter {
+	if 0 == len(c.Destinations) {
+		return shardRouter{shards: make([]shard, max(1, c.Connections))}
+	}
+	r := shardRouter{shards: make([]shard, len(c.Destinations)), namer: newNamer(c)}
+	dests := make([]destination, len(c.Destinations))
+	for i, s := range c.Destinations {
+		dests[i] = parseDestination(s)
+		r.shards[i].addr = dests[i].addr
+	}
+	r.ring = newHashRing(dests)
+	return r
+}
+
+// reset empties the payloads of the shards, keeping their memory for the
+// next flush.
+func (r *shardRouter) reset(size int) {
+	for i := range r.shards {
+		r.shards[i].payload.reset(size)
+	}
+	r.cur = &r.shards[0].payload
+}
+
+// route directs the following writes to the shard of the metric of dp.
+func (r *shardRouter) route(dp *datapoint) {
+	if 1 == len(r.shards) {
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(dp.prefix))
+	h.Write([]byte{'.'})
+	h.Write([]byte(dp.name))
+	r.cur = &r.shards[h.Sum32()%uint32(len(r.shards))].payload
+}
+
+// routeSeries directs the following writes to the shard of the destination
+// of the series of dp.
+func (r *shardRouter) routeSeries(dp *datapoint) {
+	name := r.namer.path(dp)
+	if nil != dp.tags {
+		name += dp.tags.key()
+	}
+	r.cur = &r.shards[r.ring.shard(name)].payload
+}
+
+// size returns the size of the payloads of the shards.
+func (r *shardRouter) size() int {
+	n := 0
+	for i := range r.shards {
+		n += len(r.shards[i].payload.buf)
+	}
+	return n
+}
+
+// mark appends the sizes of the payloads of the shards to m, so that what
+// is written after can be undone by rollback.
+func (r *shardRouter) mark(m []int) []int {
+	for i := range r.shards {
+		m = append(m, len(r.shards[i].payload.buf))
+	}
+	return m
+}
+
+// rollback drops what was written to the shards since m was marked.
+func (r *shardRouter) rollback(m []int) {
+	for i := range r.shards {
+		r.shards[i].payload.truncate(m[i])
+	}
+}
+
+func (r *shardRouter) Write(b []byte) (int, error) {
+	return r.cur.Write(b)
+}
+
+// sendShards sends the shards in parallel, returning the first error
+// encountered.
+func (e *Exporter) sendShards(ctx context.Context) error {
+	shards := e.shards.shards
+	if 1 == len(shards) {
+		return e.send(ctx, &shards[0])
+	}
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = e.send(ctx, &shards[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// FlushOnSignal flushes e right away whenever the process receives SIGUSR1,
+// so that operators can push the metrics with kill -USR1, and a last time
+// when it receives SIGTERM, after which the signal is raised again so the
+// process terminates as it would have otherwise. Programs which handle
+// SIGTERM themselves receive it a second time, and may rather call Flush
+// from their own handler. Errors are logged. The returned function stops
+// handling the signals. On systems without these signals, FlushOnSignal
+// does nothing.
+func (e *Exporter) FlushOnSignal() (stop func()) {
+	if 0 == len(flushSignals)+len(termSignals) {
+		return func() {}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, append(append([]os.Signal(nil), flushSignals...), termSignals...)...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-ch:
+				if err := e.Flush(); nil != err {
+					e.logError(err)
+				}
+				if terminates(sig) {
+					signal.Stop(ch)
+					e.Close()
+					if p, err := os.FindProcess(os.Getpid()); nil == err {
+						p.Signal(sig)
+					}
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// terminates reports whether sig is one of the signals after which
+// FlushOnSignal lets the process terminate.
+func terminates(sig os.Signal) bool {
+	for _, s := range termSignals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
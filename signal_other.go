@@ -0,0 +1,8 @@
+//go:build !unix
+
+package graphite
+
+import "os"
+
+// Signals handled by FlushOnSignal, of which there are none without SIGUSR1.
+var flushSignals, termSignals []os.Signal
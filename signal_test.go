@@ -0,0 +1,27 @@
+//go:build unix
+
+package graphite
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestFlushOnSignal(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "foobar")
+	defer l.Close()
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(2)
+	stop := NewExporter(c).FlushOnSignal()
+	defer stop()
+
+	wg.Add(1)
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	wg.Wait()
+
+	if expected, found := 2.0, res["foobar.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package graphite
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals handled by FlushOnSignal.
+var (
+	flushSignals = []os.Signal{syscall.SIGUSR1}
+	termSignals  = []os.Signal{syscall.SIGTERM}
+)
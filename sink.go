@@ -0,0 +1,239 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// A Sink is the destination flushes are sent to. Open is called by every
+// flush, and each batch of the flush is written to the returned writer with
+// a single Write, so that datagram and request based sinks can send a batch
+// per Write. Close completes the flush. With GraphiteConfig.Connections,
+// every shard of a flush is sent in parallel, so sinks must be safe for
+// concurrent use.
+//
+// Unless GraphiteConfig.Sink is set, flushes are POSTed to URL over HTTP,
+// written to standard output with TransportStdout, dropped with
+// TransportDiscard, or written to a connection dialed to Address.
+type Sink interface {
+	Open() (io.WriteCloser, error)
+}
+
+// WriterSink returns a Sink which writes every flush to w, such as a file or
+// a buffer in tests. It does not close w.
+func WriterSink(w io.Writer) Sink {
+	return writerSink{w}
+}
+
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) Open() (io.WriteCloser, error) {
+	return nopCloser{s.w}, nil
+}
+
+// DiscardSink returns a Sink which drops every flush, so that the cost of
+// snapshotting and encoding can be measured on its own, such as in load tests
+// and benchmarks, without a server listening.
+func DiscardSink() Sink {
+	return writerSink{io.Discard}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// A dialSink sends each flush of a shard over a new connection made by
+// Exporter.dial, or over the same connection with PersistentConnection.
+type dialSink struct {
+	e   *Exporter
+	ctx context.Context
+	s   *shard
+}
+
+func (s dialSink) Open() (io.WriteCloser, error) {
+	if !s.e.config.PersistentConnection {
+		return s.e.dial(s.ctx, s.s.addr)
+	}
+	if nil == s.s.conn {
+		conn, err := s.e.dial(s.ctx, s.s.addr)
+		if nil != err {
+			return nil, err
+		}
+		s.s.conn = conn
+	}
+	return persistentConn{s.s}, nil
+}
+
+// A persistentConn writes to the connection kept by a shard, closing it
+// after a failed write so that the next flush connects again.
+type persistentConn struct {
+	s *shard
+}
+
+func (c persistentConn) Write(b []byte) (int, error) {
+	n, err := c.s.conn.Write(b)
+	if nil != err {
+		c.s.conn.Close()
+		c.s.conn = nil
+	}
+	return n, err
+}
+
+func (c persistentConn) writeBuffers(bufs *net.Buffers) (int64, error) {
+	n, err := writeBuffers(c.s.conn, bufs)
+	if nil != err {
+		c.s.conn.Close()
+		c.s.conn = nil
+	}
+	return n, err
+}
+
+func (c persistentConn) SetWriteDeadline(t time.Time) error {
+	return c.s.conn.SetWriteDeadline(t)
+}
+
+func (c persistentConn) Close() error {
+	return nil
+}
+
+// An httpSink POSTs each batch of a flush to URL.
+type httpSink struct {
+	e   *Exporter
+	ctx context.Context
+}
+
+func (s httpSink) Open() (io.WriteCloser, error) {
+	return nopCloser{s}, nil
+}
+
+func (s httpSink) Write(b []byte) (int, error) {
+	if err := s.e.post(s.ctx, b); nil != err {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// A buffersWriter wraps a connection, writing several batches to it at
+// once with writeBuffers.
+type buffersWriter interface {
+	writeBuffers(bufs *net.Buffers) (int64, error)
+}
+
+// writeBuffers writes bufs to w, with a single writev call if w is a TCP or
+// unix connection, or one wrapping it.
+func writeBuffers(w io.Writer, bufs *net.Buffers) (int64, error) {
+	if bw, ok := w.(buffersWriter); ok {
+		return bw.writeBuffers(bufs)
+	}
+	return bufs.WriteTo(w)
+}
+
+// sink returns the Sink the flushes of shard s are sent to, connecting and
+// sending within ctx.
+func (e *Exporter) sink(ctx context.Context, s *shard) Sink {
+	switch {
+	case nil != e.config.Sink:
+		return e.config.Sink
+	case TransportStdout == e.config.Transport:
+		return WriterSink(os.Stdout)
+	case TransportDiscard == e.config.Transport:
+		return DiscardSink()
+	case e.config.overHTTP():
+		return httpSink{e, ctx}
+	}
+	return dialSink{e, ctx, s}
+}
+
+// send writes the batches of shard s to the sink, returning the first error
+// encountered. The remaining batches are not sent after an error, or once
+// ctx is done, which also interrupts writes to connections.
+func (e *Exporter) send(ctx context.Context, s *shard) error {
+	w, err := e.sink(ctx, s).Open()
+	if nil != err {
+		if nil != ctx.Err() {
+			return ctx.Err()
+		}
+		return sendErr(ErrDial, err)
+	}
+	if d, ok := w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		stop := context.AfterFunc(ctx, func() { d.SetWriteDeadline(time.Unix(1, 0)) })
+		defer stop()
+	}
+	mirror := e.openMirror()
+	if e.config.VectoredWrites && !e.config.compressed() {
+		s.bufs = s.bufs[:0]
+		s.payload.each(func(b []byte) {
+			mirror.write(b)
+			s.bufs = append(s.bufs, b)
+		})
+		if err = ctx.Err(); nil == err {
+			bufs := s.bufs
+			_, err = writeBuffers(w, &bufs)
+		}
+	} else {
+		s.payload.each(func(b []byte) {
+			if nil == err {
+				err = ctx.Err()
+			}
+			if nil == err {
+				mirror.write(b)
+				if e.config.compressed() {
+					b = s.gzip.compress(b)
+				}
+				_, err = w.Write(b)
+			}
+		})
+	}
+	mirror.close()
+	if cerr := w.Close(); nil == err {
+		err = cerr
+	}
+	if nil != err && nil != ctx.Err() {
+		return ctx.Err()
+	}
+	return sendErr(ErrWrite, err)
+}
+
+// A mirror writes the batches of a flush to the Mirror sink, logging its
+// errors rather than failing the flush.
+type mirror struct {
+	e   *Exporter
+	w   io.WriteCloser // Nil without a Mirror, or if it could not be opened
+	err error
+}
+
+// openMirror opens the Mirror sink for a flush, if there is one.
+func (e *Exporter) openMirror() *mirror {
+	m := &mirror{e: e}
+	if nil != e.config.Mirror {
+		m.w, m.err = e.config.Mirror.Open()
+	}
+	return m
+}
+
+func (m *mirror) write(b []byte) {
+	if nil != m.w && nil == m.err {
+		_, m.err = m.w.Write(b)
+	}
+}
+
+func (m *mirror) close() {
+	if nil != m.w {
+		if err := m.w.Close(); nil == m.err {
+			m.err = err
+		}
+	}
+	if nil != m.err {
+		m.e.logError(fmt.Errorf("graphite: mirror: %w", m.err))
+	}
+}
@@ -0,0 +1,85 @@
+package graphite
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A statsdEncoder translates datapoints into statsd datagrams. Counters and
+// meter counts are sent as the change since the previous flush, gauges as
+// gauges and timers as their mean duration in milliseconds, sampled so that
+// statsd counts as many timings as were recorded since the previous flush.
+// Any other series is sent as a gauge named after its plaintext series.
+type statsdEncoder struct {
+	w  io.Writer
+	du float64 // Nanoseconds per DurationUnit
+	namer
+	line []byte
+}
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_")
+
+func (enc *statsdEncoder) encode(dps []datapoint, now int64) {
+	var timings int64
+	for i := range dps {
+		if fieldTimerCount == dps[i].field {
+			timings = dps[i].delta
+		}
+	}
+	for i := range dps {
+		dp := &dps[i]
+		if !dp.valid() {
+			continue
+		}
+		b := enc.line[:0]
+		switch {
+		case fieldCounter == dp.field, fieldMeterCount == dp.field:
+			b = enc.appendName(b, enc.fullName(dp))
+			b = strconv.AppendInt(b, dp.delta, 10)
+			b = append(b, "|c"...)
+		case kindMeter == dp.kind:
+			continue
+		case kindTimer == dp.kind:
+			if fieldMean != dp.field || 0 >= timings {
+				continue
+			}
+			b = enc.appendName(b, enc.fullName(dp))
+			b = enc.appendFloat(b, dp.fvalue*enc.du/float64(time.Millisecond))
+			b = append(b, "|ms"...)
+			if 1 < timings {
+				b = append(b, "|@"...)
+				b = strconv.AppendFloat(b, 1/float64(timings), 'g', -1, 64)
+			}
+		case kindGauge == dp.kind, kindGaugeFloat64 == dp.kind:
+			b = enc.appendName(b, enc.fullName(dp))
+			b = enc.appendValue(b, dp)
+			b = append(b, "|g"...)
+		default:
+			b = enc.appendName(b, enc.path(dp))
+			b = enc.appendValue(b, dp)
+			b = append(b, "|g"...)
+		}
+		b = append(b, '\n')
+		enc.w.Write(b)
+		enc.line = b
+	}
+}
+
+func (enc *statsdEncoder) appendName(b []byte, name string) []byte {
+	b = append(b, statsdEscaper.Replace(name)...)
+	return append(b, ':')
+}
+
+// appendValue appends the value of dp to b.
+func (n *namer) appendValue(b []byte, dp *datapoint) []byte {
+	if dp.field.integer() {
+		return strconv.AppendInt(b, dp.ivalue, 10)
+	}
+	return n.appendFloat(b, dp.fvalue)
+}
+
+// statsdPacketSize is the largest datagram sent over datagram networks,
+// chosen to fit into a single ethernet frame.
+const statsdPacketSize = 1432
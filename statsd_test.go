@@ -0,0 +1,76 @@
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestStatsDEncoder(t *testing.T) {
+	c := GraphiteConfig{Protocol: ProtocolStatsD, DurationUnit: time.Microsecond}
+	var b bytes.Buffer
+	enc := newEncoder(&b, &c)
+
+	enc.encode([]datapoint{{prefix: "app", name: "hits", field: fieldCounter, kind: kindCounter, ivalue: 7, delta: 3}}, 10)
+	enc.encode([]datapoint{{prefix: "app", name: "temp", field: fieldGaugeFloat64, kind: kindGaugeFloat64, fvalue: 21.5}}, 10)
+	enc.encode([]datapoint{
+		{prefix: "app", name: "db", field: fieldTimerCount, kind: kindTimer, ivalue: 8, delta: 4},
+		{prefix: "app", name: "db", field: fieldMean, kind: kindTimer, fvalue: 1500},
+		{prefix: "app", name: "db", field: fieldRate1, kind: kindTimer, fvalue: 1},
+	}, 10)
+	enc.encode([]datapoint{{prefix: "app", name: "size", field: fieldMax, kind: kindHistogram, ivalue: 9}}, 10)
+
+	if expected, found := "app.hits:3|c\napp.temp:21.5|g\napp.db:1.5|ms|@0.25\napp.size.max:9|g\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := metrics.NewRegistry()
+	for i := 0; i < 200; i++ {
+		metrics.GetOrRegisterCounter(fmt.Sprintf("counter%03d", i), r).Inc(1)
+	}
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	err = GraphiteOnce(GraphiteConfig{
+		Addr:     &net.TCPAddr{IP: addr.IP, Port: addr.Port},
+		Registry: r,
+		Prefix:   "app",
+		Protocol: ProtocolStatsD,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	lines, datagrams := 0, 0
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for lines < 200 {
+		n, err := conn.Read(buf)
+		if nil != err {
+			t.Fatal("received", lines, "lines:", err)
+		}
+		if n > statsdPacketSize {
+			t.Fatal("oversized datagram:", n)
+		}
+		if '\n' != buf[n-1] {
+			t.Fatal("datagram splits a line")
+		}
+		lines += bytes.Count(buf[:n], []byte("\n"))
+		datagrams++
+	}
+
+	if datagrams > 10 {
+		t.Fatal("lines not packed into datagrams:", datagrams)
+	}
+}
@@ -0,0 +1,94 @@
+package graphite
+
+import "time"
+
+// Status describes the flushes an Exporter has sent, so that health checks
+// and admin endpoints can tell whether metrics are reaching the server.
+type Status struct {
+	LastFlushTime     time.Time     // Time the snapshot of the last flush was taken, zero before the first one completed
+	LastFlushDuration time.Duration // How long the last flush took from its snapshot until it was sent or failed
+	LastError         error         // Error of the last flush, nil if it succeeded
+	DatapointsSent    uint64        // Datapoints of all the flushes sent successfully
+	LastFlushBytes    int           // Size of the last flush before any compression, whether it was sent or not
+	BytesSent         uint64        // Size of all the flushes sent successfully before any compression
+	BytesLastHour     uint64        // Size of the flushes sent successfully within the last hour before any compression
+}
+
+// Status returns the outcome of the flushes sent so far. With AsyncSend it
+// does not include the flush being sent, if any.
+func (e *Exporter) Status() Status {
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+	s := e.status
+	s.BytesLastHour = e.bandwidth.lastHour(e.clock.Now())
+	return s
+}
+
+// FlushStats describes a flush to AfterFlush.
+type FlushStats struct {
+	Time       time.Time     // Time the snapshot of the flush was taken
+	Duration   time.Duration // How long the flush took from its snapshot until it was sent or failed
+	Datapoints int           // Datapoints of the flush, whether they were sent or not
+	Bytes      int           // Size of the encoded datapoints before any compression
+	Err        error         // Error of the flush, nil if it succeeded
+}
+
+// recordStatus records the outcome of the flush described by stats, whose
+// Duration is filled in, which sent the given number of datapoints and
+// bytes, and passes it to the trace of the flush and AfterFlush.
+func (e *Exporter) recordStatus(stats FlushStats, sent, sentBytes int) {
+	stats.Duration = e.clock.Now().Sub(stats.Time)
+	e.statusMu.Lock()
+	e.status.LastFlushTime = stats.Time
+	e.status.LastFlushDuration = stats.Duration
+	e.status.LastError = stats.Err
+	e.status.DatapointsSent += uint64(sent)
+	e.status.LastFlushBytes = stats.Bytes
+	e.status.BytesSent += uint64(sentBytes)
+	e.bandwidth.add(stats.Time, sentBytes)
+	e.statusMu.Unlock()
+	if nil != e.endTrace {
+		e.endTrace(stats)
+		e.endTrace = nil
+	}
+	if nil != e.config.AfterFlush {
+		e.config.AfterFlush(stats)
+	}
+}
+
+// A bandwidth accounts for the bytes sent by the flushes of the last hour.
+type bandwidth struct {
+	sends []sentBytes // Flushes of the last hour, oldest first
+}
+
+type sentBytes struct {
+	time  time.Time
+	bytes int
+}
+
+// add records n bytes sent by the flush at t, forgetting the flushes more
+// than an hour older.
+func (b *bandwidth) add(t time.Time, n int) {
+	b.prune(t)
+	if 0 < n {
+		b.sends = append(b.sends, sentBytes{t, n})
+	}
+}
+
+// lastHour returns the bytes sent by the flushes within the hour before now.
+func (b *bandwidth) lastHour(now time.Time) uint64 {
+	b.prune(now)
+	n := uint64(0)
+	for _, s := range b.sends {
+		n += uint64(s.bytes)
+	}
+	return n
+}
+
+func (b *bandwidth) prune(now time.Time) {
+	i := 0
+	for i < len(b.sends) && now.Sub(b.sends[i].time) >= time.Hour {
+		i++
+	}
+	b.sends = append(b.sends[:0], b.sends[i:]...)
+}
@@ -0,0 +1,162 @@
+package graphite
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syslogSockets are the paths local syslog daemons listen at.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// A SyslogSink is a Sink which sends every line of each flush as an RFC 5424
+// syslog message, to a remote server or the local daemon, for hosts whose
+// only way out is a syslog pipeline feeding a metrics gateway. It is meant
+// for line based protocols such as plaintext.
+//
+// Over streams, messages end with a newline. A SyslogSink keeps its
+// connection between flushes, reconnecting after errors, and is safe for
+// concurrent use.
+type SyslogSink struct {
+	Network  string // Network of Address, such as "udp" or "tcp"; the local daemon if empty
+	Address  string // host:port of the syslog server, or the path of the local socket
+	Facility int    // Facility of the messages, such as 16 for local0; user if zero
+	AppName  string // APP-NAME of the messages, "graphite" if empty
+	Hostname string // HOSTNAME of the messages, that of the host if empty
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool   // Whether conn is a stream rather than datagrams
+	header []byte // Start of every message up to its timestamp
+	fields []byte // Fields of every message between its timestamp and its line
+	buf    []byte
+}
+
+func (s *SyslogSink) Open() (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.conn {
+		conn, err := s.dial()
+		if nil != err {
+			return nil, err
+		}
+		switch conn.LocalAddr().Network() {
+		case "tcp", "tcp4", "tcp6", "unix":
+			s.stream = true
+		}
+		s.conn = conn
+	}
+	return nopCloser{syslogWriter{s}}, nil
+}
+
+// dial connects to Address, or to the local daemon.
+func (s *SyslogSink) dial() (net.Conn, error) {
+	if "" != s.Network {
+		return net.Dial(s.Network, s.Address)
+	}
+	paths := syslogSockets
+	if "" != s.Address {
+		paths = []string{s.Address}
+	}
+	var err error
+	for _, path := range paths {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.Dial(network, path); nil == err {
+				return conn, nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// Close closes the connection. A later flush connects again.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.conn {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// message appends the message of line sent at now to b.
+func (s *SyslogSink) message(b []byte, line []byte, now time.Time) []byte {
+	if nil == s.header {
+		facility, hostname, app := s.Facility, s.Hostname, s.AppName
+		if 0 == facility {
+			facility = 1
+		}
+		if "" == hostname {
+			hostname, _ = os.Hostname()
+		}
+		if "" == app {
+			app = "graphite"
+		}
+		// Informational severity, and neither a MSGID nor STRUCTURED-DATA.
+		s.header = strconv.AppendInt([]byte{'<'}, int64(facility*8+6), 10)
+		s.header = append(s.header, ">1 "...)
+		s.fields = []byte(" " + hostname + " " + app + " " + strconv.Itoa(os.Getpid()) + " - - ")
+	}
+	b = append(b, s.header...)
+	b = now.AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, s.fields...)
+	b = append(b, line...)
+	if s.stream {
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// A syslogWriter sends every line of each batch as a message of a
+// SyslogSink, closing its connection after a failed write so that the next
+// flush connects again.
+type syslogWriter struct {
+	s *SyslogSink
+}
+
+func (w syslogWriter) Write(b []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.conn {
+		return 0, net.ErrClosed
+	}
+	now := time.Now()
+	s.buf = s.buf[:0]
+	for rest := b; 0 < len(rest); {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); 0 <= i {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		if 0 == len(line) {
+			continue
+		}
+		if !s.stream {
+			s.buf = s.message(s.buf[:0], line, now)
+			if _, err := s.conn.Write(s.buf); nil != err {
+				s.conn.Close()
+				s.conn = nil
+				return 0, err
+			}
+			continue
+		}
+		s.buf = s.message(s.buf, line, now)
+	}
+	if s.stream && 0 < len(s.buf) {
+		if _, err := s.conn.Write(s.buf); nil != err {
+			s.conn.Close()
+			s.conn = nil
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,42 @@
+package graphite
+
+import (
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	metrics.GetOrRegisterGauge("bar", r).Update(2)
+	s := &SyslogSink{Network: "udp", Address: conn.LocalAddr().String(), Facility: 16, Hostname: "web1"}
+	defer s.Close()
+	err = GraphiteOnce(GraphiteConfig{Registry: r, Prefix: "app", Sink: s, SortedOutput: true, Timestamp: func() int64 { return 1 }})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	for _, line := range []string{"app.bar.value 2 1", "app.foo.count 1 1"} {
+		b := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(b)
+		if nil != err {
+			t.Fatal(err)
+		}
+		expected := `^<134>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d) web1 graphite ` + pid + " - - " + regexp.QuoteMeta(line) + "$"
+		if found := string(b[:n]); !regexp.MustCompile(expected).MatchString(found) {
+			t.Fatalf("expected %s, found %q", expected, found)
+		}
+	}
+}
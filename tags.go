@@ -0,0 +1,100 @@
+package graphite
+
+import (
+	"sort"
+	"strings"
+)
+
+// A TagExtractor returns the name the given metric is exported under along
+// with its tags, such as the labels of metrics kept by forks of go-metrics
+// which support them. Metrics without tags may return a nil map.
+type TagExtractor func(name string, metric interface{}) (string, map[string]string)
+
+// Modes of GraphiteConfig.TagMode.
+const (
+	TagModeTagged = ""       // Graphite tags in the plaintext protocol, and the tags or labels of other protocols
+	TagModeFolded = "folded" // Appended to the dotted name as key.value pairs, sorted by key
+	TagModeBoth   = "both"   // Exported twice, under the name in the registry and tagged under TaggedPrefix, such as while migrating dashboards to tags
+)
+
+// A tag is a key and value of the tags of a metric.
+type tag struct {
+	key, value string
+}
+
+// A tagSet holds the tags of a metric, sorted by key.
+type tagSet struct {
+	tags []tag
+	id   string // Tags in the form Graphite appends them to series names, ";key=value"
+}
+
+// escapeTag returns s, a key or value of Graphite tags, with ';', '=' and
+// the bytes which would split its line replaced by underscores.
+func escapeTag(s string) string {
+	return replaceBytes(s, func(c byte) bool { return ';' == c || '=' == c || splitsLine(c) })
+}
+
+func newTagSet(tags map[string]string) *tagSet {
+	if 0 == len(tags) {
+		return nil
+	}
+	s := &tagSet{tags: make([]tag, 0, len(tags))}
+	for k, v := range tags {
+		s.tags = append(s.tags, tag{k, v})
+	}
+	sort.Slice(s.tags, func(i, j int) bool { return s.tags[i].key < s.tags[j].key })
+	var b strings.Builder
+	for _, t := range s.tags {
+		b.WriteByte(';')
+		b.WriteString(escapeTag(t.key))
+		b.WriteByte('=')
+		b.WriteString(escapeTag(t.value))
+	}
+	s.id = b.String()
+	return s
+}
+
+// key returns the identity of s, the empty string if s is nil.
+func (s *tagSet) key() string {
+	if nil == s {
+		return ""
+	}
+	return s.id
+}
+
+// merge returns tags with the tags of s added, overriding those with the
+// same keys, or tags itself if s is nil.
+func (s *tagSet) merge(tags map[string]string) map[string]string {
+	if nil == s {
+		return tags
+	}
+	m := make(map[string]string, len(tags)+len(s.tags))
+	for k, v := range tags {
+		m[k] = v
+	}
+	for _, t := range s.tags {
+		m[t.key] = t.value
+	}
+	return m
+}
+
+var foldedTagEscaper = strings.NewReplacer(".", "_", " ", "_", "\n", "_")
+
+// foldTags returns name followed by the keys and values of tags as path
+// components, sorted by key.
+func foldTags(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteByte('.')
+		b.WriteString(foldedTagEscaper.Replace(k))
+		b.WriteByte('.')
+		b.WriteString(foldedTagEscaper.Replace(tags[k]))
+	}
+	return b.String()
+}
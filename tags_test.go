@@ -0,0 +1,126 @@
+package graphite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// splitLabels extracts labels from names such as "requests{method=GET}".
+func splitLabels(name string, metric interface{}) (string, map[string]string) {
+	i := strings.IndexByte(name, '{')
+	if i < 0 {
+		return name, nil
+	}
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimSuffix(name[i+1:], "}"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			tags[k] = v
+		}
+	}
+	return name[:i], tags
+}
+
+func TestTagExtractor(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "app")
+	defer l.Close()
+
+	c.TagExtractor = splitLabels
+	metrics.GetOrRegisterCounter("requests{method=GET,code=200}", r).Inc(2)
+	metrics.GetOrRegisterCounter("requests{method=POST,code=200}", r).Inc(3)
+	metrics.GetOrRegisterCounter("errors", r).Inc(4)
+
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	for name, expected := range map[string]float64{
+		"app.requests.count;code=200;method=GET":  2,
+		"app.requests.count;code=200;method=POST": 3,
+		"app.errors.count":                        4,
+	} {
+		if found := res[name]; !floatEquals(found, expected) {
+			t.Fatal("bad value of", name, expected, found)
+		}
+	}
+
+	for k := range res {
+		delete(res, k)
+	}
+	c.TagMode = TagModeFolded
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 3.0, res["app.requests.code.200.method.POST.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestTagModeBoth(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("requests.GET", r).Inc(2)
+	metrics.GetOrRegisterCounter("errors", r).Inc(4)
+
+	var b strings.Builder
+	c := GraphiteConfig{
+		Registry:     r,
+		Prefix:       "legacy.app",
+		TaggedPrefix: "app",
+		Sink:         WriterSink(&b),
+		Timestamp:    func() int64 { return 1 },
+		SortedOutput: true,
+		TagMode:      TagModeBoth,
+		TagExtractor: func(name string, _ interface{}) (string, map[string]string) {
+			if i := strings.IndexByte(name, '.'); 0 <= i {
+				return name[:i], map[string]string{"method": name[i+1:]}
+			}
+			return name, nil
+		},
+	}
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	expected := "legacy.app.errors.count 4 1\napp.errors.count 4 1\n" +
+		"legacy.app.requests.GET.count 2 1\napp.requests.count;method=GET 2 1\n"
+	if found := b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+
+	// Both series of a counter are sent before it is reset, once.
+	b.Reset()
+	c.ResetAfterFlush = true
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	requests := metrics.GetOrRegisterCounter("requests.GET", r)
+	if found := b.String(); expected != found || 0 != requests.Count() {
+		t.Fatalf("expected %q and a reset counter, found %q and %d", expected, found, requests.Count())
+	}
+}
+
+func TestTagsMergeWithGlobalTags(t *testing.T) {
+	c := GraphiteConfig{Protocol: ProtocolInflux, Tags: map[string]string{"host": "a", "dc": "east"}}
+	var b bytes.Buffer
+	enc := newEncoder(&b, &c)
+
+	tags := newTagSet(map[string]string{"dc": "west", "method": "GET"})
+	enc.encode([]datapoint{{prefix: "app", name: "requests", field: fieldCounter, ivalue: 3, tags: tags}}, 10)
+
+	if expected, found := "app.requests,dc=west,host=a,method=GET count=3i 10000000000\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestTagEscaping(t *testing.T) {
+	var b bytes.Buffer
+	enc := newEncoder(&b, &GraphiteConfig{})
+	tags := newTagSet(map[string]string{"ke\ty": "a b=c;d\re\x7f", "host": "x\ny"})
+	enc.encode([]datapoint{{prefix: "app", name: "requests", field: fieldCounter, ivalue: 3, tags: tags}}, 10)
+
+	if expected, found := "app.requests.count;host=x_y;ke_y=a_b_c_d_e_ 3 10\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
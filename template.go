@@ -0,0 +1,200 @@
+package graphite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A template is a format string of ExportFormats compiled into the literal
+// text and values it is made of, so that datapoints can be appended to a
+// buffer with strconv instead of fmt, which allocates for every argument.
+type template struct {
+	ops []templateOp
+}
+
+// A templateOp appends literal text followed by one argument of the format
+// string, if arg is not argNone.
+type templateOp struct {
+	lit  string
+	arg  int
+	verb byte // 'd', 's', 'f', 'e' or 'g'
+	prec int  // Precision of float verbs, -1 for the shortest representation
+}
+
+// Arguments of the format strings in ExportFormats, in the order fmt would
+// receive them.
+const (
+	argNone = iota
+	argPrefix
+	argName
+	argKey
+	argValue
+	argTime
+)
+
+// A templateKey identifies a format string along with the arguments it is
+// formatted with, which differ between fields.
+type templateKey struct {
+	format  string
+	integer bool // Whether the value is an integer
+	keyed   bool // Whether the key is passed after the name
+}
+
+// compileTemplate compiles format for the arguments described by k,
+// returning nil if it uses anything beyond plain %s, %d, %f, %e and %g verbs
+// with an optional precision, or doesn't match its arguments, in which case
+// the datapoint has to be formatted by fmt.
+func compileTemplate(k templateKey) *template {
+	args := []int{argPrefix, argName, argValue, argTime}
+	if k.keyed {
+		args = []int{argPrefix, argName, argKey, argValue, argTime}
+	}
+	t := &template{}
+	format, lit := k.format, []byte(nil)
+	for i := 0; i < len(format); i++ {
+		if '%' != format[i] {
+			lit = append(lit, format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return nil
+		}
+		if '%' == format[i] {
+			lit = append(lit, '%')
+			continue
+		}
+		op := templateOp{lit: string(lit), prec: -1}
+		lit = lit[:0]
+		if '.' == format[i] {
+			op.prec = 0
+			for i++; i < len(format) && '0' <= format[i] && format[i] <= '9'; i++ {
+				op.prec = op.prec*10 + int(format[i]-'0')
+			}
+			if i == len(format) {
+				return nil
+			}
+		}
+		if 0 == len(args) {
+			return nil
+		}
+		op.arg, op.verb, args = args[0], format[i], args[1:]
+		switch op.verb {
+		case 's':
+			if argPrefix != op.arg && argName != op.arg && argKey != op.arg {
+				return nil
+			}
+		case 'd':
+			if argTime != op.arg && !(argValue == op.arg && k.integer) {
+				return nil
+			}
+		case 'f', 'e', 'g':
+			if argValue != op.arg || k.integer {
+				return nil
+			}
+			if -1 == op.prec && 'g' != op.verb {
+				op.prec = 6
+			}
+		default:
+			return nil
+		}
+		if 's' == op.verb || 'd' == op.verb {
+			if -1 != op.prec {
+				return nil
+			}
+		}
+		t.ops = append(t.ops, op)
+	}
+	if 0 != len(args) {
+		return nil
+	}
+	if 0 < len(lit) {
+		t.ops = append(t.ops, templateOp{lit: string(lit), arg: argNone})
+	}
+	return t
+}
+
+// append appends dp with the timestamp now to b, rendering float values
+// with the given significant digits instead of the precision of their verbs
+// unless zero. Values of %e and %g verbs are rendered in decimal notation
+// with as many digits as the verb would render.
+func (t *template) append(b []byte, dp *datapoint, now int64, digits int) []byte {
+	for i := range t.ops {
+		op := &t.ops[i]
+		b = append(b, op.lit...)
+		switch op.arg {
+		case argPrefix:
+			b = append(b, dp.prefix...)
+		case argName:
+			b = append(b, dp.name...)
+		case argKey:
+			b = append(b, dp.key...)
+		case argValue:
+			if 'd' == op.verb {
+				b = strconv.AppendInt(b, dp.ivalue, 10)
+			} else if 0 != digits {
+				b = appendDecimal(b, dp.fvalue, digits)
+			} else if 'f' != op.verb {
+				b = appendDecimal(b, dp.fvalue, exponentDigits(rune(op.verb), op.prec))
+			} else {
+				b = strconv.AppendFloat(b, dp.fvalue, 'f', op.prec, 64)
+			}
+		case argTime:
+			b = strconv.AppendInt(b, now, 10)
+		}
+	}
+	return b
+}
+
+// validFormat reports whether fmt renders the arguments described by k with
+// their format string without complaining of bad verbs or arguments.
+func validFormat(k templateKey) bool {
+	var value interface{} = 0.0
+	if k.integer {
+		value = int64(0)
+	}
+	args := []interface{}{"", "", value, int64(0)}
+	if k.keyed {
+		args = []interface{}{"", "", "", value, int64(0)}
+	}
+	return !strings.Contains(fmt.Sprintf(k.format, args...), "%!")
+}
+
+// appendFormat appends dp to b using the given format string, compiling it
+// into a template on first use.
+func (n *namer) appendFormat(b []byte, dp *datapoint, format string, now int64) []byte {
+	k := templateKey{
+		format:  format,
+		integer: dp.field.integer(),
+		keyed:   fieldPercentile == dp.field || fieldBucket == dp.field || fieldCustom == dp.field,
+	}
+	t, ok := n.templates[k]
+	if !ok {
+		if nil == n.templates {
+			n.templates = make(map[templateKey]*template)
+		}
+		t = compileTemplate(k)
+		n.templates[k] = t
+		if nil == t && !validFormat(k) {
+			if nil == n.invalid {
+				n.invalid = make(map[templateKey]bool)
+			}
+			n.invalid[k] = true
+		}
+	}
+	if n.invalid[k] {
+		n.bad = format
+	}
+	if nil != t {
+		return t.append(b, dp, now, n.digits)
+	}
+	var value interface{} = fixedFloat{dp.fvalue, n.digits}
+	if k.integer {
+		value = dp.ivalue
+	}
+	if k.keyed {
+		return fmt.Appendf(b, format, dp.prefix, dp.name, dp.key, value, now)
+	}
+	return fmt.Appendf(b, format, dp.prefix, dp.name, value, now)
+}
@@ -0,0 +1,163 @@
+package graphite
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestTemplateMatchesFmt(t *testing.T) {
+	formats := []string{
+		"%s.%s.count %d %d\n",
+		"%s.%s.mean %.2f %d\n",
+		"%s.%s.%s-percentile %.2f %d\n",
+		"%s.%s.value %f %d\n",
+		"%s.%s.%s %e %d\n",
+		"%s.%s.%s %g %d\n",
+		"100%% %s.%s %.0f %d\n",
+	}
+	for _, f := range []ExportFormatStrings{ExportFormats, OstrichFormats, StatsDFormats, DropwizardFormats} {
+		for i := fieldCounter; i <= fieldCustom; i++ {
+			if format := f.format(i); "" != format {
+				formats = append(formats, format)
+			}
+		}
+	}
+	values := []float64{0, 1.5, -2.25, 1e21, 123456.789, math.NaN(), math.Inf(1), math.Inf(-1)}
+	var n namer
+	for _, format := range formats {
+		for _, f := range []field{fieldCounter, fieldGaugeFloat64, fieldPercentile} {
+			for _, v := range values {
+				dp := datapoint{prefix: "pre", name: "name", field: f, key: "99", ivalue: int64(v), fvalue: v}
+				var value interface{} = fixedFloat{dp.fvalue, 0}
+				if f.integer() {
+					value = dp.ivalue
+				}
+				want := fmt.Sprintf(format, "pre", "name", value, int64(1234))
+				if fieldPercentile == f {
+					want = fmt.Sprintf(format, "pre", "name", "99", value, int64(1234))
+				}
+				if got := string(n.appendFormat(nil, &dp, format, 1234)); got != want {
+					t.Errorf("%q with %v: %q != %q", format, value, got, want)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkPlaintextEncoder(b *testing.B) {
+	c := GraphiteConfig{Percentiles: []float64{0.5, 0.75, 0.95, 0.99, 0.999}}
+	dps := benchmarkDatapoints(&c)
+	enc := newEncoder(io.Discard, &c)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.encode(dps, 1234567890)
+	}
+}
+
+func BenchmarkPlaintextEncoderSuffixMap(b *testing.B) {
+	c := GraphiteConfig{
+		Percentiles: []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+		SuffixMap:   map[string]string{"count": "n", "99-percentile": "p99"},
+	}
+	dps := benchmarkDatapoints(&c)
+	enc := newEncoder(io.Discard, &c)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.encode(dps, 1234567890)
+	}
+}
+
+// benchmarkDatapoints returns the datapoints of a timer as exported by c.
+func benchmarkDatapoints(c *GraphiteConfig) []datapoint {
+	dp := func(f field, i int64, v float64) datapoint {
+		return datapoint{prefix: "app.host", name: "requests.latency", field: f, kind: kindTimer, ivalue: i, fvalue: v}
+	}
+	dps := []datapoint{
+		dp(fieldTimerCount, 12345, 0),
+		dp(fieldMin, 3, 0),
+		dp(fieldMax, 912, 0),
+		dp(fieldMean, 0, 41.25),
+		dp(fieldStddev, 0, 12.5),
+	}
+	for _, p := range c.Percentiles {
+		d := dp(fieldPercentile, 0, p*100)
+		d.key, d.quantile = percentileKey(p, c.PercentileFormat), p
+		dps = append(dps, d)
+	}
+	for i, f := range []field{fieldRate1, fieldRate5, fieldRate15, fieldRateMean} {
+		dps = append(dps, dp(f, 0, float64(i)+0.5))
+	}
+	return dps
+}
+
+func TestPlaintextEncoderAllocs(t *testing.T) {
+	c := GraphiteConfig{Percentiles: []float64{0.5, 0.99}, SuffixMap: map[string]string{"count": "n"}}
+	dps := benchmarkDatapoints(&c)
+	enc := newEncoder(io.Discard, &c)
+	enc.encode(dps, 1)
+	if n := testing.AllocsPerRun(100, func() { enc.encode(dps, 1) }); 0 != n {
+		t.Fatalf("%v allocations per encode", n)
+	}
+}
+
+func TestCachedNamesAllocs(t *testing.T) {
+	c := GraphiteConfig{Percentiles: []float64{0.5, 0.99}, Protocol: ProtocolOpenTSDB, Tags: map[string]string{"host": "a"}}
+	dps := benchmarkDatapoints(&c)
+	enc := newEncoder(io.Discard, &c)
+	enc.encode(dps, 1)
+	if n := testing.AllocsPerRun(100, func() { enc.encode(dps, 1) }); 0 != n {
+		t.Fatalf("%v allocations per encode", n)
+	}
+
+	n := &enc.(*openTSDBEncoder).namer
+	n.sweep()
+	n.sweep()
+	if 0 != len(n.cache.names) {
+		t.Fatal("expected names of series which were not encoded to be dropped:", n.cache.names)
+	}
+}
+
+func TestFloatPrecision(t *testing.T) {
+	for _, tc := range []struct {
+		v      float64
+		digits int
+		want   string
+	}{
+		{1e-7, 3, "0.0000001"},
+		{1.23456e-7, 3, "0.000000123"},
+		{41.256, 3, "41.3"},
+		{-41.256, 2, "-41"},
+		{123456.789, 3, "123457"},
+		{1e21, 3, "1000000000000000000000"},
+		{0, 3, "0"},
+		{1e-7, -1, "0.0000001"},
+		{1.5, 6, "1.5"},
+	} {
+		if got := string(appendDecimal(nil, tc.v, tc.digits)); tc.want != got {
+			t.Errorf("%v with %d digits: %q != %q", tc.v, tc.digits, got, tc.want)
+		}
+	}
+
+	var n namer
+	n.digits = 3
+	dp := datapoint{prefix: "pre", name: "name", field: fieldRate1, fvalue: 1.23456e-7}
+	for _, format := range []string{"%s.%s.rate %.2f %d\n", "%s.%s.rate %e %d\n", "%s.%s.rate %8.2f %d\n"} {
+		if expected, found := "pre.name.rate 0.000000123 1\n", string(n.appendFormat(nil, &dp, format, 1)); expected != found {
+			t.Errorf("%q: expected %q, found %q", format, expected, found)
+		}
+	}
+}
+
+func TestNoExponentForm(t *testing.T) {
+	var n namer
+	dp := datapoint{prefix: "pre", name: "name", field: fieldGaugeFloat64, fvalue: 1.5e21}
+	for _, format := range []string{"%s.%s.value %g %d\n", "%s.%s.value %e %d\n", "%s.%s.value %v %d\n", "%s.%s.value %8.3g %d\n"} {
+		if expected, found := "pre.name.value 1500000000000000000000 1\n", string(n.appendFormat(nil, &dp, format, 1)); expected != found {
+			t.Errorf("%q: expected %q, found %q", format, expected, found)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package graphite
+
+import "time"
+
+// A TimestampedMetric is a metric which knows when its value was observed,
+// such as a gauge holding the last reading scraped from a device. Every
+// metric of a registry implementing it is exported with the timestamp of its
+// observation instead of that of the flush.
+type TimestampedMetric interface {
+	// Timestamp returns the time the value of the metric was observed, or
+	// the zero time if it is to be exported at the time of the flush.
+	Timestamp() time.Time
+}
+
+// metricTime returns the time metric i was observed in seconds since the
+// epoch, or zero if it is not a TimestampedMetric or does not know.
+func metricTime(i interface{}) int64 {
+	m, ok := i.(TimestampedMetric)
+	if !ok {
+		return 0
+	}
+	if t := m.Timestamp(); !t.IsZero() {
+		return t.Unix()
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A Tracer traces the flushes of an Exporter, such as with the spans of a
+// distributed tracing system, so that the time spent shipping metrics shows
+// up in traces, see otelgraphite.NewTracer. StartFlush is called as a flush
+// starts, with the context it was started with and the destination it is
+// sent to, and returns the context the flush is sent within along with the
+// function called with its outcome once it was sent or failed.
+type Tracer interface {
+	StartFlush(ctx context.Context, endpoint string) (context.Context, func(FlushStats))
+}
+
+// endpoint returns the destination flushes are sent to, as given to Tracer.
+func (c *GraphiteConfig) endpoint() string {
+	switch {
+	case nil != c.Sink:
+		return fmt.Sprintf("%T", c.Sink)
+	case TransportStdout == c.Transport, TransportDiscard == c.Transport:
+		return c.Transport
+	case c.overHTTP():
+		return c.URL
+	case 0 != len(c.Destinations):
+		return strings.Join(c.Destinations, ",")
+	}
+	return c.address()
+}
+
+// startTrace starts tracing a flush with Tracer, if set, returning the
+// context it is sent within. The trace ends as recordStatus records the
+// outcome of the flush.
+func (e *Exporter) startTrace(ctx context.Context) context.Context {
+	if nil == e.config.Tracer {
+		return ctx
+	}
+	ctx, e.endTrace = e.config.Tracer.StartFlush(ctx, e.config.endpoint())
+	return ctx
+}
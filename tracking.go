@@ -0,0 +1,130 @@
+package graphite
+
+import (
+	"math"
+	"time"
+)
+
+// A metric identifies a registry metric across flushes.
+type metric struct {
+	prefix, name string
+	tags         string
+}
+
+// An observation records the value of a series seen by a flush.
+type observation struct {
+	ivalue int64
+	fvalue uint64 // Bits of the float value, so that NaN equals NaN
+	flush  uint64
+	at     time.Time
+}
+
+// A failure count records how often a healthcheck has failed.
+type failureCount struct {
+	count int64
+	flush uint64
+}
+
+// An update records when a metric last changed.
+type update struct {
+	at    time.Time
+	flush uint64
+}
+
+// tracksValues reports whether flushes record the values of every series,
+// for the settings which compare them with those of the previous flush.
+func (c *GraphiteConfig) tracksValues() bool {
+	if c.SkipUnchanged || 0 < c.MetricTTL || c.CountersAsRate || ProtocolStatsD == c.Protocol || InvalidLast == c.InvalidValues {
+		return true
+	}
+	for _, p := range c.InvalidValuesByType {
+		if InvalidLast == p {
+			return true
+		}
+	}
+	return false
+}
+
+// counts reports whether values of f are the counts or values of their
+// metric, which change only once it is updated, unlike the rates and the
+// statistics derived from them, such as mean-rate, which change with time.
+func (f field) counts() bool {
+	switch f {
+	case fieldCounter, fieldGauge, fieldGaugeFloat64, fieldHistogramCount, fieldMeterCount, fieldTimerCount, fieldHealthy, fieldHealthErrors, fieldCustom:
+		return true
+	}
+	return false
+}
+
+// observe records the values of dps, the datapoints of the named metric,
+// marking those which are unchanged since the previous flush, how much
+// their integer values changed and how long ago they were observed. It
+// reports whether MetricTTL is enabled and the metric has not been updated
+// for longer, going by its counts and values, see counts, or by any of its
+// series for metrics without any, such as EWMAs.
+func (e *Exporter) observe(prefix, name string, dps []datapoint, now time.Time) (expired bool) {
+	changed, updated, counted := false, false, false
+	for i := range dps {
+		dp := &dps[i]
+		s := dp.series()
+		o := observation{ivalue: dp.ivalue, fvalue: math.Float64bits(dp.fvalue), flush: e.flushes, at: now}
+		prev, ok := e.values[s]
+		dp.unchanged = ok && prev.ivalue == o.ivalue && prev.fvalue == o.fvalue
+		dp.delta = dp.ivalue - prev.ivalue
+		if ok {
+			dp.since = now.Sub(prev.at)
+		}
+		changed = changed || !dp.unchanged
+		if dp.field.counts() {
+			counted, updated = true, updated || !dp.unchanged
+		}
+		e.values[s] = o
+	}
+	if !counted {
+		updated = changed
+	}
+	m := metric{prefix: prefix, name: name, tags: e.tags.key()}
+	u, ok := e.updates[m]
+	if updated || !ok {
+		u.at = now
+	}
+	u.flush = e.flushes
+	e.updates[m] = u
+	return 0 < e.config.MetricTTL && now.Sub(u.at) > e.config.MetricTTL
+}
+
+// healthFailures returns the number of times the named healthcheck has
+// been seen failing, counting this flush if failed is set.
+func (e *Exporter) healthFailures(prefix, name string, failed bool) int64 {
+	m := metric{prefix: prefix, name: name, tags: e.tags.key()}
+	f := e.failures[m]
+	if failed {
+		f.count++
+	}
+	f.flush = e.flushes
+	e.failures[m] = f
+	return f.count
+}
+
+// forget drops the state of series and metrics which were not seen by the
+// recent flushes, so unregistered metrics don't accumulate. State is kept
+// for as many flushes as the longest FlushRule skips, so that metrics which
+// are exported less often keep theirs.
+func (e *Exporter) forget() {
+	retain := uint64(e.config.retainFlushes())
+	for s, o := range e.values {
+		if e.flushes-o.flush >= retain {
+			delete(e.values, s)
+		}
+	}
+	for m, u := range e.updates {
+		if e.flushes-u.flush >= retain {
+			delete(e.updates, m)
+		}
+	}
+	for m, f := range e.failures {
+		if e.flushes-f.flush >= retain {
+			delete(e.failures, m)
+		}
+	}
+}
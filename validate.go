@@ -0,0 +1,109 @@
+package graphite
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Policies of GraphiteConfig.ValidateNames for the names of metrics with
+// characters other than ASCII letters, digits, '-', '_' and '.', which are
+// the ones Graphite reliably stores and queries. Whatever the policy, spaces
+// and control characters such as newlines are replaced with underscores in
+// names and prefixes, as they would split the lines of datapoints.
+const (
+	ValidateNone     = ""         // Export names as they are
+	ValidateReject   = "reject"   // Skip the metric, reporting it by the error of the flush
+	ValidateSkip     = "skip"     // Skip the metric silently
+	ValidateSanitize = "sanitize" // Replace invalid characters with underscores
+)
+
+// validName reports whether every character of name is valid, returning the
+// first invalid one otherwise.
+func validName(name string) (bool, byte) {
+	for i := 0; i < len(name); i++ {
+		if !validNameChar(name[i]) {
+			return false, name[i]
+		}
+	}
+	return true, 0
+}
+
+func validNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || '-' == c || '_' == c || '.' == c
+}
+
+// sanitizeName returns name with its invalid characters replaced by
+// underscores.
+func sanitizeName(name string) string {
+	if ok, _ := validName(name); ok {
+		return name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		if !validNameChar(c) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// escapeName returns name with spaces and control characters such as '\n'
+// and '\r' replaced by underscores, whatever ValidateNames, as they would
+// split its line, corrupting the datapoints next to it.
+func escapeName(name string) string {
+	return replaceBytes(name, splitsLine)
+}
+
+// replaceBytes returns s with the bytes for which escaped is true replaced
+// by underscores, s itself if there are none.
+func replaceBytes(s string, escaped func(byte) bool) string {
+	i := 0
+	for ; i < len(s) && !escaped(s[i]); i++ {
+	}
+	if i == len(s) {
+		return s
+	}
+	b := []byte(s)
+	for ; i < len(b); i++ {
+		if escaped(b[i]) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func splitsLine(c byte) bool {
+	return c <= ' ' || 0x7f == c
+}
+
+// validateName applies ValidateNames to the prefix and name of a metric,
+// returning the ones to export it under, or false if it is to be skipped.
+func (e *Exporter) validateName(prefix, name string) (string, string, bool) {
+	if ValidateSanitize == e.config.ValidateNames {
+		return sanitizeName(prefix), sanitizeName(name), true
+	}
+	full := fullName(prefix, name)
+	ok, c := validName(full)
+	if !ok && ValidateReject == e.config.ValidateNames {
+		e.snapshot.errs = append(e.snapshot.errs, &MetricError{Name: full, Err: fmt.Errorf("invalid character %q in name", c)})
+	}
+	return prefix, name, ok
+}
+
+// limitName applies MaxNameLength to the name of a metric whose full name
+// is longer, returning the name to export it under, or false if it is to be
+// skipped. Either way the metric is reported by the error of the flush.
+func (e *Exporter) limitName(prefix, name string) (string, bool) {
+	c := &e.config
+	full := fullName(prefix, name)
+	room := c.MaxNameLength - (len(full) - len(name)) - 9 // Room for the name before "-" and the hash
+	if !c.TruncateNames || room < 1 {
+		e.snapshot.errs = append(e.snapshot.errs, &MetricError{Name: full, Err: fmt.Errorf("name longer than %d characters", c.MaxNameLength)})
+		return name, false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(full))
+	truncated := fmt.Sprintf("%s-%08x", name[:room], h.Sum32())
+	e.snapshot.errs = append(e.snapshot.errs, &MetricError{Name: full, Err: fmt.Errorf("name longer than %d characters, truncated to '%s'", c.MaxNameLength, truncated)})
+	return truncated, true
+}
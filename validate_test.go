@@ -0,0 +1,106 @@
+package graphite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestValidateNames(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "app")
+	defer l.Close()
+
+	metrics.GetOrRegisterCounter("foo", r).Inc(1)
+	metrics.GetOrRegisterCounter("bad name/with:chars", r).Inc(2)
+
+	c.ValidateNames = ValidateReject
+	wg.Add(1)
+	err := GraphiteOnce(c)
+	wg.Wait()
+
+	var merr *MetricError
+	if !errors.As(err, &merr) || "app.bad name/with:chars" != merr.Name {
+		t.Fatal("expected the invalid name to be reported:", err)
+	}
+	if expected, found := 1.0, res["app.foo.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+	if 1 != len(res) {
+		t.Fatal("expected the invalid name to be skipped:", res)
+	}
+
+	c.ValidateNames = ValidateSkip
+	wg.Add(1)
+	if err := GraphiteOnce(c); nil != err {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	c.ValidateNames = ValidateSanitize
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if expected, found := 2.0, res["app.bad_name_with_chars.count"]; !floatEquals(found, expected) {
+		t.Fatal("bad value:", expected, found)
+	}
+}
+
+func TestEscapeNames(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("foo\nbar 2 1\nbaz", r).Inc(1)
+
+	var b strings.Builder
+	err := GraphiteOnce(GraphiteConfig{
+		Registry:  r,
+		Prefix:    "my app\r",
+		Sink:      WriterSink(&b),
+		Timestamp: func() int64 { return 1 },
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, found := "my_app_.foo_bar_2_1_baz.count 1 1\n", b.String(); expected != found {
+		t.Fatalf("expected %q, found %q", expected, found)
+	}
+}
+
+func TestMaxNameLength(t *testing.T) {
+	res, l, r, c, wg := NewTestServer(t, "app")
+	defer l.Close()
+
+	metrics.GetOrRegisterCounter("short", r).Inc(1)
+	metrics.GetOrRegisterCounter("a.very.long.name.indeed", r).Inc(2)
+
+	c.MaxNameLength = 20
+	wg.Add(1)
+	err := GraphiteOnce(c)
+	wg.Wait()
+
+	var merr *MetricError
+	if !errors.As(err, &merr) || "app.a.very.long.name.indeed" != merr.Name {
+		t.Fatal("expected the long name to be reported:", err)
+	}
+	if 1 != len(res) {
+		t.Fatal("expected the long name to be skipped:", res)
+	}
+
+	c.TruncateNames = true
+	wg.Add(1)
+	GraphiteOnce(c)
+	wg.Wait()
+
+	if 2 != len(res) {
+		t.Fatal("expected the long name to be truncated:", res)
+	}
+	for name := range res {
+		if "app.short.count" == name {
+			continue
+		}
+		if 20 != len(strings.TrimSuffix(name, ".count")) || !strings.HasPrefix(name, "app.a.very.") {
+			t.Fatal("bad truncated name:", name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package graphite
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dt/go-metrics"
+)
+
+// A pendingMetric is a metric of a registry queued to be snapshotted once
+// the workers of EncodeWorkers have computed its datapoints, or once every
+// metric was frozen with PointInTime.
+type pendingMetric struct {
+	prefix, name string
+	metric       interface{}
+	values       interface{} // Snapshot of metric with PointInTime, or metric itself
+	tags         *tagSet
+	dps          []datapoint
+	parallel     bool // Whether the datapoints are computed by a worker
+}
+
+// deferSnapshots reports whether the metrics of registries are queued and
+// snapshotted by snapshotPending once every registry was iterated.
+func (c *GraphiteConfig) deferSnapshots() bool {
+	return c.PointInTime || 1 < c.EncodeWorkers
+}
+
+// queue adds the named metric i to the pending metrics, unless it is not to
+// be exported, frozen with PointInTime. The memory of the datapoints of
+// previous flushes is reused.
+func (e *Exporter) queue(prefix, name string, i interface{}) {
+	prefix, name, ok := e.exportedName(prefix, name)
+	if !ok {
+		return
+	}
+	if len(e.pending) == cap(e.pending) {
+		e.pending = append(e.pending, pendingMetric{})
+	} else {
+		e.pending = e.pending[:len(e.pending)+1]
+	}
+	p := &e.pending[len(e.pending)-1]
+	p.prefix, p.name, p.metric, p.values, p.tags, p.dps = prefix, name, i, i, e.tags, p.dps[:0]
+	if e.config.PointInTime {
+		p.values = freeze(i)
+	}
+	p.parallel = 1 < e.config.EncodeWorkers && parallelizable(i)
+}
+
+// freeze returns a snapshot of the values of i, of go-metrics or of a fork,
+// or i itself if it has none or is exported by an encoder of
+// RegisterEncoder.
+func freeze(i interface{}) interface{} {
+	if _, ok := lookupEncoder(i); ok {
+		return i
+	}
+	switch metric := i.(type) {
+	case metrics.Counter:
+		return metric.Snapshot()
+	case metrics.Gauge:
+		return metric.Snapshot()
+	case metrics.GaugeFloat64:
+		return metric.Snapshot()
+	case metrics.Histogram:
+		return metric.Snapshot()
+	case metrics.Meter:
+		return metric.Snapshot()
+	case metrics.Timer:
+		return metric.Snapshot()
+	case metrics.EWMA:
+		return metric.Snapshot()
+	case foreignCounter, foreignGauge, foreignGaugeFloat64, foreignHistogram, foreignMeter, foreignTimer, foreignEWMA:
+		return snapshotOf(i)
+	}
+	return i
+}
+
+// parallelizable reports whether the datapoints of i may be computed by a
+// worker. Only histograms and timers are, as sorting their samples for
+// percentiles is what makes snapshots slow, and the datapoints of other
+// metrics may depend on the state of the Exporter.
+func parallelizable(i interface{}) bool {
+	if _, ok := lookupEncoder(i); ok {
+		return false
+	}
+	switch i.(type) {
+	case metrics.Histogram, metrics.Timer, foreignHistogram, foreignTimer:
+		return true
+	}
+	return false
+}
+
+// snapshotPending computes the datapoints of the pending metrics, those of
+// histograms and timers on EncodeWorkers goroutines, then snapshots them in
+// the order they were queued.
+func (e *Exporter) snapshotPending(now time.Time) {
+	var wg sync.WaitGroup
+	next := int64(-1)
+	for w := 0; w < e.config.EncodeWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j := int(atomic.AddInt64(&next, 1))
+				if j >= len(e.pending) {
+					return
+				}
+				if p := &e.pending[j]; p.parallel {
+					p.dps = e.appendDatapoints(p.dps, p.prefix, p.name, p.values)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for j := range e.pending {
+		p := &e.pending[j]
+		e.tags = p.tags
+		if !p.parallel {
+			p.dps = e.appendDatapoints(p.dps, p.prefix, p.name, p.values)
+		}
+		e.snapshotDatapoints(p.prefix, p.name, p.metric, p.dps, now)
+	}
+	e.tags = nil
+	for j := range e.pending {
+		e.pending[j].metric, e.pending[j].values, e.pending[j].tags = nil, nil, nil
+	}
+}